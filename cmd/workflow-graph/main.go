@@ -0,0 +1,45 @@
+// Command workflow-graph renders a workflow definition file as a Graphviz DOT or Mermaid graph, optionally
+// colored with the step statuses of a workflow object in the cluster.
+//
+//	workflow-graph [-format dot|mermaid] [-object <name>] [-kubeconfig <path>] <definition file>
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	workflowFramework "github.com/flintdev/workflow-engine/engine"
+	"github.com/flintdev/workflow-engine/util"
+	"os"
+)
+
+func main() {
+	format := flag.String("format", workflowFramework.GraphDOT, "graph format, dot or mermaid")
+	object := flag.String("object", "", "(optional) name of a workflow object whose step statuses are overlaid")
+	kubeconfig := util.GetKubeConfig()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: workflow-graph [-format dot|mermaid] [-object name] [-kubeconfig path] <definition file>")
+		os.Exit(2)
+	}
+	w, err := workflowFramework.ReadWorkflowFile(flag.Arg(0))
+	if err != nil {
+		fail(err)
+	}
+	var statuses map[string]string
+	if *object != "" {
+		statuses, err = util.GetWorkflowObjectStepStatuses(context.Background(), kubeconfig, *object)
+		if err != nil {
+			fail(err)
+		}
+	}
+	graph, err := workflowFramework.WorkflowGraph(w, *format, statuses)
+	if err != nil {
+		fail(err)
+	}
+	fmt.Print(graph)
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err.Error())
+	os.Exit(1)
+}
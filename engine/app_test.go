@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/flintdev/workflow-engine/internal/kubetest"
+	"github.com/flintdev/workflow-engine/util"
+)
+
+// start a fake API server and an app using it, with the workflows registered. Tasks the app spawns run on
+// its worker pool, so settle can wait for them. The returned function stops both.
+func newTestApp(t *testing.T, workflows ...Workflow) (*App, *kubetest.Server, func()) {
+	t.Helper()
+	server, err := kubetest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := CreateApp()
+	app.kubeconfig = server.Kubeconfig()
+	ctx, cancel := context.WithCancel(context.Background())
+	app.ctx = ctx
+	app.pool = newWorkerPool(ConcurrencyConfig{})
+	for _, w := range workflows {
+		w := w
+		app.RegisterWorkflow(func() Workflow { return w })
+	}
+	return &app, server, func() {
+		cancel()
+		app.pool.stop()
+		server.Close()
+	}
+}
+
+// wait for the tasks the app has spawned to finish.
+func settle(t *testing.T, app *App) {
+	t.Helper()
+	if !app.pool.wait(5 * time.Second) {
+		t.Fatal("spawned tasks did not finish")
+	}
+}
+
+// add a workflow object of a registered workflow with the given step entries and flowData.
+func addWorkflowObject(server *kubetest.Server, app *App, objName string, workflowName string, status string, flowData string, steps ...map[string]interface{}) {
+	wi := app.getWorkflowInstance(workflowName)
+	entries := make([]interface{}, 0, len(steps))
+	for _, step := range steps {
+		entry := map[string]interface{}{"startAt": "2020-01-01 00:00:00 +0000 UTC", "endAt": "", "message": ""}
+		for k, v := range step {
+			entry[k] = v
+		}
+		entries = append(entries, entry)
+	}
+	if flowData == "" {
+		flowData = "{}"
+	}
+	server.Add(util.WFResource, map[string]interface{}{
+		"apiVersion": util.WFGroup + "/" + util.WFVersion,
+		"kind":       "WorkFlow",
+		"metadata": map[string]interface{}{
+			"name":   objName,
+			"labels": map[string]interface{}{"workflowName": workflowName},
+		},
+		"spec": map[string]interface{}{
+			"steps":          entries,
+			"flowData":       flowData,
+			"status":         status,
+			"message":        "",
+			"definitionHash": wi.hash,
+		},
+	})
+}
+
+// get the step entries of a workflow object.
+func workflowObjectSteps(t *testing.T, server *kubetest.Server, objName string) []map[string]interface{} {
+	t.Helper()
+	obj := server.Get(util.WFResource, objName)
+	if obj == nil {
+		t.Fatalf("workflow object %s does not exist", objName)
+	}
+	var steps []map[string]interface{}
+	for _, s := range obj["spec"].(map[string]interface{})["steps"].([]interface{}) {
+		steps = append(steps, s.(map[string]interface{}))
+	}
+	return steps
+}
+
+// get a spec field of a workflow object.
+func workflowObjectSpec(t *testing.T, server *kubetest.Server, objName string, field string) interface{} {
+	t.Helper()
+	obj := server.Get(util.WFResource, objName)
+	if obj == nil {
+		t.Fatalf("workflow object %s does not exist", objName)
+	}
+	return obj["spec"].(map[string]interface{})[field]
+}
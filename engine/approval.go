@@ -0,0 +1,270 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/flintdev/workflow-engine/util"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ApprovalConfig describes who decides an approval step and how many approvals it needs. Approvers are user
+// names or "group:<name>" entries; an empty list lets anyone decide. Quorum is "any" (the default), "all", or
+// a number N of approvals. The fields are JSON paths into the model object that carry the approver, the
+// approver's comma separated groups, and the decision of a model event.
+type ApprovalConfig struct {
+	Approvers     []string `json:"approvers"`
+	Quorum        string   `json:"quorum"`
+	ApproverField string   `json:"approverField"`
+	GroupsField   string   `json:"groupsField"`
+	DecisionField string   `json:"decisionField"`
+}
+
+// Decision is one approver's answer to an approval step. Decision is "approve" or "reject". The approver's
+// groups are not part of a decision: they are resolved by App.ApproverGroups, or read from the model object
+// of a decision made through a model event.
+type Decision struct {
+	ObjName  string `json:"objName"`
+	Step     string `json:"step"`
+	Approver string `json:"approver"`
+	Decision string `json:"decision"`
+	Comment  string `json:"comment"`
+}
+
+const approvalApproved = "Approved"
+const approvalRejected = "Rejected"
+
+// DecideApproval records an approver's decision on a pending approval step. The step follows its nextSteps
+// once the quorum is reached, and its onReject steps as soon as an approver rejects; without onReject steps a
+// rejection fails the step.
+func (app *App) DecideApproval(ctx context.Context, wfObjName string, stepName string, d Decision) error {
+	logger, _ := zap.NewProduction()
+	defer logger.Sync()
+	wi, err := app.getWorkflowInstanceOfObject(ctx, wfObjName)
+	if err != nil {
+		return err
+	}
+	var groups []string
+	if app.ApproverGroups != nil {
+		groups, err = app.ApproverGroups(ctx, d.Approver)
+		if err != nil {
+			return err
+		}
+	}
+	return wi.decideApproval(app.ctx, app.kubeconfig, logger, wfObjName, stepName, d, groups)
+}
+
+func (wi *WorkflowInstance) decideApproval(ctx context.Context, kubeconfig *string, logger *zap.Logger, wfObjName string, stepName string, d Decision, groups []string) error {
+	step, exist := wi.Workflow.Steps[stepName]
+	if !exist || step.Type != "approval" {
+		message := fmt.Sprintf("step %s is not an approval step of workflow %s", stepName, wi.Workflow.Name)
+		return errors.New(message)
+	}
+	decision, err := normalizeDecision(d.Decision)
+	if err != nil {
+		return err
+	}
+	if !step.Approval.isApprover(d.Approver, groups) {
+		message := fmt.Sprintf("%s is not an approver of step %s", d.Approver, stepName)
+		return errors.New(message)
+	}
+	var groupList []interface{}
+	for _, g := range groups {
+		groupList = append(groupList, g)
+	}
+	entry := map[string]interface{}{
+		"approver": d.Approver,
+		"groups":   groupList,
+		"decision": decision,
+		"comment":  d.Comment,
+		"at":       time.Now().UTC().Format(time.RFC3339),
+	}
+	outcome, executionID, err := util.RecordWorkflowObjectStepDecision(ctx, kubeconfig, wfObjName, stepName, entry, step.Approval.outcome)
+	if err != nil {
+		return err
+	}
+	ctx = util.WithExecution(ctx, executionID, "")
+	logInfo(logger, wfObjName, stepName, fmt.Sprintf("Recorded %s decision of %s", decision, d.Approver))
+	if outcome == "" {
+		return nil
+	}
+	logInfo(logger, wfObjName, stepName, fmt.Sprintf("Approval step is %s", strings.ToLower(outcome)))
+	h := newHandler(kubeconfig, wfObjName)
+	candidates := step.NextSteps
+	if outcome == approvalRejected {
+		if len(step.OnReject) == 0 {
+			message := fmt.Sprintf("rejected by %s", d.Approver)
+			wi.failStep(ctx, kubeconfig, logger, wfObjName, stepName, message)
+			return nil
+		}
+		candidates = step.OnReject
+	}
+	nextSteps, err := matchNextSteps(ctx, candidates, h, nil)
+	if err != nil {
+		wi.failStep(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
+		return nil
+	}
+	wi.ExecuteWorkflow(ctx, kubeconfig, logger, h, wfObjName, []string{stepName}, true, nextSteps)
+	return nil
+}
+
+func normalizeDecision(decision string) (string, error) {
+	switch strings.ToLower(decision) {
+	case "approve", "approved", "true":
+		return "approve", nil
+	case "reject", "rejected", "false":
+		return "reject", nil
+	}
+	message := fmt.Sprintf("unknown decision %s, expected approve or reject", decision)
+	return "", errors.New(message)
+}
+
+func (c ApprovalConfig) isApprover(approver string, groups []string) bool {
+	if approver == "" {
+		return false
+	}
+	if len(c.Approvers) == 0 {
+		return true
+	}
+	for _, a := range c.Approvers {
+		if a == approver {
+			return true
+		}
+		for _, g := range groups {
+			if a == "group:"+g {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// evaluate the decisions recorded on an approval step. It returns approvalApproved, approvalRejected, or an
+// empty string while the quorum is not reached.
+func (c ApprovalConfig) outcome(decisions []interface{}) string {
+	var approvals []map[string]interface{}
+	for _, d := range decisions {
+		m, ok := d.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch m["decision"] {
+		case "reject":
+			return approvalRejected
+		case "approve":
+			approvals = append(approvals, m)
+		}
+	}
+	switch c.Quorum {
+	case "", "any":
+		if len(approvals) >= 1 {
+			return approvalApproved
+		}
+	case "all":
+		if len(c.Approvers) == 0 && len(approvals) >= 1 {
+			return approvalApproved
+		}
+		for _, a := range c.Approvers {
+			if !approvedBy(a, approvals) {
+				return ""
+			}
+		}
+		if len(c.Approvers) > 0 {
+			return approvalApproved
+		}
+	default:
+		n, err := strconv.Atoi(c.Quorum)
+		if err == nil && len(approvals) >= n {
+			return approvalApproved
+		}
+	}
+	return ""
+}
+
+// check whether an approver entry is covered by one of the approvals.
+func approvedBy(approver string, approvals []map[string]interface{}) bool {
+	for _, m := range approvals {
+		if m["approver"] == approver {
+			return true
+		}
+		groups, _ := m["groups"].([]interface{})
+		for _, g := range groups {
+			if approver == fmt.Sprintf("group:%v", g) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// record the decision carried by a model event on the workflow objects pending on the approval step.
+func handleApprovalTrigger(ctx context.Context, wi WorkflowInstance, kubeconfig *string, logger *zap.Logger, stepName string, objName string, e Event) {
+	step := wi.Workflow.Steps[stepName]
+	result, err := ParseTrigger(step.StepTrigger, e)
+	if err != nil || !result {
+		return
+	}
+	var d Decision
+	d.Approver, err = getFiledValueByJsonPath(e, step.Approval.ApproverField)
+	if err != nil {
+		logger.Warn(err.Error(), zap.String("Name", e.Name), zap.String("step name", stepName))
+		return
+	}
+	d.Decision, err = getFiledValueByJsonPath(e, step.Approval.DecisionField)
+	if err != nil {
+		logger.Warn(err.Error(), zap.String("Name", e.Name), zap.String("step name", stepName))
+		return
+	}
+	var groups []string
+	if step.Approval.GroupsField != "" {
+		value, err := getFiledValueByJsonPath(e, step.Approval.GroupsField)
+		if err == nil && value != "" {
+			for _, g := range strings.Split(value, ",") {
+				groups = append(groups, strings.TrimSpace(g))
+			}
+		}
+	}
+	objList, err := util.GetPendingWorkflowList(ctx, kubeconfig, objName, stepName)
+	if err != nil {
+		logger.Error(err.Error(), zap.String("Name", e.Name), zap.String("step name", stepName))
+		return
+	}
+	for _, obj := range objList.Items {
+		wfObjName := obj.GetName()
+		if !wi.runs(&obj) {
+			continue
+		}
+		err := wi.decideApproval(ctx, kubeconfig, logger, wfObjName, stepName, d, groups)
+		if err != nil {
+			logInfo(logger, wfObjName, stepName, err.Error())
+		}
+	}
+}
+
+func (app *App) handleDecision(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeCallbackResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var d Decision
+	err := json.NewDecoder(r.Body).Decode(&d)
+	if err != nil {
+		writeCallbackResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if d.ObjName == "" || d.Step == "" || d.Approver == "" {
+		writeCallbackResponse(w, http.StatusBadRequest, "objName, step and approver are required")
+		return
+	}
+	err = app.DecideApproval(app.ctx, d.ObjName, d.Step, d)
+	if err != nil {
+		writeCallbackResponse(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeCallbackResponse(w, http.StatusOK, "")
+}
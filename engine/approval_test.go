@@ -0,0 +1,89 @@
+package engine
+
+import "testing"
+
+func TestApprovalConfigIsApprover(t *testing.T) {
+	tests := []struct {
+		name      string
+		approvers []string
+		approver  string
+		groups    []string
+		want      bool
+	}{
+		{name: "anyone", approver: "alice", want: true},
+		{name: "no approver", approvers: []string{"alice"}, want: false},
+		{name: "listed user", approvers: []string{"alice", "bob"}, approver: "bob", want: true},
+		{name: "unlisted user", approvers: []string{"alice"}, approver: "mallory", want: false},
+		{name: "group member", approvers: []string{"group:finance"}, approver: "carol", groups: []string{"finance"}, want: true},
+		{name: "other group", approvers: []string{"group:finance"}, approver: "carol", groups: []string{"sales"}, want: false},
+		{name: "group name is not a user", approvers: []string{"group:finance"}, approver: "finance", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := ApprovalConfig{Approvers: tt.approvers}
+			if got := c.isApprover(tt.approver, tt.groups); got != tt.want {
+				t.Errorf("isApprover(%s, %v) = %v, want %v", tt.approver, tt.groups, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApprovalConfigOutcome(t *testing.T) {
+	approve := func(approver string, groups ...interface{}) interface{} {
+		return map[string]interface{}{"approver": approver, "decision": "approve", "groups": groups}
+	}
+	reject := func(approver string) interface{} {
+		return map[string]interface{}{"approver": approver, "decision": "reject"}
+	}
+	tests := []struct {
+		name      string
+		approvers []string
+		quorum    string
+		decisions []interface{}
+		want      string
+	}{
+		{name: "no decisions", want: ""},
+		{name: "any approval", decisions: []interface{}{approve("alice")}, want: approvalApproved},
+		{name: "explicit any", quorum: "any", decisions: []interface{}{approve("alice")}, want: approvalApproved},
+		{name: "rejection wins", quorum: "any", decisions: []interface{}{approve("alice"), reject("bob")}, want: approvalRejected},
+		{name: "all pending", approvers: []string{"alice", "bob"}, quorum: "all", decisions: []interface{}{approve("alice")}, want: ""},
+		{name: "all approved", approvers: []string{"alice", "bob"}, quorum: "all", decisions: []interface{}{approve("bob"), approve("alice")}, want: approvalApproved},
+		{name: "all with a group", approvers: []string{"alice", "group:finance"}, quorum: "all", decisions: []interface{}{approve("alice"), approve("carol", "finance")}, want: approvalApproved},
+		{name: "all without approvers", quorum: "all", decisions: []interface{}{approve("alice")}, want: approvalApproved},
+		{name: "quorum pending", approvers: []string{"alice", "bob", "carol"}, quorum: "2", decisions: []interface{}{approve("alice")}, want: ""},
+		{name: "quorum reached", approvers: []string{"alice", "bob", "carol"}, quorum: "2", decisions: []interface{}{approve("alice"), approve("carol")}, want: approvalApproved},
+		{name: "unknown decision ignored", quorum: "1", decisions: []interface{}{map[string]interface{}{"approver": "alice", "decision": "maybe"}}, want: ""},
+		{name: "invalid quorum never approves", quorum: "some", decisions: []interface{}{approve("alice")}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := ApprovalConfig{Approvers: tt.approvers, Quorum: tt.quorum}
+			if got := c.outcome(tt.decisions); got != tt.want {
+				t.Errorf("outcome = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeDecision(t *testing.T) {
+	tests := []struct {
+		decision string
+		want     string
+		wantErr  bool
+	}{
+		{decision: "approve", want: "approve"},
+		{decision: "Approved", want: "approve"},
+		{decision: "true", want: "approve"},
+		{decision: "REJECT", want: "reject"},
+		{decision: "false", want: "reject"},
+		{decision: "maybe", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.decision, func(t *testing.T) {
+			got, err := normalizeDecision(tt.decision)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("normalizeDecision(%s) = %q, %v, want %q, error %v", tt.decision, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
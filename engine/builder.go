@@ -0,0 +1,227 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// WorkflowBuilder builds a Workflow in Go code:
+//
+//	w, err := engine.NewWorkflow("expense").
+//		Trigger("expense", "MODIFIED", "'spec.switch' == 'true'").
+//		Step("submit").Automation().Next("approve").
+//		Step("approve").Manual("expense", "MODIFIED", "").Next("done").When("'spec.approval' == 'true'").
+//		Step("done").End("Approved").
+//		Build()
+//
+// Mistakes such as undefined steps are collected while building and reported by Build, together with the
+// problems ValidateWorkflow finds.
+type WorkflowBuilder struct {
+	workflow Workflow
+	steps    map[string]*Step
+	order    []string
+	problems []string
+}
+
+// StepBuilder configures one step of a WorkflowBuilder. Step starts the next step, and Build builds the workflow.
+type StepBuilder struct {
+	*WorkflowBuilder
+	name string
+	step *Step
+	// the edge list When applies to.
+	edges *[]NextStep
+}
+
+// NewWorkflow starts building the workflow with the given name. Unless StartAt is called, the workflow starts
+// at the first step.
+func NewWorkflow(name string) *WorkflowBuilder {
+	return &WorkflowBuilder{
+		workflow: Workflow{Name: name},
+		steps:    make(map[string]*Step),
+	}
+}
+
+func (b *WorkflowBuilder) Version(version string) *WorkflowBuilder {
+	b.workflow.Version = version
+	return b
+}
+
+// Trigger sets the model event that starts the workflow. when may be empty.
+func (b *WorkflowBuilder) Trigger(model string, eventType string, when string) *WorkflowBuilder {
+	b.workflow.Trigger = TriggerCondition{Model: model, EventType: eventType, When: when}
+	return b
+}
+
+func (b *WorkflowBuilder) StartAt(steps ...string) *WorkflowBuilder {
+	b.workflow.StartAt = steps
+	return b
+}
+
+func (b *WorkflowBuilder) MaxConcurrency(n int) *WorkflowBuilder {
+	b.workflow.MaxConcurrency = n
+	return b
+}
+
+// Step adds a step. Its type is set by the next call, such as Automation or Manual.
+func (b *WorkflowBuilder) Step(name string) *StepBuilder {
+	if _, exist := b.steps[name]; exist {
+		b.problems = append(b.problems, fmt.Sprintf("steps.%s: is defined twice", name))
+	} else {
+		b.order = append(b.order, name)
+	}
+	step := &Step{}
+	b.steps[name] = step
+	return &StepBuilder{WorkflowBuilder: b, name: name, step: step}
+}
+
+// Build returns the workflow, or an error listing every problem found while building and validating it.
+func (b *WorkflowBuilder) Build() (Workflow, error) {
+	w := b.workflow
+	if len(w.StartAt) == 0 && len(b.order) > 0 {
+		w.StartAt = []string{b.order[0]}
+	}
+	w.Steps = make(map[string]Step)
+	for name, step := range b.steps {
+		w.Steps[name] = *step
+	}
+	problems := b.problems
+	if err := ValidateWorkflow(w); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if len(problems) > 0 {
+		message := fmt.Sprintf("cannot build workflow %s: %s", w.Name, strings.Join(problems, "; "))
+		return w, errors.New(message)
+	}
+	return w, nil
+}
+
+// MustBuild is like Build but panics on an invalid workflow, which suits definitions that are fixed in code:
+//
+//	app.RegisterWorkflow(builder.MustBuild)
+func (b *WorkflowBuilder) MustBuild() Workflow {
+	w, err := b.Build()
+	if err != nil {
+		panic(err)
+	}
+	return w
+}
+
+func (s *StepBuilder) Automation() *StepBuilder {
+	s.step.Type = "automation"
+	return s
+}
+
+// Manual makes the step wait for a model event. The condition of each next step is evaluated on the event.
+func (s *StepBuilder) Manual(model string, eventType string, when string) *StepBuilder {
+	s.step.Type = "manual"
+	s.step.StepTrigger = TriggerCondition{Model: model, EventType: eventType, When: when}
+	return s
+}
+
+// Approval makes the step wait for approver decisions, taken from model events matching the trigger.
+func (s *StepBuilder) Approval(model string, eventType string, when string, config ApprovalConfig) *StepBuilder {
+	s.step.Type = "approval"
+	s.step.StepTrigger = TriggerCondition{Model: model, EventType: eventType, When: when}
+	s.step.Approval = config
+	return s
+}
+
+// Hub makes the step join its inputs with one of the Join conditions. A JoinNOfM hub needs JoinCount.
+func (s *StepBuilder) Hub(condition string, inputs ...string) *StepBuilder {
+	s.step.Type = "hub"
+	s.step.Condition = condition
+	s.step.Inputs = inputs
+	return s
+}
+
+func (s *StepBuilder) JoinCount(n int) *StepBuilder {
+	s.step.JoinCount = n
+	return s
+}
+
+func (s *StepBuilder) Wait(config WaitConfig) *StepBuilder {
+	s.step.Type = "wait"
+	s.step.Wait = config
+	return s
+}
+
+func (s *StepBuilder) SubWorkflow(config SubWorkflowConfig) *StepBuilder {
+	s.step.Type = "subworkflow"
+	s.step.SubWorkflow = config
+	return s
+}
+
+func (s *StepBuilder) Map(config MapConfig) *StepBuilder {
+	s.step.Type = "map"
+	s.step.Map = config
+	return s
+}
+
+func (s *StepBuilder) Loop(config LoopConfig) *StepBuilder {
+	s.step.Type = "loop"
+	s.step.Loop = config
+	return s
+}
+
+// End makes the step end its branch with the given outcome, which may be empty.
+func (s *StepBuilder) End(outcome string) *StepBuilder {
+	s.step.Type = "end"
+	s.step.End.Outcome = outcome
+	return s
+}
+
+// Result maps a key of the workflow result of an end step to the flowData key it is read from.
+func (s *StepBuilder) Result(key string, flowDataKey string) *StepBuilder {
+	if s.step.End.Result == nil {
+		s.step.End.Result = make(map[string]string)
+	}
+	s.step.End.Result[key] = flowDataKey
+	return s
+}
+
+func (s *StepBuilder) HeartbeatTimeout(timeout string) *StepBuilder {
+	s.step.HeartbeatTimeout = timeout
+	return s
+}
+
+func (s *StepBuilder) Compensation(step string) *StepBuilder {
+	s.step.Compensation = step
+	return s
+}
+
+// Next adds a next step. A following When sets its condition.
+func (s *StepBuilder) Next(step string) *StepBuilder {
+	return s.edge(&s.step.NextSteps, step)
+}
+
+// OnFailure adds a step taken when the step fails. A following When sets its condition.
+func (s *StepBuilder) OnFailure(step string) *StepBuilder {
+	return s.edge(&s.step.OnFailure, step)
+}
+
+// OnReject adds a step an approval step takes when it is rejected. A following When sets its condition.
+func (s *StepBuilder) OnReject(step string) *StepBuilder {
+	return s.edge(&s.step.OnReject, step)
+}
+
+// OnUnsatisfied adds a step a hub takes when its join cannot be satisfied. A following When sets its condition.
+func (s *StepBuilder) OnUnsatisfied(step string) *StepBuilder {
+	return s.edge(&s.step.OnUnsatisfied, step)
+}
+
+// When sets the condition of the step added last by Next, OnFailure, OnReject or OnUnsatisfied.
+func (s *StepBuilder) When(condition string) *StepBuilder {
+	if s.edges == nil || len(*s.edges) == 0 {
+		s.problems = append(s.problems, fmt.Sprintf("steps.%s: When %s does not follow a next step", s.name, condition))
+		return s
+	}
+	(*s.edges)[len(*s.edges)-1].When = condition
+	return s
+}
+
+func (s *StepBuilder) edge(edges *[]NextStep, step string) *StepBuilder {
+	*edges = append(*edges, NextStep{Name: step})
+	s.edges = edges
+	return s
+}
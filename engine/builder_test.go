@@ -0,0 +1,138 @@
+package engine
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWorkflowBuilderBuild(t *testing.T) {
+	tests := []struct {
+		name    string
+		build   func() *WorkflowBuilder
+		want    Workflow
+		wantErr []string
+	}{
+		{
+			name: "valid",
+			build: func() *WorkflowBuilder {
+				return NewWorkflow("expense").Version("2").
+					Trigger("expense", "MODIFIED", "").
+					Step("submit").Automation().Next("approve").
+					Step("approve").Manual("expense", "MODIFIED", "").Next("done").When("'spec.approval' == 'true'").
+					Step("done").End("Approved").WorkflowBuilder
+			},
+			want: Workflow{
+				Name:    "expense",
+				Version: "2",
+				StartAt: []string{"submit"},
+				Trigger: TriggerCondition{Model: "expense", EventType: "MODIFIED"},
+				Steps: map[string]Step{
+					"submit": {Type: "automation", NextSteps: []NextStep{{Name: "approve"}}},
+					"approve": {
+						Type:        "manual",
+						StepTrigger: TriggerCondition{Model: "expense", EventType: "MODIFIED"},
+						NextSteps:   []NextStep{{Name: "done", When: "'spec.approval' == 'true'"}},
+					},
+					"done": {Type: "end", End: EndConfig{Outcome: "Approved"}},
+				},
+			},
+		},
+		{
+			name: "explicit start steps",
+			build: func() *WorkflowBuilder {
+				return NewWorkflow("w").Trigger("model1", "ADDED", "").StartAt("b").
+					Step("a").Automation().
+					Step("b").Automation().Next("a").WorkflowBuilder
+			},
+			want: Workflow{
+				Name:    "w",
+				StartAt: []string{"b"},
+				Trigger: TriggerCondition{Model: "model1", EventType: "ADDED"},
+				Steps: map[string]Step{
+					"a": {Type: "automation"},
+					"b": {Type: "automation", NextSteps: []NextStep{{Name: "a"}}},
+				},
+			},
+		},
+		{
+			name: "step defined twice",
+			build: func() *WorkflowBuilder {
+				return NewWorkflow("w").Trigger("model1", "ADDED", "").
+					Step("a").Automation().
+					Step("a").End("").WorkflowBuilder
+			},
+			wantErr: []string{"cannot build workflow w", "steps.a: is defined twice"},
+		},
+		{
+			name: "when without next step",
+			build: func() *WorkflowBuilder {
+				return NewWorkflow("w").Trigger("model1", "ADDED", "").
+					Step("a").Automation().When("x == 1").WorkflowBuilder
+			},
+			wantErr: []string{"steps.a: When x == 1 does not follow a next step"},
+		},
+		{
+			name: "undefined next step",
+			build: func() *WorkflowBuilder {
+				return NewWorkflow("w").Trigger("model1", "ADDED", "").
+					Step("a").Automation().Next("b").OnFailure("c").WorkflowBuilder
+			},
+			wantErr: []string{"steps.a.nextSteps[0].name: step b is not defined", "steps.a.onFailure[0].name: step c is not defined"},
+		},
+		{
+			name: "no steps",
+			build: func() *WorkflowBuilder {
+				return NewWorkflow("w").Trigger("model1", "ADDED", "")
+			},
+			wantErr: []string{"startAt: needs at least one step"},
+		},
+		{
+			name: "missing trigger",
+			build: func() *WorkflowBuilder {
+				return NewWorkflow("w").Step("a").Automation().WorkflowBuilder
+			},
+			wantErr: []string{"trigger.model: is required"},
+		},
+		{
+			name: "hub without join count",
+			build: func() *WorkflowBuilder {
+				return NewWorkflow("w").Trigger("model1", "ADDED", "").
+					Step("a").Automation().Next("join").
+					Step("join").Hub(JoinNOfM, "a").WorkflowBuilder
+			},
+			wantErr: []string{"steps.join.joinCount: must be between 1 and the number of inputs"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := tt.build().Build()
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if !reflect.DeepEqual(w, tt.want) {
+					t.Errorf("Build() = %+v, want %+v", w, tt.want)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("got no error, want %q", tt.wantErr)
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("got error %v, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWorkflowBuilderMustBuild(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("MustBuild did not panic on an invalid workflow")
+		}
+	}()
+	NewWorkflow("w").MustBuild()
+}
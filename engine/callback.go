@@ -0,0 +1,141 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/flintdev/workflow-engine/util"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+// CallbackRequest is sent by an asynchronous executor to complete or fail a step it accepted. ExecutionID
+// echoes the executionId of the executor request; without it the latest execution of the step is addressed.
+// ItemIndex echoes the itemIndex of a map step item, which is completed with Result instead.
+type CallbackRequest struct {
+	ObjName     string            `json:"objName"`
+	Step        string            `json:"step"`
+	ExecutionID string            `json:"executionId"`
+	ItemIndex   *int              `json:"itemIndex"`
+	TaskToken   string            `json:"taskToken"`
+	Status      string            `json:"status"`
+	Message     string            `json:"message"`
+	ErrorClass  string            `json:"errorClass"`
+	Result      string            `json:"result"`
+	Outputs     map[string]string `json:"outputs"`
+}
+
+type callbackResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+func (app *App) registerHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/callback", app.handleCallback)
+	mux.HandleFunc("/heartbeat", app.handleHeartbeat)
+	mux.HandleFunc("/signal", app.handleSignal)
+	mux.HandleFunc("/decision", app.handleDecision)
+}
+
+func (app *App) handleCallback(w http.ResponseWriter, r *http.Request) {
+	ctx := app.ctx
+	logger, _ := zap.NewProduction()
+	defer logger.Sync()
+	if r.Method != http.MethodPost {
+		writeCallbackResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var c CallbackRequest
+	err := json.NewDecoder(r.Body).Decode(&c)
+	if err != nil {
+		writeCallbackResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if c.ObjName == "" || c.Step == "" || c.TaskToken == "" {
+		writeCallbackResponse(w, http.StatusBadRequest, "objName, step and taskToken are required")
+		return
+	}
+	// the token is consumed by the claim below, so a status the engine does not know must not reach it.
+	if c.Status != "success" && c.Status != "failure" {
+		writeCallbackResponse(w, http.StatusBadRequest, "status must be success or failure")
+		return
+	}
+	if c.ExecutionID != "" {
+		ctx = util.WithExecution(ctx, c.ExecutionID, "")
+	}
+	obj, err := util.GetObj(ctx, app.kubeconfig, util.WFNamespace, util.WFGroup, util.WFVersion, util.WFResource, c.ObjName)
+	if err != nil {
+		writeCallbackResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	wi, err := app.getWorkflowInstanceOf(obj)
+	if err != nil {
+		writeCallbackResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if c.ItemIndex != nil {
+		app.handleMapItemCallback(ctx, w, logger, wi, c)
+		return
+	}
+	executionID, err := util.ClaimWorkflowObjectStepTaskToken(ctx, app.kubeconfig, c.ObjName, c.Step, c.TaskToken)
+	if err != nil {
+		writeCallbackResponse(w, http.StatusConflict, err.Error())
+		return
+	}
+	// a callback without an executionId was matched by its task token.
+	ctx = util.WithExecution(ctx, executionID, "")
+	if len(c.Outputs) > 0 {
+		err = util.SetWorkflowObjectFlowDataValues(ctx, app.kubeconfig, c.ObjName, c.Outputs)
+		if err != nil {
+			logError(logger, c.ObjName, c.Step, err.Error())
+			wi.failStep(ctx, app.kubeconfig, logger, c.ObjName, c.Step, err.Error())
+			writeCallbackResponse(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	writeCallbackResponse(w, http.StatusOK, "")
+
+	logInfo(logger, c.ObjName, c.Step, "received executor callback")
+	h := newHandler(app.kubeconfig, c.ObjName)
+	ctx = wi.instanceContext(ctx, c.ObjName)
+	wi.spawn(func() {
+		wi.handleExecutorResponse(ctx, app.kubeconfig, logger, c.ObjName, c.Step, h, ExecutorResponse{Status: c.Status, Message: c.Message, ErrorClass: c.ErrorClass})
+	})
+}
+
+// complete or fail the map step item a callback addresses.
+func (app *App) handleMapItemCallback(ctx context.Context, w http.ResponseWriter, logger *zap.Logger, wi *WorkflowInstance, c CallbackRequest) {
+	index := *c.ItemIndex
+	executionID, err := util.ClaimWorkflowObjectMapItemTaskToken(ctx, app.kubeconfig, c.ObjName, c.Step, index, c.TaskToken)
+	if err != nil {
+		writeCallbackResponse(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeCallbackResponse(w, http.StatusOK, "")
+
+	logInfo(logger, c.ObjName, c.Step, fmt.Sprintf("received executor callback for item %d", index))
+	ctx = wi.instanceContext(util.WithExecution(ctx, executionID, ""), c.ObjName)
+	h := newHandler(app.kubeconfig, c.ObjName)
+	status := "Complete"
+	if c.Status != "success" {
+		status = "Failure"
+	}
+	wi.spawn(func() {
+		wi.finishMapItem(ctx, app.kubeconfig, logger, c.ObjName, c.Step, h, index, status, c.Result, c.Message)
+	})
+}
+
+func writeCallbackResponse(w http.ResponseWriter, code int, message string) {
+	s := callbackResponse{Status: "accepted", Message: message}
+	if code != http.StatusOK {
+		s.Status = "rejected"
+	}
+	js, err := json.Marshal(s)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(js)
+}
@@ -0,0 +1,115 @@
+package engine
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegisterHandlersPerRun(t *testing.T) {
+	app := CreateApp()
+	// registering on a fresh mux per run must not panic on duplicate patterns.
+	for run := 0; run < 2; run++ {
+		mux := http.NewServeMux()
+		app.registerHandlers(mux)
+		for _, path := range []string{"/callback", "/heartbeat", "/signal", "/decision"} {
+			_, pattern := mux.Handler(httptest.NewRequest(http.MethodPost, path, nil))
+			if pattern != path {
+				t.Errorf("run %d: %s is routed to %q", run, path, pattern)
+			}
+		}
+	}
+}
+
+func TestHandleCallback(t *testing.T) {
+	w := Workflow{
+		Name:    "workflow1",
+		StartAt: []string{"step1"},
+		Trigger: TriggerCondition{Model: "model1", EventType: "added"},
+		Steps:   map[string]Step{"step1": {}},
+	}
+	running := map[string]interface{}{"name": "step1", "id": "e1", "status": "Running", "taskToken": "t1"}
+	tests := []struct {
+		name         string
+		body         string
+		wantCode     int
+		wantToken    string
+		wantStatus   string
+		wantFlowData string
+	}{
+		{
+			name:         "success with outputs",
+			body:         `{"objName": "wf1", "step": "step1", "taskToken": "t1", "status": "success", "outputs": {"$.workflow1.step1.field1": "test1"}}`,
+			wantCode:     http.StatusOK,
+			wantStatus:   "Complete",
+			wantFlowData: `{"workflow1.step1.field1":"test1"}`,
+		},
+		{
+			name:       "failure",
+			body:       `{"objName": "wf1", "step": "step1", "executionId": "e1", "taskToken": "t1", "status": "failure", "message": "boom"}`,
+			wantCode:   http.StatusOK,
+			wantStatus: "Failure",
+		},
+		{
+			name:       "unknown status keeps the token",
+			body:       `{"objName": "wf1", "step": "step1", "taskToken": "t1", "status": "succeeded"}`,
+			wantCode:   http.StatusBadRequest,
+			wantToken:  "t1",
+			wantStatus: "Running",
+		},
+		{
+			name:       "missing task token",
+			body:       `{"objName": "wf1", "step": "step1", "status": "success"}`,
+			wantCode:   http.StatusBadRequest,
+			wantToken:  "t1",
+			wantStatus: "Running",
+		},
+		{
+			name:       "wrong task token",
+			body:       `{"objName": "wf1", "step": "step1", "taskToken": "t2", "status": "success"}`,
+			wantCode:   http.StatusConflict,
+			wantToken:  "t1",
+			wantStatus: "Running",
+		},
+		{
+			name:       "unknown execution",
+			body:       `{"objName": "wf1", "step": "step1", "executionId": "e9", "taskToken": "t1", "status": "success"}`,
+			wantCode:   http.StatusConflict,
+			wantToken:  "t1",
+			wantStatus: "Running",
+		},
+		{
+			name:       "unknown object",
+			body:       `{"objName": "wf9", "step": "step1", "taskToken": "t1", "status": "success"}`,
+			wantCode:   http.StatusNotFound,
+			wantToken:  "t1",
+			wantStatus: "Running",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, server, stop := newTestApp(t, w)
+			defer stop()
+			addWorkflowObject(server, app, "wf1", "workflow1", "Running", "", running)
+			recorder := httptest.NewRecorder()
+			app.handleCallback(recorder, httptest.NewRequest(http.MethodPost, "/callback", strings.NewReader(tt.body)))
+			settle(t, app)
+			if recorder.Code != tt.wantCode {
+				t.Fatalf("got status code %d (%s), want %d", recorder.Code, recorder.Body.String(), tt.wantCode)
+			}
+			step := workflowObjectSteps(t, server, "wf1")[0]
+			if token, _ := step["taskToken"].(string); token != tt.wantToken {
+				t.Errorf("taskToken = %q, want %q", token, tt.wantToken)
+			}
+			if status := step["status"]; status != tt.wantStatus {
+				t.Errorf("step status = %v, want %s", status, tt.wantStatus)
+			}
+			if tt.wantFlowData != "" {
+				if flowData := workflowObjectSpec(t, server, "wf1", "flowData"); flowData != tt.wantFlowData {
+					t.Errorf("flowData = %v, want %s", flowData, tt.wantFlowData)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/flintdev/workflow-engine/util"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const abortRequestTimeout = 10 * time.Second
+
+// AbortRequest is posted to the executor's abortUrl for every step that was running when its workflow
+// was cancelled.
+type AbortRequest struct {
+	ObjName        string `json:"objName"`
+	Step           string `json:"step"`
+	TaskToken      string `json:"taskToken"`
+	IdempotencyKey string `json:"idempotencyKey"`
+}
+
+// instanceContexts keeps a cancellable context per workflow object, so that cancelling an instance also
+// stops its steps that are in flight in this process.
+type instanceContexts struct {
+	mu      sync.Mutex
+	entries map[string]instanceContext
+}
+
+type instanceContext struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (ic *instanceContexts) get(parent context.Context, wfObjName string) context.Context {
+	if ic == nil {
+		return parent
+	}
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	if ic.entries == nil {
+		ic.entries = make(map[string]instanceContext)
+	}
+	entry, exist := ic.entries[wfObjName]
+	if !exist {
+		ctx, cancel := context.WithCancel(parent)
+		entry = instanceContext{ctx: ctx, cancel: cancel}
+		ic.entries[wfObjName] = entry
+	}
+	return valuesContext{Context: entry.ctx, values: parent}
+}
+
+// a context that is cancelled with the instance but carries the values of the caller's context, such as the
+// step execution it addresses.
+type valuesContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c valuesContext) Value(key interface{}) interface{} {
+	return c.values.Value(key)
+}
+
+func (ic *instanceContexts) release(wfObjName string) {
+	if ic == nil {
+		return
+	}
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	if entry, exist := ic.entries[wfObjName]; exist {
+		entry.cancel()
+		delete(ic.entries, wfObjName)
+	}
+}
+
+// derive the context the steps of a workflow object run with.
+func (wi *WorkflowInstance) instanceContext(ctx context.Context, wfObjName string) context.Context {
+	if wi.app == nil {
+		return ctx
+	}
+	return wi.app.instances.get(ctx, wfObjName)
+}
+
+// drop the context of a workflow object once it has finished.
+func (wi *WorkflowInstance) releaseInstanceContext(wfObjName string) {
+	if wi.app == nil {
+		return
+	}
+	wi.app.instances.release(wfObjName)
+}
+
+// CancelWorkflow cancels a running or pending workflow object. No further steps are scheduled, pending
+// manual steps stop waiting for triggers, and steps in flight in this process are cancelled. When the
+// executor has an abortUrl configured, it is notified of every step that was running. Completed steps
+// with a compensation step are compensated.
+func (app *App) CancelWorkflow(ctx context.Context, wfObjName string, reason string) error {
+	logger, _ := zap.NewProduction()
+	defer logger.Sync()
+	if reason == "" {
+		reason = "cancelled"
+	}
+	runningSteps, err := util.CancelWorkflowObject(ctx, app.kubeconfig, wfObjName, reason)
+	if err != nil {
+		return err
+	}
+	app.instances.release(wfObjName)
+	logInfo(logger, wfObjName, "", fmt.Sprintf("Cancelled workflow: %s", reason))
+	if wi, err := app.getWorkflowInstanceOfObject(ctx, wfObjName); err == nil {
+		wi.startCompensation(wfObjName)
+	}
+	for _, step := range runningSteps {
+		stepName, _, _ := unstructured.NestedString(step, "name")
+		// cancel the children of running sub-workflow and map steps along with their parent.
+		if childName, _, _ := unstructured.NestedString(step, "childObjName"); childName != "" {
+			err := app.CancelWorkflow(ctx, childName, reason)
+			if err != nil {
+				logError(logger, wfObjName, stepName, err.Error())
+			}
+		}
+		if items, _, _ := unstructured.NestedSlice(step, "items"); len(items) > 0 {
+			app.cancelMapItems(ctx, logger, wfObjName, stepName, items, reason)
+		}
+		if app.executor().AbortURL == "" {
+			continue
+		}
+		taskToken, _, _ := unstructured.NestedString(step, "taskToken")
+		idempotencyKey, _, _ := unstructured.NestedString(step, "idempotencyKey")
+		err := app.notifyExecutorAbort(ctx, AbortRequest{
+			ObjName:        wfObjName,
+			Step:           stepName,
+			TaskToken:      taskToken,
+			IdempotencyKey: idempotencyKey,
+		})
+		if err != nil {
+			logError(logger, wfObjName, stepName, err.Error())
+		}
+	}
+	return nil
+}
+
+// notify the executor that a step was aborted. The request gives up after abortRequestTimeout, so a slow
+// abortUrl cannot hold up the cancellation.
+func (app *App) notifyExecutorAbort(ctx context.Context, a AbortRequest) error {
+	ctx, cancel := context.WithTimeout(ctx, abortRequestTimeout)
+	defer cancel()
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, app.executor().AbortURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		message := fmt.Sprintf("executor abort request failed with status %d", response.StatusCode)
+		return errors.New(message)
+	}
+	return nil
+}
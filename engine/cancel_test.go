@@ -0,0 +1,37 @@
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/flintdev/workflow-engine/util"
+)
+
+func TestInstanceContexts(t *testing.T) {
+	ic := &instanceContexts{}
+	tests := []struct {
+		name        string
+		executionID string
+	}{
+		{name: "first caller", executionID: "a1"},
+		{name: "later caller", executionID: "b1"},
+		{name: "caller without execution", executionID: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.executionID != "" {
+				ctx = util.WithExecution(ctx, tt.executionID, "")
+			}
+			got := ic.get(ctx, "wf1")
+			if id := util.ExecutionID(got); id != tt.executionID {
+				t.Errorf("ExecutionID() = %q, want %q", id, tt.executionID)
+			}
+		})
+	}
+	ctx := ic.get(context.Background(), "wf1")
+	ic.release("wf1")
+	if ctx.Err() == nil {
+		t.Error("context is not cancelled after release")
+	}
+}
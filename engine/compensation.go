@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/flintdev/workflow-engine/util"
+	"go.uber.org/zap"
+	"strings"
+)
+
+// start compensating a workflow object that has failed or was cancelled.
+func (wi *WorkflowInstance) startCompensation(wfObjName string) {
+	if wi.app == nil {
+		return
+	}
+	wi.spawn(func() {
+		wi.app.compensate(wi.app.ctx, wi, wfObjName)
+	})
+}
+
+// run the compensation steps of the completed steps of a finished workflow object, in reverse completion
+// order. Every compensation is recorded in spec.steps as its own entry, and the outcome in spec.compensation.
+// A failed compensation does not stop the ones after it.
+func (app *App) compensate(ctx context.Context, wi *WorkflowInstance, wfObjName string) {
+	logger, _ := zap.NewProduction()
+	defer logger.Sync()
+	completed, err := util.ClaimWorkflowObjectCompensation(ctx, app.kubeconfig, wfObjName, func(stepName string) bool {
+		return wi.Workflow.Steps[stepName].Compensation != ""
+	})
+	if err != nil {
+		logError(logger, wfObjName, "", err.Error())
+		return
+	}
+	if len(completed) == 0 {
+		return
+	}
+	logInfo(logger, wfObjName, "", fmt.Sprintf("Compensating %d completed steps", len(completed)))
+	var failures []string
+	for i := len(completed) - 1; i >= 0; i-- {
+		stepName, _ := completed[i]["name"].(string)
+		executionID, _ := completed[i]["id"].(string)
+		compensation := wi.Workflow.Steps[stepName].Compensation
+		err := wi.runCompensation(ctx, app.kubeconfig, logger, wfObjName, compensation, stepName, executionID)
+		if err != nil {
+			logError(logger, wfObjName, compensation, err.Error())
+			failures = append(failures, compensation)
+		}
+	}
+	status, message := "Complete", ""
+	if len(failures) > 0 {
+		status = "Failure"
+		message = fmt.Sprintf("Failed on compensation steps: %s", strings.Join(failures, ","))
+	}
+	err = util.SetWorkflowObjectCompensationStatus(ctx, app.kubeconfig, wfObjName, status, message)
+	if err != nil {
+		logError(logger, wfObjName, "", err.Error())
+	}
+}
+
+// run the compensation step of a completed execution of a step through the executor, which must answer
+// synchronously.
+func (wi *WorkflowInstance) runCompensation(ctx context.Context, kubeconfig *string, logger *zap.Logger, wfObjName string, compensation string, stepName string, executionID string) error {
+	ctx = util.WithExecution(ctx, util.GenerateExecutionID(), executionID)
+	attempt, err := util.AddWorkflowObjectCompensationStep(ctx, kubeconfig, wfObjName, compensation, stepName, executionID)
+	if err != nil {
+		return err
+	}
+	logInfo(logger, wfObjName, compensation, fmt.Sprintf("compensating step %s", stepName))
+	r, err := wi.callExecutor(ctx, ExecutorRequest{
+		Step:           compensation,
+		ObjName:        wfObjName,
+		Attempt:        attempt,
+		IdempotencyKey: util.GenerateIdempotencyKey(wfObjName, compensation, attempt),
+		Compensates:    stepName,
+	})
+	if err == nil && r.Status != "success" {
+		err = errors.New(r.Message)
+	}
+	if err != nil {
+		if err := util.SetWorkflowObjectStepToFailure(ctx, kubeconfig, wfObjName, compensation, err.Error()); err != nil {
+			logError(logger, wfObjName, compensation, err.Error())
+		}
+		return err
+	}
+	return util.SetWorkflowObjectStepToComplete(ctx, kubeconfig, wfObjName, compensation, r.Message)
+}
@@ -0,0 +1,180 @@
+package engine
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/flintdev/workflow-engine/util"
+)
+
+// start an executor that records the steps it is called for and fails the given ones.
+func newRecordingExecutor(fail ...string) (*httptest.Server, func() []string) {
+	var mu sync.Mutex
+	var called []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request ExecutorRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		mu.Lock()
+		called = append(called, request.Step)
+		mu.Unlock()
+		status := "success"
+		for _, step := range fail {
+			if step == request.Step {
+				status = "failure"
+			}
+		}
+		json.NewEncoder(w).Encode(ExecutorResponse{Status: status, Message: request.Compensates})
+	}))
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), called...)
+	}
+}
+
+func TestCompensate(t *testing.T) {
+	w := Workflow{
+		Name:    "workflow1",
+		StartAt: []string{"step1"},
+		Trigger: TriggerCondition{Model: "model1", EventType: "added"},
+		Steps: map[string]Step{
+			"step1": {Compensation: "undo1", NextSteps: []NextStep{{Name: "step2"}}},
+			"step2": {NextSteps: []NextStep{{Name: "step3"}}},
+			"step3": {Compensation: "undo3", NextSteps: []NextStep{{Name: "step4"}}},
+			"step4": {Compensation: "undo4"},
+			"undo1": {},
+			"undo3": {},
+			"undo4": {},
+		},
+	}
+	entry := func(name string, status string, endAt string) map[string]interface{} {
+		return map[string]interface{}{"name": name, "id": name + "-e", "status": status, "endAt": endAt}
+	}
+	tests := []struct {
+		name        string
+		status      string
+		steps       []map[string]interface{}
+		fail        []string
+		wantCalled  []string
+		wantOutcome string
+	}{
+		{
+			name:   "reverse completion order",
+			status: "Failure",
+			steps: []map[string]interface{}{
+				// step3 finished before step1 although it was recorded after it.
+				entry("step1", "Complete", "2020-01-01 00:00:02 +0000 UTC"),
+				entry("step3", "Complete", "2020-01-01 00:00:01 +0000 UTC"),
+				entry("step4", "Complete", "2020-01-01 00:00:03 +0000 UTC"),
+			},
+			wantCalled:  []string{"undo4", "undo1", "undo3"},
+			wantOutcome: "Complete",
+		},
+		{
+			name:   "steps without compensation are skipped",
+			status: "Cancelled",
+			steps: []map[string]interface{}{
+				entry("step1", "Complete", "2020-01-01 00:00:01 +0000 UTC"),
+				entry("step2", "Complete", "2020-01-01 00:00:02 +0000 UTC"),
+				entry("step3", "Complete", "2020-01-01 00:00:03 +0000 UTC"),
+			},
+			wantCalled:  []string{"undo3", "undo1"},
+			wantOutcome: "Complete",
+		},
+		{
+			name:   "steps that did not complete are skipped",
+			status: "Failure",
+			steps: []map[string]interface{}{
+				entry("step1", "Complete", "2020-01-01 00:00:01 +0000 UTC"),
+				entry("step3", "Failure", "2020-01-01 00:00:02 +0000 UTC"),
+			},
+			wantCalled:  []string{"undo1"},
+			wantOutcome: "Complete",
+		},
+		{
+			name:   "a failed compensation does not stop the rest",
+			status: "Failure",
+			steps: []map[string]interface{}{
+				entry("step1", "Complete", "2020-01-01 00:00:01 +0000 UTC"),
+				entry("step3", "Complete", "2020-01-01 00:00:02 +0000 UTC"),
+			},
+			fail:        []string{"undo3"},
+			wantCalled:  []string{"undo3", "undo1"},
+			wantOutcome: "Failure",
+		},
+		{
+			name:   "nothing to compensate",
+			status: "Failure",
+			steps: []map[string]interface{}{
+				entry("step2", "Complete", "2020-01-01 00:00:01 +0000 UTC"),
+			},
+		},
+		{
+			name:   "reopened object",
+			status: "Running",
+			steps: []map[string]interface{}{
+				entry("step1", "Complete", "2020-01-01 00:00:01 +0000 UTC"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			executor, called := newRecordingExecutor(tt.fail...)
+			defer executor.Close()
+			app, server, stop := newTestApp(t, w)
+			defer stop()
+			app.Executor.URL = executor.URL
+			addWorkflowObject(server, app, "wf1", "workflow1", tt.status, "", tt.steps...)
+
+			app.compensate(app.ctx, app.getWorkflowInstance("workflow1"), "wf1")
+			if got := called(); !reflect.DeepEqual(got, tt.wantCalled) {
+				t.Errorf("compensation steps = %v, want %v", got, tt.wantCalled)
+			}
+			compensation, _ := workflowObjectSpec(t, server, "wf1", "compensation").(map[string]interface{})
+			if status, _ := compensation["status"].(string); status != tt.wantOutcome {
+				t.Errorf("compensation status = %q, want %q", status, tt.wantOutcome)
+			}
+			// every compensation is recorded as its own entry pointing at the execution it undoes.
+			steps := workflowObjectSteps(t, server, "wf1")
+			for i, name := range tt.wantCalled {
+				entry := steps[len(tt.steps)+i]
+				if entry["name"] != name || entry["compensates"] != "step"+name[len("undo"):] {
+					t.Errorf("entry %d = %v, want a compensation %s", len(tt.steps)+i, entry, name)
+				}
+			}
+		})
+	}
+}
+
+func TestClaimCompensationOnce(t *testing.T) {
+	w := Workflow{
+		Name:    "workflow1",
+		StartAt: []string{"step1"},
+		Trigger: TriggerCondition{Model: "model1", EventType: "added"},
+		Steps:   map[string]Step{"step1": {Compensation: "undo1"}, "undo1": {}},
+	}
+	app, server, stop := newTestApp(t, w)
+	defer stop()
+	addWorkflowObject(server, app, "wf1", "workflow1", "Failure", "",
+		map[string]interface{}{"name": "step1", "id": "e1", "status": "Complete", "endAt": "2020-01-01 00:00:01 +0000 UTC"})
+	compensable := func(stepName string) bool { return stepName == "step1" }
+
+	first, err := util.ClaimWorkflowObjectCompensation(app.ctx, app.kubeconfig, "wf1", compensable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 1 || first[0]["name"] != "step1" {
+		t.Fatalf("first claim = %v, want step1", first)
+	}
+	second, err := util.ClaimWorkflowObjectCompensation(app.ctx, app.kubeconfig, "wf1", compensable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second) != 0 {
+		t.Errorf("second claim = %v, want nothing", second)
+	}
+}
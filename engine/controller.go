@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"github.com/flintdev/workflow-engine/util"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// watch the workflow objects until watchCtx is cancelled and act on the control requests made on them.
+func (app *App) watchWorkflowObjects(watchCtx context.Context, ctx context.Context) {
+	logger, _ := zap.NewProduction()
+	defer logger.Sync()
+	ch := util.WatchObject(watchCtx, app.kubeconfig, util.WFNamespace, util.WFGroup, util.WFVersion, util.WFResource)
+	for event := range ch {
+		if event.Type == watch.Deleted {
+			continue
+		}
+		obj, ok := event.Object.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		app.handleWorkflowObjectEvent(ctx, logger, obj)
+	}
+}
+
+func (app *App) handleWorkflowObjectEvent(ctx context.Context, logger *zap.Logger, obj *unstructured.Unstructured) {
+	wfObjName := obj.GetName()
+	if reason, exist := obj.GetAnnotations()[util.CancelAnnotation]; exist {
+		// cancelling also cancels children and notifies the executor, so it runs off the watch loop.
+		app.submitControl(func() {
+			err := app.CancelWorkflow(ctx, wfObjName, reason)
+			if err != nil {
+				logError(logger, wfObjName, "", fmt.Sprintf("cannot cancel workflow: %s", err))
+				// drop the request so it is not retried on every update of the object.
+				err := util.RemoveWorkflowObjectAnnotation(ctx, app.kubeconfig, wfObjName, util.CancelAnnotation)
+				if err != nil {
+					logError(logger, wfObjName, "", err.Error())
+				}
+			}
+		})
+		return
+	}
+	if value, exist := obj.GetAnnotations()[util.SignalAnnotation]; exist {
+		app.handleSignalAnnotation(ctx, logger, wfObjName, value)
+	}
+	paused, _, _ := unstructured.NestedBool(obj.Object, "spec", "paused")
+	status, _, _ := unstructured.NestedString(obj.Object, "spec", "status")
+	switch {
+	case paused && status != "Paused" && status != "Complete" && status != "Failure" && status != "Cancelled":
+		err := app.PauseWorkflow(ctx, wfObjName)
+		if err != nil {
+			logError(logger, wfObjName, "", fmt.Sprintf("cannot pause workflow: %s", err))
+		}
+	case !paused && status == "Paused":
+		err := app.ResumeWorkflow(ctx, wfObjName)
+		if err != nil {
+			logError(logger, wfObjName, "", fmt.Sprintf("cannot resume workflow: %s", err))
+		}
+	}
+	if _, exist := obj.GetLabels()[util.ParentWorkflowLabel]; exist {
+		app.handleChildWorkflowEvent(ctx, logger, obj)
+	}
+	// a retired version is unregistered once the last workflow object running on it has finished.
+	if status == "Complete" || status == "Cancelled" {
+		if wi, err := app.getWorkflowInstanceOf(obj); err == nil && wi.retired {
+			app.pruneWorkflowInstances(logger, wi.Workflow.Name)
+		}
+	}
+}
+
+// run a control request on the worker pool. Control requests are not tied to a workflow, so no workflow limit
+// holds them back.
+func (app *App) submitControl(task func()) {
+	if app.pool == nil {
+		go task()
+		return
+	}
+	app.pool.submit("", task)
+}
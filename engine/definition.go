@@ -0,0 +1,201 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/flintdev/workflow-engine/util"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/watch"
+	"reflect"
+	"time"
+)
+
+// watch the WorkflowDefinition resources until watchCtx is cancelled and keep the registered workflows in sync
+// with them.
+func (app *App) watchWorkflowDefinitions(watchCtx context.Context) {
+	logger, _ := zap.NewProduction()
+	defer logger.Sync()
+	logger.Info(fmt.Sprintf("Start Watching Resource Group: %s, Version: %s, Resource: %s", util.WFGroup, util.WFVersion, util.WFDefinitionResource))
+	start := func(ctx context.Context) (<-chan watch.Event, error) {
+		return util.StartWatchObject(ctx, app.kubeconfig, util.WFNamespace, util.WFGroup, util.WFVersion, util.WFDefinitionResource)
+	}
+	rewatch(watchCtx, logger, start, definitionWatchBackoff, func(event watch.Event) {
+		obj, ok := event.Object.(*unstructured.Unstructured)
+		if !ok {
+			return
+		}
+		if event.Type == watch.Deleted {
+			app.unloadWorkflowDefinition(logger, obj)
+			return
+		}
+		app.loadWorkflowDefinition(logger, obj)
+	})
+}
+
+// the first and the longest wait before a WorkflowDefinition watch is started again.
+var definitionWatchBackoff = [2]time.Duration{time.Second, time.Minute}
+
+// keep a watch running until ctx is cancelled and pass its events to handle. A watch that cannot be started,
+// for example because its resource is not installed yet, or that the API server closes is started again,
+// waiting twice as long after every failure up to backoff[1]. A watch that delivered events resets the wait.
+func rewatch(ctx context.Context, logger *zap.Logger, start func(ctx context.Context) (<-chan watch.Event, error), backoff [2]time.Duration, handle func(event watch.Event)) {
+	wait := backoff[0]
+	for ctx.Err() == nil {
+		watchCtx, cancel := context.WithCancel(ctx)
+		ch, err := start(watchCtx)
+		if err != nil {
+			logger.Error(fmt.Sprintf("cannot watch, retrying in %s: %s", wait, err))
+		} else {
+			received := false
+			for event := range ch {
+				received = true
+				handle(event)
+			}
+			if received {
+				wait = backoff[0]
+			}
+		}
+		cancel()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait *= 2
+		if wait > backoff[1] {
+			wait = backoff[1]
+		}
+	}
+}
+
+// register the workflow a WorkflowDefinition describes and report the outcome in the Ready condition of the
+// resource, once per generation.
+func (app *App) loadWorkflowDefinition(logger *zap.Logger, obj *unstructured.Unstructured) {
+	name := obj.GetName()
+	app.loadWorkflowDefinitionVersions(logger, obj)
+	generation := obj.GetGeneration()
+	observed, _, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+	report := func(status string, reason string, message string, registered map[string]interface{}) {
+		if observed == generation {
+			return
+		}
+		err := util.SetWorkflowDefinitionCondition(app.ctx, app.kubeconfig, name, generation, status, reason, message, registered)
+		if err != nil {
+			logger.Error(err.Error(), zap.String("WorkflowDefinition", name))
+		}
+	}
+	w, err := parseWorkflowDefinition(obj)
+	var wi WorkflowInstance
+	if err == nil {
+		wi, err = app.loadWorkflow(logger, w, "WorkflowDefinition/"+name)
+	}
+	if err != nil {
+		logger.Error(err.Error(), zap.String("WorkflowDefinition", name))
+		report("False", "InvalidDefinition", err.Error(), nil)
+		return
+	}
+	message := fmt.Sprintf("workflow %s version %s is registered", w.Name, w.Version)
+	registered := map[string]interface{}{"workflow": w.Name, "version": w.Version, "definitionHash": wi.hash}
+	report("True", "Registered", message, registered)
+	app.recordWorkflowDefinitionVersion(logger, obj, wi)
+}
+
+// register the earlier versions recorded in the status of a WorkflowDefinition, so the workflow objects started
+// with them keep running after the resource changes or the engine restarts. The version in the spec stays
+// current.
+func (app *App) loadWorkflowDefinitionVersions(logger *zap.Logger, obj *unstructured.Unstructured) {
+	name := obj.GetName()
+	spec, _, _ := unstructured.NestedMap(obj.Object, "spec")
+	versions, _, _ := unstructured.NestedSlice(obj.Object, "status", "versions")
+	for _, v := range versions {
+		entry, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		definition, _ := entry["definition"].(map[string]interface{})
+		if reflect.DeepEqual(definition, spec) {
+			continue
+		}
+		w, err := parseWorkflowDocument(definition, name)
+		var wi WorkflowInstance
+		if err == nil {
+			wi, err = app.newWorkflowInstance(w, "WorkflowDefinition/"+name)
+		}
+		if err != nil {
+			logger.Error(fmt.Sprintf("invalid earlier version %v: %s", entry["definitionHash"], err), zap.String("WorkflowDefinition", name))
+			continue
+		}
+		if app.addEarlierWorkflowInstance(wi) {
+			logger.Info(fmt.Sprintf("Registered earlier workflow %s version %s (%s)", w.Name, w.Version, wi.hash), zap.String("WorkflowDefinition", name))
+		}
+	}
+}
+
+// record the registered version of a WorkflowDefinition in status.versions, dropping the recorded versions no
+// workflow object runs on any more.
+func (app *App) recordWorkflowDefinitionVersion(logger *zap.Logger, obj *unstructured.Unstructured, wi WorkflowInstance) {
+	name := obj.GetName()
+	versions, _, _ := unstructured.NestedSlice(obj.Object, "status", "versions")
+	for _, v := range versions {
+		if entry, ok := v.(map[string]interface{}); ok && entry["definitionHash"] == wi.hash {
+			return
+		}
+	}
+	referenced, err := app.referencedDefinitions(app.ctx, wi.Workflow.Name)
+	if err != nil {
+		logger.Error(err.Error(), zap.String("WorkflowDefinition", name))
+		return
+	}
+	definition, _, _ := unstructured.NestedMap(obj.Object, "spec")
+	version := map[string]interface{}{
+		"version":        wi.Workflow.Version,
+		"definitionHash": wi.hash,
+		"definition":     definition,
+	}
+	err = util.AddWorkflowDefinitionVersion(app.ctx, app.kubeconfig, name, version, func(hash string) bool {
+		return referenced[hash]
+	})
+	if err != nil {
+		logger.Error(err.Error(), zap.String("WorkflowDefinition", name))
+	}
+}
+
+// unregister every version of the workflow a deleted WorkflowDefinition described.
+func (app *App) unloadWorkflowDefinition(logger *zap.Logger, obj *unstructured.Unstructured) {
+	name := obj.GetName()
+	app.unloadWorkflows(logger, "WorkflowDefinition/"+name)
+}
+
+// read the workflow from the spec of a WorkflowDefinition after checking it against WorkflowSchema. The
+// workflow is named after the resource unless the spec names it.
+func parseWorkflowDefinition(obj *unstructured.Unstructured) (Workflow, error) {
+	spec, _, _ := unstructured.NestedMap(obj.Object, "spec")
+	return parseWorkflowDocument(spec, obj.GetName())
+}
+
+// read a workflow from a definition document after checking it against WorkflowSchema. The workflow is named
+// name unless the document names it.
+func parseWorkflowDocument(spec map[string]interface{}, name string) (Workflow, error) {
+	var w Workflow
+	b, err := json.Marshal(spec)
+	if err != nil {
+		return w, err
+	}
+	var doc interface{}
+	err = json.Unmarshal(b, &doc)
+	if err == nil {
+		err = ValidateWorkflowDocument(doc)
+	}
+	if err == nil {
+		err = json.Unmarshal(b, &w)
+	}
+	if err != nil {
+		return w, err
+	}
+	if w.Name == "" {
+		w.Name = name
+	}
+	return w, nil
+}
@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func TestRewatch(t *testing.T) {
+	tests := []struct {
+		name string
+		// the outcome of every start: an error, or the number of events the watch delivers before it closes.
+		starts     []interface{}
+		wantEvents int
+	}{
+		{name: "resource not installed yet", starts: []interface{}{errors.New("not found"), errors.New("not found"), 2}, wantEvents: 2},
+		{name: "closed by the server", starts: []interface{}{1, 0, 3}, wantEvents: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			starts := 0
+			start := func(ctx context.Context) (<-chan watch.Event, error) {
+				if starts == len(tt.starts) {
+					cancel()
+					return nil, errors.New("done")
+				}
+				outcome := tt.starts[starts]
+				starts++
+				if err, ok := outcome.(error); ok {
+					return nil, err
+				}
+				ch := make(chan watch.Event, outcome.(int))
+				for i := 0; i < outcome.(int); i++ {
+					ch <- watch.Event{Type: watch.Added}
+				}
+				close(ch)
+				return ch, nil
+			}
+			events := 0
+			done := make(chan struct{})
+			go func() {
+				rewatch(ctx, zap.NewNop(), start, [2]time.Duration{time.Millisecond, 4 * time.Millisecond}, func(watch.Event) { events++ })
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("rewatch did not stop after ctx was cancelled")
+			}
+			if starts != len(tt.starts) {
+				t.Errorf("started %d watches, want %d", starts, len(tt.starts))
+			}
+			if events != tt.wantEvents {
+				t.Errorf("handled %d events, want %d", events, tt.wantEvents)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"github.com/flintdev/workflow-engine/util"
+	"go.uber.org/zap"
+)
+
+// EndConfig describes how an end step finishes a workflow. Outcome, such as Approved or Rejected, is recorded
+// on the workflow object, and Result maps keys of the workflow result to the flowData keys they are read from.
+type EndConfig struct {
+	Outcome string            `json:"outcome"`
+	Result  map[string]string `json:"result"`
+}
+
+// check whether a step ends its branch. Besides "end" steps, an untyped step without next steps is the end
+// marker of older definitions.
+func (s Step) isEnd() bool {
+	return s.Type == "end" || (s.Type == "" && len(s.NextSteps) == 0)
+}
+
+// run an end step: record its outcome and result on the workflow object, complete it and settle the workflow.
+// When several end steps are reached, the first one decides the outcome.
+func (wi *WorkflowInstance) runEnd(ctx context.Context, kubeconfig *string, logger *zap.Logger, wfObjName string, stepName string) {
+	config := wi.Workflow.Steps[stepName].End
+	var result map[string]string
+	if len(config.Result) > 0 {
+		flowData, err := util.GetWorkflowObjectFlowData(ctx, kubeconfig, wfObjName)
+		if err != nil {
+			logError(logger, wfObjName, stepName, err.Error())
+			wi.failStep(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
+			return
+		}
+		result, err = mapFlowData(flowData, config.Result)
+		if err != nil {
+			logError(logger, wfObjName, stepName, err.Error())
+			wi.failStep(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
+			return
+		}
+	}
+	recorded, err := util.SetWorkflowObjectOutcome(ctx, kubeconfig, wfObjName, stepName, config.Outcome, result)
+	if err != nil {
+		logError(logger, wfObjName, stepName, err.Error())
+		wi.failStep(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
+		return
+	}
+	message := ""
+	if recorded && config.Outcome != "" {
+		message = fmt.Sprintf("outcome %s", config.Outcome)
+		logInfo(logger, wfObjName, stepName, fmt.Sprintf("workflow ends with outcome %s", config.Outcome))
+	}
+	err = util.SetWorkflowObjectStepToComplete(ctx, kubeconfig, wfObjName, stepName, message)
+	if err != nil {
+		logError(logger, wfObjName, stepName, err.Error())
+		wi.failStep(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
+		return
+	}
+	wi.finishBranch(ctx, kubeconfig, logger, wfObjName, stepName)
+}
@@ -0,0 +1,150 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestStepIsEnd(t *testing.T) {
+	tests := []struct {
+		name string
+		step Step
+		want bool
+	}{
+		{name: "end step", step: Step{Type: "end"}, want: true},
+		{name: "end step with next steps", step: Step{Type: "end", NextSteps: []NextStep{{Name: "a"}}}, want: true},
+		{name: "untyped step without next steps", step: Step{}, want: true},
+		{name: "untyped step with next steps", step: Step{NextSteps: []NextStep{{Name: "a"}}}},
+		{name: "typed step without next steps", step: Step{Type: "wait"}},
+		{name: "untyped step with only onFailure", step: Step{OnFailure: []NextStep{{Name: "a"}}}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.step.isEnd(); got != tt.want {
+				t.Errorf("isEnd = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunEnd(t *testing.T) {
+	w := Workflow{
+		Name:    "workflow1",
+		StartAt: []string{"check"},
+		Trigger: TriggerCondition{Model: "model1", EventType: "added"},
+		Steps: map[string]Step{
+			"check": {NextSteps: []NextStep{{Name: "approved"}, {Name: "rejected"}}},
+			"approved": {Type: "end", End: EndConfig{
+				Outcome: "Approved",
+				Result:  map[string]string{"$.amount": "$.workflow1.check.amount"},
+			}},
+			"rejected": {Type: "end", End: EndConfig{Outcome: "Rejected"}},
+			"missing": {Type: "end", End: EndConfig{
+				Outcome: "Approved",
+				Result:  map[string]string{"$.amount": "$.workflow1.check.total"},
+			}},
+		},
+	}
+	tests := []struct {
+		name        string
+		ends        []string
+		wantOutcome string
+		wantEndStep string
+		wantResult  map[string]interface{}
+		wantStatus  string
+	}{
+		{
+			name:        "outcome and result",
+			ends:        []string{"approved"},
+			wantOutcome: "Approved",
+			wantEndStep: "approved",
+			wantResult:  map[string]interface{}{"amount": "10"},
+			wantStatus:  "Complete",
+		},
+		{
+			name:        "first end step wins",
+			ends:        []string{"rejected", "approved"},
+			wantOutcome: "Rejected",
+			wantEndStep: "rejected",
+			wantStatus:  "Complete",
+		},
+		{
+			name:       "result key missing from flowData",
+			ends:       []string{"missing"},
+			wantStatus: "Failure",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, server, stop := newTestApp(t, w)
+			defer stop()
+			entries := []map[string]interface{}{{"name": "check", "id": "e0", "status": "Complete"}}
+			for i, end := range tt.ends {
+				entries = append(entries, map[string]interface{}{"name": end, "id": fmt.Sprintf("e%d", i+1), "status": "Running"})
+			}
+			addWorkflowObject(server, app, "wf1", "workflow1", "Running", `{"workflow1.check.amount":"10"}`, entries...)
+
+			wi := app.getWorkflowInstance("workflow1")
+			for _, end := range tt.ends {
+				wi.runEnd(app.ctx, app.kubeconfig, zap.NewNop(), "wf1", end)
+			}
+			settle(t, app)
+
+			if outcome, _ := workflowObjectSpec(t, server, "wf1", "outcome").(string); outcome != tt.wantOutcome {
+				t.Errorf("outcome = %q, want %q", outcome, tt.wantOutcome)
+			}
+			if endStep, _ := workflowObjectSpec(t, server, "wf1", "endStep").(string); endStep != tt.wantEndStep {
+				t.Errorf("endStep = %q, want %q", endStep, tt.wantEndStep)
+			}
+			result, _ := workflowObjectSpec(t, server, "wf1", "result").(map[string]interface{})
+			if len(result) != len(tt.wantResult) || (len(result) > 0 && result["amount"] != tt.wantResult["amount"]) {
+				t.Errorf("result = %v, want %v", result, tt.wantResult)
+			}
+			if status := workflowObjectSpec(t, server, "wf1", "status"); status != tt.wantStatus {
+				t.Errorf("workflow status = %v, want %s", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestRunNextStepsDeadEnd(t *testing.T) {
+	w := Workflow{
+		Name:    "workflow1",
+		StartAt: []string{"check"},
+		Trigger: TriggerCondition{Model: "model1", EventType: "added"},
+		Steps: map[string]Step{
+			"check": {NextSteps: []NextStep{{Name: "done", When: "'$.workflow1.check.ok' == 'true'"}}},
+			"done":  {},
+		},
+	}
+	tests := []struct {
+		name           string
+		step           string
+		wantStepStatus string
+		wantStatus     string
+	}{
+		{name: "no next step matched", step: "check", wantStepStatus: "Failure", wantStatus: "Failure"},
+		{name: "last step of a branch", step: "done", wantStepStatus: "Complete", wantStatus: "Complete"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, server, stop := newTestApp(t, w)
+			defer stop()
+			addWorkflowObject(server, app, "wf1", "workflow1", "Running", "",
+				map[string]interface{}{"name": tt.step, "id": "e1", "status": "Complete"})
+
+			wi := app.getWorkflowInstance("workflow1")
+			wi.runNextSteps(app.ctx, app.kubeconfig, zap.NewNop(), "wf1", tt.step, app.newHandler("wf1"), nil)
+			settle(t, app)
+
+			if status := workflowObjectSteps(t, server, "wf1")[0]["status"]; status != tt.wantStepStatus {
+				t.Errorf("step status = %v, want %s", status, tt.wantStepStatus)
+			}
+			if status := workflowObjectSpec(t, server, "wf1", "status"); status != tt.wantStatus {
+				t.Errorf("workflow status = %v, want %s", status, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package engine
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/Knetic/govaluate"
@@ -15,8 +16,11 @@ import (
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/util/jsonpath"
 	"k8s.io/kubectl/pkg/cmd/get"
+	"net/http"
+	"os"
+	"os/signal"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 )
 
@@ -39,29 +43,69 @@ type TriggerCondition struct {
 }
 
 type Step struct {
-	Type        string           `json:"type"`
-	StepTrigger TriggerCondition `json:"trigger"`
-	Inputs      []string         `json:"inputs"`
-	Condition   string           `json:"condition"`
-	NextSteps   []NextStep       `json:"nextSteps"`
+	Type             string            `json:"type"`
+	StepTrigger      TriggerCondition  `json:"trigger"`
+	Inputs           []string          `json:"inputs"`
+	Condition        string            `json:"condition"`
+	NextSteps        []NextStep        `json:"nextSteps"`
+	HeartbeatTimeout string            `json:"heartbeatTimeout"`
+	Approval         ApprovalConfig    `json:"approval"`
+	OnReject         []NextStep        `json:"onReject"`
+	Wait             WaitConfig        `json:"wait"`
+	SubWorkflow      SubWorkflowConfig `json:"subWorkflow"`
+	Map              MapConfig         `json:"map"`
+	JoinCount        int               `json:"joinCount"`
+	OnUnsatisfied    []NextStep        `json:"onUnsatisfied"`
+	Compensation     string            `json:"compensation"`
+	OnFailure        []NextStep        `json:"onFailure"`
+	Loop             LoopConfig        `json:"loop"`
+	End              EndConfig         `json:"end"`
 }
 
 type Workflow struct {
-	Name    string           `json:"name"`
-	StartAt []string         `json:"startAt"`
-	Trigger TriggerCondition `json:"trigger"`
-	Steps   map[string]Step  `json:"steps"`
+	Name           string           `json:"name"`
+	Version        string           `json:"version"`
+	StartAt        []string         `json:"startAt"`
+	Trigger        TriggerCondition `json:"trigger"`
+	Steps          map[string]Step  `json:"steps"`
+	MaxConcurrency int              `json:"maxConcurrency"`
 }
 
 type WorkflowInstance struct {
 	Workflow     Workflow
 	StepTriggers map[string][]TriggerCondition
+	app          *App
+	// the loop step every step in a loop body belongs to.
+	loops map[string]string
+	// the hash of the definition, recorded on the workflow objects started with it.
+	hash string
+	// where the definition was loaded from at runtime, empty for definitions registered in code.
+	source string
+	// the source no longer defines the workflow; the instance only runs the workflow objects started with it.
+	retired bool
 }
 
 type App struct {
-	WorkflowInstances []WorkflowInstance
-	ModelGVRMap       map[string]GVR
-	StartAt           time.Time
+	WorkflowInstances   []WorkflowInstance
+	ModelGVRMap         map[string]GVR
+	Executor            ExecutorConfig
+	Concurrency         ConcurrencyConfig
+	ShutdownGracePeriod time.Duration
+	WatchDefinitions    bool
+	ReloadInterval      time.Duration
+	ApproverGroups      func(ctx context.Context, approver string) ([]string, error)
+	StartAt             time.Time
+	ctx                 context.Context
+	kubeconfig          *string
+	pool                *workerPool
+	instances           *instanceContexts
+	registry            *registry
+	configFile          string
+	configFileTime      time.Time
+	config              Config
+	models              *modelWatches
+	workflowPaths       []string
+	workflowFileTimes   map[string]time.Time
 }
 
 type Event struct {
@@ -79,10 +123,26 @@ type GVR struct {
 	Resource string `json:"resource"`
 }
 
+type ExecutorConfig struct {
+	URL         string `json:"url"`
+	CallbackURL string `json:"callbackUrl"`
+	AbortURL    string `json:"abortUrl"`
+}
+
 type Config struct {
-	GVRMap map[string]GVR `json:"gvr"`
+	GVRMap              map[string]GVR    `json:"gvr"`
+	Executor            ExecutorConfig    `json:"executor"`
+	Concurrency         ConcurrencyConfig `json:"concurrency"`
+	ShutdownGracePeriod string            `json:"shutdownGracePeriod"`
+	WatchDefinitions    bool              `json:"watchDefinitions"`
+	ReloadInterval      string            `json:"reloadInterval"`
 }
 
+const defaultExecutorURL = "http://python-executor:8080/execute"
+const defaultCallbackURL = "http://workflow-engine:8080/callback"
+const defaultShutdownGracePeriod = 30 * time.Second
+const shutdownCancelTimeout = 5 * time.Second
+
 func CreateWorkflowInstance() WorkflowInstance {
 	var wi WorkflowInstance
 	wi.StepTriggers = make(map[string][]TriggerCondition)
@@ -91,6 +151,10 @@ func CreateWorkflowInstance() WorkflowInstance {
 
 func CreateApp() App {
 	var app App
+	app.Executor = ExecutorConfig{URL: defaultExecutorURL, CallbackURL: defaultCallbackURL}
+	app.ShutdownGracePeriod = defaultShutdownGracePeriod
+	app.instances = &instanceContexts{}
+	app.registry = &registry{}
 	return app
 }
 
@@ -108,18 +172,57 @@ func (wi *WorkflowInstance) RegisterWorkflowDefinition(f func() Workflow) {
 			wi.StepTriggers[stepName] = stepTriggerConditions
 		}
 	}
+	wi.loops = loopBodies(w)
+	wi.hash = definitionHash(w)
 	wi.Workflow = w
 }
 
 func (app *App) RegisterConfig(f func() Config) {
 	c := f()
-	app.ModelGVRMap = c.GVRMap
+	app.setModelsAndExecutor(c)
+	app.Concurrency = c.Concurrency
+	app.WatchDefinitions = c.WatchDefinitions
+	if c.ReloadInterval != "" {
+		d, err := time.ParseDuration(c.ReloadInterval)
+		if err != nil {
+			logger, _ := zap.NewProduction()
+			defer logger.Sync()
+			logger.Error(fmt.Sprintf("invalid reloadInterval %s: %s", c.ReloadInterval, err))
+		} else {
+			app.ReloadInterval = d
+		}
+	}
+	if c.ShutdownGracePeriod != "" {
+		d, err := time.ParseDuration(c.ShutdownGracePeriod)
+		if err != nil {
+			logger, _ := zap.NewProduction()
+			defer logger.Sync()
+			logger.Error(fmt.Sprintf("invalid shutdownGracePeriod %s: %s", c.ShutdownGracePeriod, err))
+		} else {
+			app.ShutdownGracePeriod = d
+		}
+	}
 }
 
+// RegisterWorkflow registers a workflow definition. Registering another version of a registered workflow keeps
+// the earlier ones loaded for the workflow objects started with them; new workflow objects start on the version
+// registered last.
 func (app *App) RegisterWorkflow(definition func() Workflow) {
 	workflowInstance := CreateWorkflowInstance()
 	workflowInstance.RegisterWorkflowDefinition(definition)
-	app.WorkflowInstances = append(app.WorkflowInstances, workflowInstance)
+	workflowInstance.app = app
+	app.addWorkflowInstance(workflowInstance)
+}
+
+// get the current workflow instance by workflow name, which is the version registered last that is not retired.
+func (app *App) getWorkflowInstance(name string) *WorkflowInstance {
+	instances := app.workflowInstances()
+	for i := len(instances) - 1; i >= 0; i-- {
+		if instances[i].Workflow.Name == name && !instances[i].retired {
+			return &instances[i]
+		}
+	}
+	return nil
 }
 
 func ParseTrigger(t TriggerCondition, e Event) (bool, error) {
@@ -220,19 +323,80 @@ func getFiledValueByJsonPath(e Event, fieldInput string) (string, error) {
 	return out, nil
 }
 
+// Start runs the engine until the process receives SIGTERM or SIGINT.
 func (app *App) Start() {
+	logger, _ := zap.NewProduction()
+	defer logger.Sync()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		s := <-signals
+		logger.Info(fmt.Sprintf("Received signal %s, shutting down", s))
+		cancel()
+	}()
+	err := app.Run(ctx)
+	if err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+// Run starts the engine and blocks until ctx is cancelled. On cancellation the watches are stopped, in-flight
+// steps get ShutdownGracePeriod to finish, and whatever still runs after that, including executor HTTP calls,
+// is cancelled.
+func (app *App) Run(ctx context.Context) error {
+	logger, _ := zap.NewProduction()
+	defer logger.Sync()
 	kubeconfig := util.GetKubeConfig()
 	namespace := "default"
 	app.StartAt = time.Now()
-	var gvrList []GVR
-	for _, element := range app.ModelGVRMap {
-		gvrList = append(gvrList, element)
+	app.kubeconfig = kubeconfig
+	// in-flight work outlives ctx by the grace period, so it runs on its own context.
+	workCtx, cancelWork := context.WithCancel(context.Background())
+	defer cancelWork()
+	app.ctx = workCtx
+	app.pool = newWorkerPool(app.Concurrency)
+	for _, wi := range app.workflowInstances() {
+		app.pool.setWorkflowLimit(wi.Workflow.Name, wi.Workflow.MaxConcurrency)
+	}
+	// every run serves its own mux, so the engine can be run again in the same process.
+	mux := http.NewServeMux()
+	app.registerHandlers(mux)
+	go func() {
+		err := healthCheck.HealthCheck(workCtx, kubeconfig, mux)
+		if err != nil {
+			logger.Fatal(err.Error())
+		}
+	}()
+	go app.monitorHeartbeats(workCtx)
+	go app.monitorWaits(workCtx)
+	go app.watchWorkflowObjects(ctx, workCtx)
+	if app.WatchDefinitions {
+		go app.watchWorkflowDefinitions(ctx)
+	}
+	if len(app.workflowPaths) > 0 || app.configFile != "" {
+		go app.watchFiles(ctx)
 	}
-	ch := BulkWatchObject(kubeconfig, namespace, gvrList)
-	triggerWorkflow(kubeconfig, ch, app)
+	// the model watches follow the GVR map as the config file is reloaded.
+	app.models = newModelWatches(ctx, func(ctx context.Context, gvr GVR) (<-chan watch.Event, error) {
+		return util.StartWatchObject(ctx, kubeconfig, namespace, gvr.Group, gvr.Version, gvr.Resource)
+	})
+	app.models.update(logger, app.modelGVRs())
+	// the model events close once ctx is cancelled and every model watch has stopped.
+	triggerWorkflow(workCtx, kubeconfig, app.models.events, app)
+
+	logger.Info("Watches stopped, draining in-flight steps")
+	if !app.pool.wait(app.ShutdownGracePeriod) {
+		logger.Warn("Shutdown grace period expired, cancelling in-flight steps")
+		cancelWork()
+		app.pool.wait(shutdownCancelTimeout)
+	}
+	app.pool.stop()
+	return nil
 }
 
-func triggerWorkflow(kubeconfig *string, ch <-chan watch.Event, app *App) {
+func triggerWorkflow(ctx context.Context, kubeconfig *string, ch <-chan watch.Event, app *App) {
 	logger, _ := zap.NewProduction()
 	sugarLogger := logger.Sugar()
 	defer sugarLogger.Sync()
@@ -266,16 +430,29 @@ func triggerWorkflow(kubeconfig *string, ch <-chan watch.Event, app *App) {
 			Name:    objName,
 			Version: objVersion,
 		}
-		for _, wi := range app.WorkflowInstances {
-			go triggerWorkflowInstance(kubeconfig, objName, wi, e)
+		for _, wi := range app.workflowInstances() {
+			wi := wi
+			app.pool.submit(wi.Workflow.Name, func() {
+				triggerWorkflowInstance(ctx, kubeconfig, objName, wi, e)
+			})
 		}
 	}
 }
 
-func triggerWorkflowInstance(kubeconfig *string, objName string, wi WorkflowInstance, e Event) {
+// newHandler returns the handler steps of a workflow object run with.
+func newHandler(kubeconfig *string, wfObjName string) handler.Handler {
+	var fd flowdata.FlowData
+	fd.Kubeconfig = kubeconfig
+	fd.WFObjName = wfObjName
+	var h handler.Handler
+	h.FlowData = fd
+	return h
+}
+
+func triggerWorkflowInstance(ctx context.Context, kubeconfig *string, objName string, wi WorkflowInstance, e Event) {
 	logger, _ := zap.NewProduction()
 	defer logger.Sync()
-	isWorkflowTriggered, err := util.CheckIfWorkflowIsTriggered(kubeconfig, objName)
+	isWorkflowTriggered, err := util.CheckIfWorkflowIsTriggered(ctx, kubeconfig, objName)
 	if err != nil {
 		logger.Error(err.Error(),
 			zap.String("Kind", e.Kind),
@@ -285,9 +462,21 @@ func triggerWorkflowInstance(kubeconfig *string, objName string, wi WorkflowInst
 	}
 	if isWorkflowTriggered {
 		for stepName, stepTriggerConditions := range wi.StepTriggers {
-			go handlePendingStepsTrigger(wi, kubeconfig, logger, stepName, stepTriggerConditions, objName, e)
+			stepName, stepTriggerConditions := stepName, stepTriggerConditions
+			wi.spawn(func() {
+				handlePendingStepsTrigger(ctx, wi, kubeconfig, logger, stepName, stepTriggerConditions, objName, e)
+			})
 		}
-	} else {
+		for stepName, step := range wi.Workflow.Steps {
+			if step.Type != "approval" || step.StepTrigger.Model == "" {
+				continue
+			}
+			stepName := stepName
+			wi.spawn(func() {
+				handleApprovalTrigger(ctx, wi, kubeconfig, logger, stepName, objName, e)
+			})
+		}
+	} else if wi.isCurrent() {
 		result, err := ParseTrigger(wi.Workflow.Trigger, e)
 		if err != nil {
 			logger.Warn(err.Error(),
@@ -299,24 +488,20 @@ func triggerWorkflowInstance(kubeconfig *string, objName string, wi WorkflowInst
 		if result {
 			startAt := wi.Workflow.StartAt
 			wfObjName := util.GenerateWorkflowObjName()
-			var fd flowdata.FlowData
-			fd.Kubeconfig = kubeconfig
-			fd.WFObjName = wfObjName
-			var h handler.Handler
-			h.FlowData = fd
-			err := util.CreateEmptyWorkflowObject(kubeconfig, wfObjName, objName)
+			h := newHandler(kubeconfig, wfObjName)
+			err := util.CreateEmptyWorkflowObject(ctx, kubeconfig, wfObjName, objName, wi.Workflow.Name, wi.Workflow.Version, wi.hash)
 			if err != nil {
 				logger.Error(err.Error())
 			} else {
 				var emptyNextMatchedSteps []NextStep
-				wi.ExecuteWorkflow(kubeconfig, logger, h, wfObjName, startAt, false, emptyNextMatchedSteps)
+				wi.ExecuteWorkflow(ctx, kubeconfig, logger, h, wfObjName, startAt, false, emptyNextMatchedSteps)
 			}
 
 		}
 	}
 }
 
-func handlePendingStepsTrigger(wi WorkflowInstance, kubeconfig *string, logger *zap.Logger, stepName string, stepTriggerConditions []TriggerCondition, objName string, e Event) {
+func handlePendingStepsTrigger(ctx context.Context, wi WorkflowInstance, kubeconfig *string, logger *zap.Logger, stepName string, stepTriggerConditions []TriggerCondition, objName string, e Event) {
 	var nextMatchedSteps []NextStep
 	for _, stepTriggerCondition := range stepTriggerConditions {
 		result, err := ParseTrigger(stepTriggerCondition, e)
@@ -334,7 +519,7 @@ func handlePendingStepsTrigger(wi WorkflowInstance, kubeconfig *string, logger *
 		}
 	}
 	if len(nextMatchedSteps) > 0 {
-		objList, err := util.GetPendingWorkflowList(kubeconfig, objName, stepName)
+		objList, err := util.GetPendingWorkflowList(ctx, kubeconfig, objName, stepName)
 		if err != nil {
 			logger.Error(err.Error(),
 				zap.String("Kind", e.Kind),
@@ -345,48 +530,23 @@ func handlePendingStepsTrigger(wi WorkflowInstance, kubeconfig *string, logger *
 		}
 		for _, obj := range objList.Items {
 			wfObjName := obj.GetName()
-			var fd flowdata.FlowData
-			fd.Kubeconfig = kubeconfig
-			fd.WFObjName = wfObjName
-			var h handler.Handler
-			h.FlowData = fd
+			if !wi.runs(&obj) {
+				continue
+			}
+			// manual step triggers are ignored while the instance is paused.
+			if paused, _, _ := unstructured.NestedBool(obj.Object, "spec", "paused"); paused {
+				logInfo(logger, wfObjName, stepName, "workflow is paused, ignoring step trigger")
+				continue
+			}
+			// the step may have been resolved by a signal in the meantime.
+			executionID, err := util.ClaimWorkflowObjectPendingStep(ctx, kubeconfig, wfObjName, stepName)
+			if err != nil {
+				logInfo(logger, wfObjName, stepName, err.Error())
+				continue
+			}
+			h := newHandler(kubeconfig, wfObjName)
 			steps := []string{stepName}
-			wi.ExecuteWorkflow(kubeconfig, logger, h, wfObjName, steps, true, nextMatchedSteps)
+			wi.ExecuteWorkflow(util.WithExecution(ctx, executionID, ""), kubeconfig, logger, h, wfObjName, steps, true, nextMatchedSteps)
 		}
 	}
 }
-
-func BulkWatchObject(kubeconfig *string, namespace string, gvrList []GVR) <-chan watch.Event {
-	var chans []<-chan watch.Event
-	logger, _ := zap.NewProduction()
-	defer logger.Sync()
-	for _, gvr := range gvrList {
-		message := fmt.Sprintf("Start Watching Resource Group: %s, Version: %s, Resource: %s", gvr.Group, gvr.Version, gvr.Resource)
-		logger.Info(message)
-		chans = append(chans, util.WatchObject(kubeconfig, namespace, gvr.Group, gvr.Version, gvr.Resource))
-	}
-	ch := mergeWatchChannels(chans)
-	go healthCheck.HealthCheck(kubeconfig)
-	return ch
-}
-
-func mergeWatchChannels(chans []<-chan watch.Event) <-chan watch.Event {
-	ch := make(chan watch.Event)
-	go func() {
-		var wg sync.WaitGroup
-		wg.Add(len(chans))
-
-		for _, c := range chans {
-			go func(c <-chan watch.Event) {
-				for v := range c {
-					ch <- v
-				}
-				wg.Done()
-			}(c)
-		}
-
-		wg.Wait()
-		close(ch)
-	}()
-	return ch
-}
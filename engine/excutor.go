@@ -1,6 +1,8 @@
 package engine
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,126 +11,125 @@ import (
 	"github.com/flintdev/workflow-engine/util"
 	"go.uber.org/zap"
 	"io/ioutil"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"net/http"
 	"strings"
+	"time"
 )
 
+// ExecutorRequest is the JSON body posted to the executor for every step invocation.
+type ExecutorRequest struct {
+	Workflow        string `json:"workflow"`
+	WorkflowVersion string `json:"workflowVersion,omitempty"`
+	Step            string `json:"step"`
+	ObjName         string `json:"objName"`
+	Group           string `json:"group"`
+	Version         string `json:"version"`
+	Resource        string `json:"resource"`
+	Namespace       string `json:"namespace"`
+	Attempt         int64  `json:"attempt"`
+	IdempotencyKey  string `json:"idempotencyKey"`
+	ExecutionID     string `json:"executionId"`
+	TaskToken       string `json:"taskToken"`
+	CallbackURL     string `json:"callbackUrl"`
+	Item            string `json:"item,omitempty"`
+	ItemIndex       *int   `json:"itemIndex,omitempty"`
+	Compensates     string `json:"compensates,omitempty"`
+}
+
 type ExecutorResponse struct {
-	Message string `json:"message"`
-	Status  string `json:"status"`
+	Message    string `json:"message"`
+	Status     string `json:"status"`
+	TaskToken  string `json:"taskToken"`
+	Result     string `json:"result"`
+	ErrorClass string `json:"errorClass"`
 }
 
-func (wi *WorkflowInstance) ExecuteWorkflow(kubeconfig *string, logger *zap.Logger, handler handler.Handler, wfObjName string, steps []string, isPendingManualStep bool, nextMatchedSteps []NextStep) {
+func (wi *WorkflowInstance) ExecuteWorkflow(ctx context.Context, kubeconfig *string, logger *zap.Logger, handler handler.Handler, wfObjName string, steps []string, isPendingManualStep bool, nextMatchedSteps []NextStep) {
+	ctx = wi.instanceContext(ctx, wfObjName)
 	stepsString := strings.Join(steps[:], ",")
 	logInfo(logger, wfObjName, stepsString, "Start Executing Workflow")
-	err := util.SetWorkflowObjectToRunning(kubeconfig, wfObjName)
+	err := util.SetWorkflowObjectToRunning(ctx, kubeconfig, wfObjName)
 	if err != nil {
 		logError(logger, wfObjName, stepsString, err.Error())
-		err := util.SetWorkflowObjectToFailure(kubeconfig, wfObjName, err.Error())
-		if err != nil {
-			logError(logger, wfObjName, stepsString, err.Error())
-			return
-		}
+		wi.failWorkflow(ctx, kubeconfig, logger, wfObjName, stepsString, err.Error())
 		return
 	}
 	for _, stepName := range steps {
-		go executeStep(kubeconfig, wi, logger, wfObjName, stepName, handler, isPendingManualStep, nextMatchedSteps)
+		stepName := stepName
+		wi.spawn(func() {
+			executeStep(ctx, kubeconfig, wi, logger, wfObjName, stepName, handler, isPendingManualStep, nextMatchedSteps)
+		})
 	}
 }
-func executeStep(kubeconfig *string, wi *WorkflowInstance, logger *zap.Logger, wfObjName string, stepName string, handler handler.Handler, isPendingManualStep bool, nextMatchedSteps []NextStep) {
-	// handle hub step
-	var emptyNextMatchedSteps []NextStep
-	if wi.Workflow.Steps[stepName].Type == "hub" {
-		inputStepsList := wi.Workflow.Steps[stepName].Inputs
-		condition := wi.Workflow.Steps[stepName].Condition
-		hubStatus, err := util.CheckAllStepStatusByList(kubeconfig, wfObjName, inputStepsList)
+func executeStep(ctx context.Context, kubeconfig *string, wi *WorkflowInstance, logger *zap.Logger, wfObjName string, stepName string, handler handler.Handler, isPendingManualStep bool, nextMatchedSteps []NextStep) {
+	// the instance was cancelled or the engine is shutting down.
+	if ctx.Err() != nil {
+		return
+	}
+	// a paused instance records the step and picks it up again on resume.
+	if !isPendingManualStep {
+		deferred, err := util.DeferWorkflowObjectStepIfPaused(ctx, kubeconfig, wfObjName, stepName)
 		if err != nil {
 			logError(logger, wfObjName, stepName, err.Error())
-			err := util.SetWorkflowObjectFailedStep(kubeconfig, wfObjName, stepName, err.Error())
-			if err != nil {
-				logError(logger, wfObjName, stepName, err.Error())
-				return
-			}
 			return
 		}
-		if hubStatus != condition {
+		if deferred {
+			logInfo(logger, wfObjName, stepName, "workflow is paused, step deferred")
 			return
 		}
+		// every run of a step is a new execution, a child of the execution that led to it.
+		ctx = util.WithExecution(ctx, util.GenerateExecutionID(), util.ExecutionID(ctx))
+	}
+	// handle hub step
+	if wi.Workflow.Steps[stepName].Type == "hub" {
+		wi.joinHub(ctx, kubeconfig, logger, wfObjName, stepName, handler)
+		return
 	}
 	if isPendingManualStep {
 		logInfo(logger, wfObjName, stepName, "start running step")
-		err := util.SetWorkflowObjectStepToRunning(kubeconfig, wfObjName, stepName, "")
+		entry, err := util.GetWorkflowObjectStep(ctx, kubeconfig, wfObjName, stepName)
+		if err == nil {
+			ctx = executionContext(ctx, entry)
+		}
+		err = util.SetWorkflowObjectStepToRunning(ctx, kubeconfig, wfObjName, stepName, "")
 		if err != nil {
 			logError(logger, wfObjName, stepName, err.Error())
-			err := util.SetWorkflowObjectToFailure(kubeconfig, wfObjName, err.Error())
-			if err != nil {
-				logError(logger, wfObjName, stepName, err.Error())
-				return
-			}
+			wi.failWorkflow(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
 			return
 		}
-		err = util.RemoveWorkflowObjectPendingStepLabel(kubeconfig, wfObjName, stepName)
+		err = util.RemoveWorkflowObjectPendingStepLabel(ctx, kubeconfig, wfObjName, stepName)
 		if err != nil {
 			logError(logger, wfObjName, stepName, err.Error())
-			err := util.SetWorkflowObjectToFailure(kubeconfig, wfObjName, err.Error())
-			if err != nil {
-				logError(logger, wfObjName, stepName, err.Error())
-				return
-			}
+			wi.failWorkflow(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
 			return
 		}
-		err = util.SetWorkflowObjectStepToComplete(kubeconfig, wfObjName, stepName, "")
+		err = util.SetWorkflowObjectStepToComplete(ctx, kubeconfig, wfObjName, stepName, "")
 		if err != nil {
 			logError(logger, wfObjName, stepName, err.Error())
-			err := util.SetWorkflowObjectStepToFailure(kubeconfig, wfObjName, stepName, err.Error())
+			err := util.SetWorkflowObjectStepToFailure(ctx, kubeconfig, wfObjName, stepName, err.Error())
 			if err != nil {
 				logError(logger, wfObjName, stepName, err.Error())
 				return
 			}
 		}
-		if len(nextMatchedSteps) == 1 && len(wi.Workflow.Steps[nextMatchedSteps[0].Name].NextSteps) == 0 {
-			wfStatus, err := util.GetWorkflowObjectStatus(kubeconfig, wfObjName)
-			if err != nil {
-				logError(logger, wfObjName, stepName, err.Error())
-				err := util.SetWorkflowObjectToFailure(kubeconfig, wfObjName, err.Error())
-				if err != nil {
-					logError(logger, wfObjName, stepName, err.Error())
-					return
-				}
-				return
-			}
-			if wfStatus == "Failure" {
-				return
-			}
-			err = checkAllExistingStepsStatus(kubeconfig, logger, wfObjName, stepName)
-			if err != nil {
-				return
-			}
-			return
-		} else {
-			for _, step := range nextMatchedSteps {
-				go executeStep(kubeconfig, wi, logger, wfObjName, step.Name, handler, false, emptyNextMatchedSteps)
-			}
-			return
-		}
+		wi.runNextSteps(ctx, kubeconfig, logger, wfObjName, stepName, handler, nextMatchedSteps)
+		return
 	} else {
 		// handle manual step. Return and wait for trigger
-		if wi.Workflow.Steps[stepName].Type == "manual" {
+		if stepType := wi.Workflow.Steps[stepName].Type; stepType == "manual" || stepType == "approval" {
 			logInfo(logger, wfObjName, stepName, "pending on manual step")
-			err := util.SetPendingStepToWorkflowObject(kubeconfig, stepName, wfObjName)
+			err := util.SetPendingStepToWorkflowObject(ctx, kubeconfig, stepName, wfObjName)
 			if err != nil {
 				logError(logger, wfObjName, stepName, err.Error())
-				err := util.SetWorkflowObjectToFailure(kubeconfig, wfObjName, err.Error())
-				if err != nil {
-					logError(logger, wfObjName, stepName, err.Error())
-					return
-				}
+				wi.failWorkflow(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
 				return
 			}
-			err = util.SetWorkflowObjectPendingStepLabel(kubeconfig, wfObjName, stepName)
+			wi.recordLoopIteration(ctx, kubeconfig, logger, wfObjName, stepName)
+			err = util.SetWorkflowObjectPendingStepLabel(ctx, kubeconfig, wfObjName, stepName)
 			if err != nil {
 				logError(logger, wfObjName, stepName, err.Error())
-				err := util.SetWorkflowObjectFailedStep(kubeconfig, wfObjName, stepName, err.Error())
+				err := util.SetWorkflowObjectFailedStep(ctx, kubeconfig, wfObjName, stepName, err.Error())
 				if err != nil {
 					logError(logger, wfObjName, stepName, err.Error())
 					return
@@ -138,177 +139,394 @@ func executeStep(kubeconfig *string, wi *WorkflowInstance, logger *zap.Logger, w
 			return
 		}
 		logInfo(logger, wfObjName, stepName, "start running step")
-		err := util.SetStepToWorkflowObject(kubeconfig, stepName, wfObjName)
+		err := util.SetStepToWorkflowObject(ctx, kubeconfig, stepName, wfObjName)
 		if err != nil {
 			logError(logger, wfObjName, stepName, err.Error())
-			err := util.SetWorkflowObjectToFailure(kubeconfig, wfObjName, err.Error())
-			if err != nil {
-				logError(logger, wfObjName, stepName, err.Error())
-				return
-			}
+			wi.failWorkflow(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
 			return
 		}
 	}
 
-	// start executing step
-	url := fmt.Sprintf("http://python-executor:8080/execute?workflow=%s&step=%s&obj_name=%s&group=%s&version=%s&resource=%s&namespace=%s",
-		wi.Workflow.Name, stepName, wfObjName, util.WFGroup, util.WFVersion, util.WFResource, util.WFNamespace)
-	message := fmt.Sprintf("Sent GET request to %s", url)
-	logInfo(logger, wfObjName, stepName, message)
-	response, err := http.Get(url)
+	if wi.Workflow.Steps[stepName].Type == "loop" {
+		wi.runLoop(ctx, kubeconfig, logger, wfObjName, stepName, handler)
+		return
+	}
+	wi.recordLoopIteration(ctx, kubeconfig, logger, wfObjName, stepName)
+	if wi.Workflow.Steps[stepName].Type == "wait" {
+		wi.startWait(ctx, kubeconfig, logger, wfObjName, stepName, handler)
+		return
+	}
+	if wi.Workflow.Steps[stepName].Type == "subworkflow" {
+		wi.startSubWorkflow(ctx, kubeconfig, logger, wfObjName, stepName)
+		return
+	}
+	if wi.Workflow.Steps[stepName].Type == "map" {
+		wi.startMap(ctx, kubeconfig, logger, wfObjName, stepName, handler)
+		return
+	}
+	if wi.Workflow.Steps[stepName].isEnd() {
+		wi.runEnd(ctx, kubeconfig, logger, wfObjName, stepName)
+		return
+	}
+	wi.invokeExecutor(ctx, kubeconfig, logger, wfObjName, stepName, handler)
+}
+
+// get a context addressing the execution recorded in a step entry of the workflow object.
+func executionContext(ctx context.Context, entry map[string]interface{}) context.Context {
+	id, _ := entry["id"].(string)
+	if id == "" {
+		return ctx
+	}
+	parentID, _ := entry["parentId"].(string)
+	return util.WithExecution(ctx, id, parentID)
+}
+
+// get the attempt of the execution recorded in a step entry and its idempotency key, derived from the workflow
+// object, keyName and the attempt. Every iteration of a loop body runs the same attempt of its steps, so the
+// key of an execution in a loop also includes its iteration.
+func executionKey(wfObjName string, keyName string, entry map[string]interface{}) (int64, string) {
+	attempt, found, _ := unstructured.NestedInt64(entry, "attempt")
+	if !found {
+		attempt = 1
+	}
+	if iteration, _, _ := unstructured.NestedInt64(entry, "iteration"); iteration > 0 {
+		keyName = fmt.Sprintf("%s#%d", keyName, iteration)
+	}
+	return attempt, util.GenerateIdempotencyKey(wfObjName, keyName, attempt)
+}
+
+// send the step to the executor. A synchronous executor replies with the step result, an
+// asynchronous one replies 202 Accepted and reports the result later through the callback endpoint.
+func (wi *WorkflowInstance) invokeExecutor(ctx context.Context, kubeconfig *string, logger *zap.Logger, wfObjName string, stepName string, handler handler.Handler) {
+	entry, err := util.GetWorkflowObjectStep(ctx, kubeconfig, wfObjName, stepName)
 	if err != nil {
-		message := fmt.Sprintf("The HTTP request failed with error %s", err)
-		if err != nil {
-			logError(logger, wfObjName, stepName, err.Error())
-			err := util.SetWorkflowObjectFailedStep(kubeconfig, wfObjName, stepName, message)
-			if err != nil {
-				logError(logger, wfObjName, stepName, err.Error())
-				return
-			}
+		logError(logger, wfObjName, stepName, err.Error())
+		wi.failStep(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
+		return
+	}
+	attempt, idempotencyKey := executionKey(wfObjName, stepName, entry)
+	taskToken := util.GenerateTaskToken()
+	fields := map[string]interface{}{"taskToken": taskToken, "idempotencyKey": idempotencyKey}
+	if timeout := wi.Workflow.Steps[stepName].HeartbeatTimeout; timeout != "" {
+		if _, err := time.ParseDuration(timeout); err != nil {
+			message := fmt.Sprintf("invalid heartbeatTimeout %s: %s", timeout, err)
+			logError(logger, wfObjName, stepName, message)
+			wi.failStep(ctx, kubeconfig, logger, wfObjName, stepName, message)
 			return
 		}
-	} else {
-		data, err := ioutil.ReadAll(response.Body)
-		if err != nil {
-			logError(logger, wfObjName, stepName, err.Error())
-			err := util.SetWorkflowObjectFailedStep(kubeconfig, wfObjName, stepName, err.Error())
-			if err != nil {
-				logError(logger, wfObjName, stepName, err.Error())
-				return
-			}
-			return
+		fields["heartbeatTimeout"] = timeout
+		fields["heartbeat"] = map[string]interface{}{
+			"progress": int64(0),
+			"details":  "",
+			"at":       time.Now().UTC().Format(time.RFC3339),
 		}
+	}
+	err = util.SetWorkflowObjectStepFields(ctx, kubeconfig, wfObjName, stepName, fields)
+	if err != nil {
+		logError(logger, wfObjName, stepName, err.Error())
+		wi.failStep(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
+		return
+	}
+	body, err := json.Marshal(ExecutorRequest{
+		Workflow:        wi.Workflow.Name,
+		WorkflowVersion: wi.Workflow.Version,
+		Step:            stepName,
+		ObjName:         wfObjName,
+		Group:           util.WFGroup,
+		Version:         util.WFVersion,
+		Resource:        util.WFResource,
+		Namespace:       util.WFNamespace,
+		Attempt:         attempt,
+		IdempotencyKey:  idempotencyKey,
+		ExecutionID:     util.ExecutionID(ctx),
+		TaskToken:       taskToken,
+		CallbackURL:     wi.executorConfig().CallbackURL,
+	})
+	if err != nil {
+		logError(logger, wfObjName, stepName, err.Error())
+		wi.failStep(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
+		return
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, wi.executorConfig().URL, bytes.NewReader(body))
+	if err != nil {
+		logError(logger, wfObjName, stepName, err.Error())
+		wi.failStep(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
+		return
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Idempotency-Key", idempotencyKey)
+	message := fmt.Sprintf("Sent POST request to %s with idempotency key %s", wi.executorConfig().URL, idempotencyKey)
+	logInfo(logger, wfObjName, stepName, message)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		message := fmt.Sprintf("The HTTP request failed with error %s", err)
+		logError(logger, wfObjName, stepName, message)
+		wi.failStep(ctx, kubeconfig, logger, wfObjName, stepName, message)
+		return
+	}
+	defer response.Body.Close()
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		logError(logger, wfObjName, stepName, err.Error())
+		wi.failStep(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
+		return
+	}
+	if response.StatusCode == http.StatusAccepted {
+		// the executor took the step over; keep it Running until the callback arrives.
 		r, err := ParseExecutorResponse(data)
-		if err != nil {
-			logError(logger, wfObjName, stepName, err.Error())
-			err := util.SetWorkflowObjectStepToFailure(kubeconfig, wfObjName, stepName, err.Error())
+		if err == nil && r.TaskToken != "" && r.TaskToken != taskToken {
+			err = util.SetWorkflowObjectStepTaskToken(ctx, kubeconfig, wfObjName, stepName, r.TaskToken)
 			if err != nil {
 				logError(logger, wfObjName, stepName, err.Error())
+				wi.failStep(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
 				return
 			}
-			return
 		}
-		nextSteps, err := getNextSteps(wi, r, stepName, handler)
+		logInfo(logger, wfObjName, stepName, "step accepted by executor, waiting for callback")
+		return
+	}
+	_, err = util.ClaimWorkflowObjectStepTaskToken(ctx, kubeconfig, wfObjName, stepName, taskToken)
+	if err != nil {
+		logError(logger, wfObjName, stepName, err.Error())
+		return
+	}
+	r, err := ParseExecutorResponse(data)
+	if err != nil {
+		logError(logger, wfObjName, stepName, err.Error())
+		wi.failStep(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
+		return
+	}
+	wi.handleExecutorResponse(ctx, kubeconfig, logger, wfObjName, stepName, handler, r)
+}
+
+// send a request the executor must answer synchronously, filling in the workflow and resource fields, and
+// return its response.
+func (wi *WorkflowInstance) callExecutor(ctx context.Context, r ExecutorRequest) (ExecutorResponse, error) {
+	var executorResponse ExecutorResponse
+	code, data, err := wi.postExecutor(ctx, r)
+	if err != nil {
+		return executorResponse, err
+	}
+	if code == http.StatusAccepted {
+		return executorResponse, errors.New("the executor must answer this request synchronously, it replied 202 Accepted")
+	}
+	return ParseExecutorResponse(data)
+}
+
+// post a request to the executor, filling in the workflow and resource fields, and return the status code
+// and body of its reply.
+func (wi *WorkflowInstance) postExecutor(ctx context.Context, r ExecutorRequest) (int, []byte, error) {
+	r.Workflow = wi.Workflow.Name
+	r.WorkflowVersion = wi.Workflow.Version
+	r.Group = util.WFGroup
+	r.Version = util.WFVersion
+	r.Resource = util.WFResource
+	r.Namespace = util.WFNamespace
+	r.ExecutionID = util.ExecutionID(ctx)
+	body, err := json.Marshal(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, wi.executorConfig().URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Idempotency-Key", r.IdempotencyKey)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		message := fmt.Sprintf("The HTTP request failed with error %s", err)
+		return 0, nil, errors.New(message)
+	}
+	defer response.Body.Close()
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return response.StatusCode, data, nil
+}
+
+// complete the step with the executor result and move on to the next steps.
+func (wi *WorkflowInstance) handleExecutorResponse(ctx context.Context, kubeconfig *string, logger *zap.Logger, wfObjName string, stepName string, handler handler.Handler, r ExecutorResponse) {
+	if r.Status != "success" {
+		logError(logger, wfObjName, stepName, r.Message)
+		wi.failStepWithClass(ctx, kubeconfig, logger, wfObjName, stepName, r.Message, r.ErrorClass)
+		return
+	}
+	nextSteps, err := getNextSteps(ctx, wi, r, stepName, handler)
+	if err != nil {
+		logError(logger, wfObjName, stepName, err.Error())
+		wi.failStep(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
+		return
+	}
+	wi.completeStep(ctx, kubeconfig, logger, wfObjName, stepName, handler, nextSteps, "")
+}
+
+// complete the step and run the given next steps, or settle the workflow status when there are none left.
+func (wi *WorkflowInstance) completeStep(ctx context.Context, kubeconfig *string, logger *zap.Logger, wfObjName string, stepName string, handler handler.Handler, nextSteps []NextStep, message string) {
+	err := util.SetWorkflowObjectStepToComplete(ctx, kubeconfig, wfObjName, stepName, message)
+	if err != nil {
+		logError(logger, wfObjName, stepName, err.Error())
+		err := util.SetWorkflowObjectStepToFailure(ctx, kubeconfig, wfObjName, stepName, err.Error())
 		if err != nil {
 			logError(logger, wfObjName, stepName, err.Error())
-			err := util.SetWorkflowObjectStepToFailure(kubeconfig, wfObjName, stepName, err.Error())
-			if err != nil {
-				logError(logger, wfObjName, stepName, err.Error())
-				return
-			}
-			if len(nextSteps) == 0 {
-				err = checkAllExistingStepsStatus(kubeconfig, logger, wfObjName, stepName)
-				if err != nil {
-					return
-				}
-			}
 			return
 		}
-		err = util.SetWorkflowObjectStepToComplete(kubeconfig, wfObjName, stepName, "")
-		if err != nil {
-			logError(logger, wfObjName, stepName, err.Error())
-			err := util.SetWorkflowObjectStepToFailure(kubeconfig, wfObjName, stepName, err.Error())
-			if err != nil {
-				logError(logger, wfObjName, stepName, err.Error())
-				return
-			}
-		}
+	}
+	wi.runNextSteps(ctx, kubeconfig, logger, wfObjName, stepName, handler, nextSteps)
+}
 
-		// check if next step is end
-		if len(nextSteps) == 1 && len(wi.Workflow.Steps[nextSteps[0].Name].NextSteps) == 0 {
-			wfStatus, err := util.GetWorkflowObjectStatus(kubeconfig, wfObjName)
-			if err != nil {
-				logError(logger, wfObjName, stepName, err.Error())
-				err := util.SetWorkflowObjectToFailure(kubeconfig, wfObjName, err.Error())
-				if err != nil {
-					logError(logger, wfObjName, stepName, err.Error())
-					return
-				}
-				return
-			}
-			if wfStatus == "Failure" {
-				return
-			}
-			err = checkAllExistingStepsStatus(kubeconfig, logger, wfObjName, stepName)
-			if err != nil {
-				return
-			}
-		} else {
-			for _, step := range nextSteps {
-				go executeStep(kubeconfig, wi, logger, wfObjName, step.Name, handler, false, emptyNextMatchedSteps)
-			}
+// run the next steps of a finished step, or settle the workflow status when there are none left.
+func (wi *WorkflowInstance) runNextSteps(ctx context.Context, kubeconfig *string, logger *zap.Logger, wfObjName string, stepName string, handler handler.Handler, nextSteps []NextStep) {
+	var emptyNextMatchedSteps []NextStep
+	if len(nextSteps) == 0 {
+		// a step whose next steps all have unmet conditions is a dead end, the branch can never finish.
+		if len(wi.Workflow.Steps[stepName].NextSteps) > 0 {
+			message := fmt.Sprintf("dead end: none of the next steps of step %s matched", stepName)
+			logError(logger, wfObjName, stepName, message)
+			wi.failStep(ctx, kubeconfig, logger, wfObjName, stepName, message)
+			return
 		}
+		wi.finishBranch(ctx, kubeconfig, logger, wfObjName, stepName)
+		return
+	}
+	for _, step := range nextSteps {
+		step := step
+		wi.spawn(func() {
+			executeStep(ctx, kubeconfig, wi, logger, wfObjName, step.Name, handler, false, emptyNextMatchedSteps)
+		})
+	}
+}
+
+// settle the workflow status once a branch has reached its last step.
+func (wi *WorkflowInstance) finishBranch(ctx context.Context, kubeconfig *string, logger *zap.Logger, wfObjName string, stepName string) {
+	wfStatus, err := util.GetWorkflowObjectStatus(ctx, kubeconfig, wfObjName)
+	if err != nil {
+		logError(logger, wfObjName, stepName, err.Error())
+		wi.failWorkflow(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
+		return
+	}
+	if wfStatus == "Failure" {
+		return
+	}
+	err = wi.checkAllExistingStepsStatus(ctx, kubeconfig, logger, wfObjName, stepName)
+	if err != nil {
+		return
+	}
+}
+
+// mark the step as failed and settle the workflow status.
+func (wi *WorkflowInstance) failStep(ctx context.Context, kubeconfig *string, logger *zap.Logger, wfObjName string, stepName string, message string) {
+	wi.failStepWithClass(ctx, kubeconfig, logger, wfObjName, stepName, message, "")
+}
+
+// mark the step as failed with the error class reported by the executor, and take its onFailure branch if
+// one matches. Otherwise settle the workflow status.
+func (wi *WorkflowInstance) failStepWithClass(ctx context.Context, kubeconfig *string, logger *zap.Logger, wfObjName string, stepName string, message string, errorClass string) {
+	err := util.SetWorkflowObjectStepToFailure(ctx, kubeconfig, wfObjName, stepName, message)
+	if err != nil {
+		logError(logger, wfObjName, stepName, err.Error())
+		return
+	}
+	if wi.takeFailureBranch(ctx, kubeconfig, logger, wfObjName, stepName, message, errorClass) {
+		return
+	}
+	// a hub joining over the step may still go on without it.
+	wi.joinHubsAfterFailure(ctx, kubeconfig, logger, wfObjName, stepName)
+	err = wi.checkAllExistingStepsStatus(ctx, kubeconfig, logger, wfObjName, stepName)
+	if err != nil {
+		return
+	}
+}
+
+// run a task on the app's worker pool, or on a plain goroutine when the instance is not registered.
+func (wi *WorkflowInstance) spawn(task func()) {
+	if wi.app == nil || wi.app.pool == nil {
+		go task()
+		return
+	}
+	wi.app.pool.spawn(wi.Workflow.Name, task)
+}
+
+func (wi *WorkflowInstance) executorConfig() ExecutorConfig {
+	if wi.app == nil {
+		return ExecutorConfig{URL: defaultExecutorURL, CallbackURL: defaultCallbackURL}
+	}
+	return wi.app.executor()
+}
+
+// fail the workflow object, release its context and compensate its completed steps. Every path that fails a
+// workflow object goes through here.
+func (wi *WorkflowInstance) failWorkflow(ctx context.Context, kubeconfig *string, logger *zap.Logger, wfObjName string, stepName string, message string) error {
+	defer wi.releaseInstanceContext(wfObjName)
+	err := util.SetWorkflowObjectToFailure(ctx, kubeconfig, wfObjName, message)
+	if err != nil {
+		logError(logger, wfObjName, stepName, err.Error())
+		return err
 	}
+	wi.startCompensation(wfObjName)
+	return nil
 }
 
 // check all existing steps status.
-func checkAllExistingStepsStatus(kubeconfig *string, logger *zap.Logger, wfObjName string, stepName string) error {
-	status, message, err := util.CheckAllStepStatus(kubeconfig, wfObjName)
+func (wi *WorkflowInstance) checkAllExistingStepsStatus(ctx context.Context, kubeconfig *string, logger *zap.Logger, wfObjName string, stepName string) error {
+	status, message, err := util.CheckAllStepStatus(ctx, kubeconfig, wfObjName)
 	if err != nil {
 		logError(logger, wfObjName, stepName, err.Error())
-		err := util.SetWorkflowObjectToFailure(kubeconfig, wfObjName, err.Error())
-		if err != nil {
-			logError(logger, wfObjName, stepName, err.Error())
-			return err
-		}
+		wi.failWorkflow(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
 		return err
 	}
 	switch status {
 	case "allCompleteSuccess":
-		err := util.SetWorkflowObjectToComplete(kubeconfig, wfObjName)
+		defer wi.releaseInstanceContext(wfObjName)
+		err := util.SetWorkflowObjectToComplete(ctx, kubeconfig, wfObjName)
 		if err != nil {
 			logError(logger, wfObjName, stepName, err.Error())
-			err := util.SetWorkflowObjectToFailure(kubeconfig, wfObjName, err.Error())
-			if err != nil {
-				logError(logger, wfObjName, stepName, err.Error())
-				return err
-			}
+			wi.failWorkflow(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
 			return err
 		}
 	case "hasRunning":
 	case "hasPending":
 	case "allCompleteHasFailure":
-		err := util.SetWorkflowObjectToFailure(kubeconfig, wfObjName, message)
-		if err != nil {
-			logError(logger, wfObjName, stepName, err.Error())
-			err := util.SetWorkflowObjectToFailure(kubeconfig, wfObjName, err.Error())
-			if err != nil {
-				logError(logger, wfObjName, stepName, err.Error())
-				return err
-			}
-			return err
-		}
+		return wi.failWorkflow(ctx, kubeconfig, logger, wfObjName, stepName, message)
 	}
 	return nil
 }
 
 // get next steps by a given step.
-func getNextSteps(wi *WorkflowInstance, r ExecutorResponse, stepName string, handler handler.Handler) ([]NextStep, error) {
+func getNextSteps(ctx context.Context, wi *WorkflowInstance, r ExecutorResponse, stepName string, handler handler.Handler) ([]NextStep, error) {
 	var nextMatchedSteps []NextStep
 	if r.Status == "success" {
 		nextSteps := wi.Workflow.Steps[stepName].NextSteps
-		for _, step := range nextSteps {
-			if step.When == "" {
-				nextMatchedSteps = append(nextMatchedSteps, step)
-				continue
-			}
-			result, err := parseStepCondition(step.When, handler)
-			if err != nil {
-				return nextMatchedSteps, err
-			}
-			if result {
-				nextMatchedSteps = append(nextMatchedSteps, step)
-			}
-		}
-		return nextMatchedSteps, nil
+		return matchNextSteps(ctx, nextSteps, handler, nil)
 	} else {
 		message := fmt.Sprintf(r.Message)
 		return nextMatchedSteps, errors.New(message)
 	}
 }
 
+// get the next steps whose condition holds on the flow data. Variables take precedence over flow data keys
+// of the same name.
+func matchNextSteps(ctx context.Context, nextSteps []NextStep, handler handler.Handler, variables map[string]interface{}) ([]NextStep, error) {
+	var nextMatchedSteps []NextStep
+	for _, step := range nextSteps {
+		if step.When == "" {
+			nextMatchedSteps = append(nextMatchedSteps, step)
+			continue
+		}
+		result, err := parseStepCondition(ctx, step.When, handler, variables)
+		if err != nil {
+			return nextMatchedSteps, err
+		}
+		if result {
+			nextMatchedSteps = append(nextMatchedSteps, step)
+		}
+	}
+	return nextMatchedSteps, nil
+}
+
 //parse step condition
-func parseStepCondition(input string, handler handler.Handler) (bool, error) {
+func parseStepCondition(ctx context.Context, input string, handler handler.Handler, variables map[string]interface{}) (bool, error) {
 	input = strings.Replace(input, "\"", "'", -1)
 	expression, err := govaluate.NewEvaluableExpression(input)
 	if err != nil {
@@ -327,9 +545,12 @@ func parseStepCondition(input string, handler handler.Handler) (bool, error) {
 		tokenValue := token.(string)
 		parsedTokenValue := util.ParseFlowDataKey(tokenValue)
 		parsedTokenValue = strings.Replace(parsedTokenValue, ".", "_", -1)
-		flowDataResult, err := handler.FlowData.Get(tokenValue)
-		if err != nil {
-			return false, err
+		flowDataResult, exist := variables[util.ParseFlowDataKey(tokenValue)]
+		if !exist {
+			flowDataResult, err = handler.FlowData.Get(ctx, tokenValue)
+			if err != nil {
+				return false, err
+			}
 		}
 		switch flowDataResult.(type) {
 		case string:
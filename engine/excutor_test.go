@@ -0,0 +1,49 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/flintdev/workflow-engine/util"
+)
+
+func TestExecutionKey(t *testing.T) {
+	tests := []struct {
+		name        string
+		entry       map[string]interface{}
+		wantAttempt int64
+		wantKey     string
+	}{
+		{
+			name:        "first attempt",
+			entry:       map[string]interface{}{"name": "step1", "attempt": int64(1)},
+			wantAttempt: 1,
+			wantKey:     util.GenerateIdempotencyKey("wf-1", "step1", 1),
+		},
+		{
+			name:        "entry without attempt",
+			entry:       map[string]interface{}{"name": "step1"},
+			wantAttempt: 1,
+			wantKey:     util.GenerateIdempotencyKey("wf-1", "step1", 1),
+		},
+		{
+			name:        "retry",
+			entry:       map[string]interface{}{"name": "step1", "attempt": int64(2)},
+			wantAttempt: 2,
+			wantKey:     util.GenerateIdempotencyKey("wf-1", "step1", 2),
+		},
+		{
+			name:        "loop iteration",
+			entry:       map[string]interface{}{"name": "step1", "attempt": int64(1), "loop": "loop1", "iteration": int64(3)},
+			wantAttempt: 1,
+			wantKey:     util.GenerateIdempotencyKey("wf-1", "step1#3", 1),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempt, key := executionKey("wf-1", "step1", tt.entry)
+			if attempt != tt.wantAttempt || key != tt.wantKey {
+				t.Errorf("executionKey = %d, %s, want %d, %s", attempt, key, tt.wantAttempt, tt.wantKey)
+			}
+		})
+	}
+}
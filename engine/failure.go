@@ -0,0 +1,40 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"github.com/flintdev/workflow-engine/util"
+	"go.uber.org/zap"
+)
+
+// take the onFailure branch of a failed step. The conditions of the branch can use error.message and
+// error.class besides flow data. It reports whether a branch was taken; the failure is then handled and
+// does not fail the workflow.
+func (wi *WorkflowInstance) takeFailureBranch(ctx context.Context, kubeconfig *string, logger *zap.Logger, wfObjName string, stepName string, message string, errorClass string) bool {
+	onFailure := wi.Workflow.Steps[stepName].OnFailure
+	if len(onFailure) == 0 {
+		return false
+	}
+	h := newHandler(kubeconfig, wfObjName)
+	variables := map[string]interface{}{
+		"error.message": message,
+		"error.class":   errorClass,
+	}
+	nextSteps, err := matchNextSteps(ctx, onFailure, h, variables)
+	if err != nil {
+		logError(logger, wfObjName, stepName, fmt.Sprintf("cannot evaluate onFailure conditions: %s", err))
+		return false
+	}
+	if len(nextSteps) == 0 {
+		return false
+	}
+	fields := map[string]interface{}{"handled": true, "errorClass": errorClass}
+	err = util.SetWorkflowObjectStepFields(ctx, kubeconfig, wfObjName, stepName, fields)
+	if err != nil {
+		logError(logger, wfObjName, stepName, err.Error())
+		return false
+	}
+	logInfo(logger, wfObjName, stepName, "step failed, taking the onFailure branch")
+	wi.runNextSteps(ctx, kubeconfig, logger, wfObjName, stepName, h, nextSteps)
+	return true
+}
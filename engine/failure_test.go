@@ -0,0 +1,102 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestTakeFailureBranch(t *testing.T) {
+	w := Workflow{
+		Name:    "workflow1",
+		StartAt: []string{"step1"},
+		Trigger: TriggerCondition{Model: "model1", EventType: "added"},
+		Steps: map[string]Step{
+			"step1": {OnFailure: []NextStep{
+				{Name: "manualReview", When: "'error.class' == 'InsufficientFunds'"},
+				{Name: "retryLater", When: "'error.message' == 'timeout'"},
+			}},
+			"step2":        {OnFailure: []NextStep{{Name: "notify"}}},
+			"manualReview": {},
+			"retryLater":   {},
+			"notify":       {},
+		},
+	}
+	tests := []struct {
+		name        string
+		step        string
+		message     string
+		errorClass  string
+		wantHandled bool
+		wantSteps   []string
+		wantStatus  string
+	}{
+		{
+			name:        "error.class condition",
+			step:        "step1",
+			message:     "balance too low",
+			errorClass:  "InsufficientFunds",
+			wantHandled: true,
+			wantSteps:   []string{"manualReview"},
+			wantStatus:  "Complete",
+		},
+		{
+			name:        "error.message condition",
+			step:        "step1",
+			message:     "timeout",
+			wantHandled: true,
+			wantSteps:   []string{"retryLater"},
+			wantStatus:  "Complete",
+		},
+		{
+			name:        "unconditional branch",
+			step:        "step2",
+			message:     "boom",
+			wantHandled: true,
+			wantSteps:   []string{"notify"},
+			wantStatus:  "Complete",
+		},
+		{
+			name:       "no branch matches",
+			step:       "step1",
+			message:    "boom",
+			errorClass: "Other",
+			wantStatus: "Failure",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, server, stop := newTestApp(t, w)
+			defer stop()
+			addWorkflowObject(server, app, "wf1", "workflow1", "Running", "",
+				map[string]interface{}{"name": tt.step, "id": "e1", "status": "Running"})
+
+			wi := app.getWorkflowInstance("workflow1")
+			wi.failStepWithClass(app.ctx, app.kubeconfig, zap.NewNop(), "wf1", tt.step, tt.message, tt.errorClass)
+			settle(t, app)
+
+			steps := workflowObjectSteps(t, server, "wf1")
+			var taken []string
+			for _, step := range steps[1:] {
+				taken = append(taken, step["name"].(string))
+			}
+			if !reflect.DeepEqual(taken, tt.wantSteps) {
+				t.Errorf("steps after the failure = %v, want %v", taken, tt.wantSteps)
+			}
+			failed := steps[0]
+			if failed["status"] != "Failure" {
+				t.Errorf("step status = %v, want Failure", failed["status"])
+			}
+			if handled, _ := failed["handled"].(bool); handled != tt.wantHandled {
+				t.Errorf("handled = %v, want %v", handled, tt.wantHandled)
+			}
+			if tt.wantHandled && failed["errorClass"] != tt.errorClass {
+				t.Errorf("errorClass = %v, want %q", failed["errorClass"], tt.errorClass)
+			}
+			if status := workflowObjectSpec(t, server, "wf1", "status"); status != tt.wantStatus {
+				t.Errorf("workflow status = %v, want %s", status, tt.wantStatus)
+			}
+		})
+	}
+}
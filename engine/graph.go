@@ -0,0 +1,294 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Graph formats of WorkflowGraph.
+const (
+	GraphDOT     = "dot"
+	GraphMermaid = "mermaid"
+)
+
+// an edge of the workflow graph. kind is empty for next steps, or the branch the edge belongs to.
+type graphEdge struct {
+	from  string
+	to    string
+	kind  string
+	label string
+}
+
+// the shapes of the step types in DOT and the brackets of their Mermaid nodes.
+var dotShapes = map[string]string{
+	"automation": "box", "manual": "parallelogram", "approval": "parallelogram", "hub": "diamond",
+	"wait": "hexagon", "subworkflow": "box3d", "map": "box3d", "loop": "cds", "end": "ellipse",
+}
+
+var mermaidShapes = map[string][2]string{
+	"automation": {"[", "]"}, "manual": {"[/", "/]"}, "approval": {"[/", "/]"}, "hub": {"{", "}"},
+	"wait": {"{{", "}}"}, "subworkflow": {"[[", "]]"}, "map": {"[[", "]]"}, "loop": {"[\\", "/]"},
+	"end": {"([", "])"},
+}
+
+// the fill colors of step statuses in the overlay.
+var statusColors = map[string]string{
+	"Running": "#fff2a8", "Pending": "#fff2a8", "Paused": "#d9d9d9", "Complete": "#b7e4a8",
+	"Failure": "#f4a6a6", "Cancelled": "#d9d9d9",
+}
+
+// WorkflowGraph renders a workflow in the given format, GraphDOT or GraphMermaid. statuses optionally maps
+// step names to the status of their latest execution, as util.GetWorkflowObjectStepStatuses returns them for a
+// workflow object, and colors the steps accordingly.
+func WorkflowGraph(w Workflow, format string, statuses map[string]string) (string, error) {
+	switch format {
+	case GraphDOT:
+		return WorkflowDOT(w, statuses), nil
+	case GraphMermaid:
+		return WorkflowMermaid(w, statuses), nil
+	}
+	message := fmt.Sprintf("unknown graph format %s", format)
+	return "", errors.New(message)
+}
+
+// WorkflowGraph renders the current version of a registered workflow, like the package-level WorkflowGraph.
+// Workflows registered in Go, loaded from definition files or watched as WorkflowDefinition resources can all
+// be rendered this way.
+func (app *App) WorkflowGraph(name string, format string, statuses map[string]string) (string, error) {
+	wi := app.getWorkflowInstance(name)
+	if wi == nil {
+		message := fmt.Sprintf("workflow %s is not registered", name)
+		return "", errors.New(message)
+	}
+	return WorkflowGraph(wi.Workflow, format, statuses)
+}
+
+// WorkflowDOT renders a workflow as a Graphviz DOT digraph. Steps are shaped by type, edges are labeled with
+// their when conditions, and the start and end of the workflow are drawn as separate nodes.
+func WorkflowDOT(w Workflow, statuses map[string]string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n", dotQuote(w.Name))
+	b.WriteString("\trankdir=TB;\n")
+	b.WriteString("\t\"__start\" [label=\"start\", shape=circle, style=filled, fillcolor=black, fontcolor=white];\n")
+	b.WriteString("\t\"__end\" [label=\"end\", shape=doublecircle, style=filled, fillcolor=black, fontcolor=white];\n")
+	for _, name := range stepNames(w) {
+		step := w.Steps[name]
+		attrs := []string{"label=" + dotQuote(stepLabel(name, step, statuses)), "shape=" + dotShapes[graphStepType(step)]}
+		if color, exist := statusColors[statuses[name]]; exist {
+			attrs = append(attrs, "style=filled", "fillcolor="+dotQuote(color))
+		}
+		fmt.Fprintf(&b, "\t%s [%s];\n", dotQuote(name), strings.Join(attrs, ", "))
+	}
+	for _, name := range compensationNames(w) {
+		label := name + "\ncompensation"
+		if status := statuses[name]; status != "" {
+			label += "\n" + status
+		}
+		attrs := []string{"label=" + dotQuote(label), "shape=box", "style=dashed"}
+		if color, exist := statusColors[statuses[name]]; exist {
+			attrs = append(attrs[:2], "style=\"dashed,filled\"", "fillcolor="+dotQuote(color))
+		}
+		fmt.Fprintf(&b, "\t%s [%s];\n", dotQuote(name), strings.Join(attrs, ", "))
+	}
+	for _, e := range graphEdges(w) {
+		var attrs []string
+		label := e.label
+		if e.kind != "" {
+			attrs = append(attrs, "style=dashed")
+			label = strings.TrimSpace(e.kind + " " + label)
+		}
+		switch e.kind {
+		case "onFailure", "onUnsatisfied":
+			attrs = append(attrs, "color=red")
+		case "compensation":
+			attrs = append(attrs, "color=gray", "arrowhead=empty")
+		}
+		if label != "" {
+			attrs = append(attrs, "label="+dotQuote(label))
+		}
+		fmt.Fprintf(&b, "\t%s -> %s", dotQuote(e.from), dotQuote(e.to))
+		if len(attrs) > 0 {
+			fmt.Fprintf(&b, " [%s]", strings.Join(attrs, ", "))
+		}
+		b.WriteString(";\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// WorkflowMermaid renders a workflow as a Mermaid flowchart, like WorkflowDOT.
+func WorkflowMermaid(w Workflow, statuses map[string]string) string {
+	names := stepNames(w)
+	compensations := compensationNames(w)
+	// "end" is a keyword in Mermaid, so the start and end nodes get ids of their own.
+	ids := map[string]string{"__start": "wfStart", "__end": "wfEnd"}
+	for i, name := range names {
+		ids[name] = fmt.Sprintf("s%d", i)
+	}
+	for i, name := range compensations {
+		ids[name] = fmt.Sprintf("c%d", i)
+	}
+	var b strings.Builder
+	b.WriteString("flowchart TD\n")
+	b.WriteString("\twfStart((start))\n")
+	b.WriteString("\twfEnd(((end)))\n")
+	classes := make(map[string][]string)
+	for _, name := range names {
+		step := w.Steps[name]
+		shape := mermaidShapes[graphStepType(step)]
+		fmt.Fprintf(&b, "\t%s%s%s%s\n", ids[name], shape[0], mermaidQuote(stepLabel(name, step, statuses)), shape[1])
+		if _, exist := statusColors[statuses[name]]; exist {
+			classes[statuses[name]] = append(classes[statuses[name]], ids[name])
+		}
+	}
+	for _, name := range compensations {
+		label := name + "\ncompensation"
+		if status := statuses[name]; status != "" {
+			label += "\n" + status
+		}
+		fmt.Fprintf(&b, "\t%s[%s]\n", ids[name], mermaidQuote(label))
+		if _, exist := statusColors[statuses[name]]; exist {
+			classes[statuses[name]] = append(classes[statuses[name]], ids[name])
+		}
+	}
+	for _, e := range graphEdges(w) {
+		arrow := "-->"
+		label := e.label
+		if e.kind != "" {
+			arrow = "-.->"
+			label = strings.TrimSpace(e.kind + " " + label)
+		}
+		if label != "" {
+			arrow += "|" + mermaidQuote(label) + "|"
+		}
+		fmt.Fprintf(&b, "\t%s %s %s\n", ids[e.from], arrow, ids[e.to])
+	}
+	var statusNames []string
+	for status := range classes {
+		statusNames = append(statusNames, status)
+	}
+	sort.Strings(statusNames)
+	for _, status := range statusNames {
+		fmt.Fprintf(&b, "\tclassDef %s fill:%s\n", status, statusColors[status])
+		fmt.Fprintf(&b, "\tclass %s %s\n", strings.Join(classes[status], ","), status)
+	}
+	return b.String()
+}
+
+// collect the edges of a workflow: from the start node to the start steps, the next steps and branches of
+// every step, the body of loops, the compensation of steps, and from the steps without next steps to the end node.
+func graphEdges(w Workflow) []graphEdge {
+	var edges []graphEdge
+	for _, name := range w.StartAt {
+		edges = append(edges, graphEdge{from: "__start", to: name})
+	}
+	for _, name := range stepNames(w) {
+		step := w.Steps[name]
+		branches := []struct {
+			kind      string
+			nextSteps []NextStep
+		}{
+			{"", step.NextSteps}, {"onFailure", step.OnFailure}, {"onReject", step.OnReject},
+			{"onUnsatisfied", step.OnUnsatisfied},
+		}
+		for _, branch := range branches {
+			for _, next := range branch.nextSteps {
+				if _, exist := w.Steps[next.Name]; !exist {
+					continue
+				}
+				edges = append(edges, graphEdge{from: name, to: next.Name, kind: branch.kind, label: next.When})
+			}
+		}
+		if step.Type == "loop" && step.Loop.Start != "" {
+			label := ""
+			if step.Loop.Until != "" {
+				label = "until " + step.Loop.Until
+			}
+			edges = append(edges, graphEdge{from: name, to: step.Loop.Start, kind: "loop", label: label})
+		}
+		if step.Compensation != "" {
+			edges = append(edges, graphEdge{from: name, to: step.Compensation, kind: "compensation"})
+		}
+		if len(step.NextSteps) == 0 {
+			edges = append(edges, graphEdge{from: name, to: "__end"})
+		}
+	}
+	return edges
+}
+
+// the type a step is drawn as. Untyped steps are automation steps, or end steps without next steps.
+func graphStepType(step Step) string {
+	if step.isEnd() {
+		return "end"
+	}
+	if step.Type == "" {
+		return "automation"
+	}
+	return step.Type
+}
+
+// label a step with its name, its type, the outcome of an end step and its status in the overlay.
+func stepLabel(name string, step Step, statuses map[string]string) string {
+	label := fmt.Sprintf("%s\n%s", name, graphStepType(step))
+	switch {
+	case step.Type == "hub":
+		condition := step.Condition
+		if condition == "" {
+			condition = JoinAllSuccess
+		}
+		label += " " + condition
+	case step.isEnd() && step.End.Outcome != "":
+		label += " " + step.End.Outcome
+	}
+	if status, exist := statuses[name]; exist && status != "" {
+		label += "\n" + status
+	}
+	return label
+}
+
+func stepNames(w Workflow) []string {
+	var names []string
+	for name := range w.Steps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// the compensation steps that are not steps of the workflow themselves. They are only sent to the executor,
+// so they are drawn as nodes of their own.
+func compensationNames(w Workflow) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, name := range stepNames(w) {
+		compensation := w.Steps[name].Compensation
+		if _, exist := w.Steps[compensation]; compensation == "" || exist || seen[compensation] {
+			continue
+		}
+		seen[compensation] = true
+		names = append(names, compensation)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func dotQuote(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, "\"", "\\\"", -1)
+	s = strings.Replace(s, "\n", "\\n", -1)
+	return "\"" + s + "\""
+}
+
+// quote a Mermaid label, which cannot contain double quotes and breaks lines with <br/>. Characters Mermaid
+// reads as markup are written as entity codes, '#' first since it starts them.
+func mermaidQuote(s string) string {
+	s = strings.Replace(s, "#", "#35;", -1)
+	s = strings.Replace(s, "\"", "#quot;", -1)
+	s = strings.Replace(s, "&", "#amp;", -1)
+	s = strings.Replace(s, "<", "#lt;", -1)
+	s = strings.Replace(s, ">", "#gt;", -1)
+	s = strings.Replace(s, "\n", "<br/>", -1)
+	return "\"" + s + "\""
+}
@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// a workflow using every kind of node and edge, with conditions and names that need escaping.
+func graphWorkflow() Workflow {
+	return Workflow{
+		Name:    `expense "approval"`,
+		StartAt: []string{"submit"},
+		Trigger: TriggerCondition{Model: "expense", EventType: "added"},
+		Steps: map[string]Step{
+			"submit": {
+				NextSteps:    []NextStep{{Name: "review", When: `spec.amount > 100 && spec.note != "a\b"`}, {Name: "join", When: "spec.amount <= 100"}},
+				OnFailure:    []NextStep{{Name: "rejected"}},
+				Compensation: "refund #1",
+			},
+			"review": {
+				Type:          "approval",
+				StepTrigger:   TriggerCondition{Model: "expense", EventType: "modified"},
+				NextSteps:     []NextStep{{Name: "join"}},
+				OnReject:      []NextStep{{Name: "rejected"}},
+				Compensation:  "rejected",
+				OnUnsatisfied: []NextStep{{Name: "rejected"}},
+			},
+			"join":     {Type: "hub", Inputs: []string{"submit", "review"}, NextSteps: []NextStep{{Name: "retry"}}},
+			"retry":    {Type: "loop", Loop: LoopConfig{Start: "submit", Until: "spec.tries >= 3"}, NextSteps: []NextStep{{Name: "done"}}},
+			"done":     {Type: "end", End: EndConfig{Outcome: "<Approved>"}},
+			"rejected": {Type: "end", End: EndConfig{Outcome: "Rejected"}},
+		},
+	}
+}
+
+func TestWorkflowGraphGolden(t *testing.T) {
+	statuses := map[string]string{"submit": "Complete", "review": "Failure", "refund #1": "Running"}
+	tests := []struct {
+		name     string
+		format   string
+		statuses map[string]string
+		golden   string
+	}{
+		{name: "dot", format: GraphDOT, golden: "graph.dot"},
+		{name: "dot with statuses", format: GraphDOT, statuses: statuses, golden: "graph_statuses.dot"},
+		{name: "mermaid", format: GraphMermaid, golden: "graph.mmd"},
+		{name: "mermaid with statuses", format: GraphMermaid, statuses: statuses, golden: "graph_statuses.mmd"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := WorkflowGraph(graphWorkflow(), tt.format, tt.statuses)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join("testdata", tt.golden)
+			if *update {
+				err = ioutil.WriteFile(path, []byte(got), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("graph differs from %s, run go test -update to rewrite it:\n%s", path, got)
+			}
+		})
+	}
+}
+
+func TestWorkflowGraphFormat(t *testing.T) {
+	_, err := WorkflowGraph(graphWorkflow(), "svg", nil)
+	if err == nil || err.Error() != "unknown graph format svg" {
+		t.Errorf("got error %v, want unknown graph format svg", err)
+	}
+}
+
+func TestAppWorkflowGraph(t *testing.T) {
+	app := CreateApp()
+	app.RegisterWorkflow(graphWorkflow)
+	got, err := app.WorkflowGraph(`expense "approval"`, GraphMermaid, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := WorkflowMermaid(graphWorkflow(), nil); got != want {
+		t.Errorf("App.WorkflowGraph() = %s, want %s", got, want)
+	}
+	_, err = app.WorkflowGraph("workflow9", GraphDOT, nil)
+	if err == nil || err.Error() != "workflow workflow9 is not registered" {
+		t.Errorf("got error %v, want workflow workflow9 is not registered", err)
+	}
+}
@@ -0,0 +1,130 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/flintdev/workflow-engine/util"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"net/http"
+	"time"
+)
+
+const heartbeatCheckInterval = 15 * time.Second
+
+// HeartbeatRequest is sent by an executor to report progress on a running step.
+type HeartbeatRequest struct {
+	ObjName     string `json:"objName"`
+	Step        string `json:"step"`
+	ExecutionID string `json:"executionId"`
+	TaskToken   string `json:"taskToken"`
+	Progress    int64  `json:"progress"`
+	Details     string `json:"details"`
+}
+
+func (app *App) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	ctx := app.ctx
+	if r.Method != http.MethodPost {
+		writeCallbackResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var hb HeartbeatRequest
+	err := json.NewDecoder(r.Body).Decode(&hb)
+	if err != nil {
+		writeCallbackResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if hb.ObjName == "" || hb.Step == "" || hb.TaskToken == "" {
+		writeCallbackResponse(w, http.StatusBadRequest, "objName, step and taskToken are required")
+		return
+	}
+	if hb.ExecutionID != "" {
+		ctx = util.WithExecution(ctx, hb.ExecutionID, "")
+	}
+	err = util.RecordWorkflowObjectStepHeartbeat(ctx, app.kubeconfig, hb.ObjName, hb.Step, hb.TaskToken, hb.Progress, hb.Details)
+	if err != nil {
+		writeCallbackResponse(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeCallbackResponse(w, http.StatusOK, "")
+}
+
+// periodically fail running steps whose last heartbeat is older than their heartbeatTimeout.
+func (app *App) monitorHeartbeats(ctx context.Context) {
+	logger, _ := zap.NewProduction()
+	defer logger.Sync()
+	ticker := time.NewTicker(heartbeatCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		list, err := util.ListObj(ctx, app.kubeconfig, util.WFNamespace, util.WFGroup, util.WFVersion, util.WFResource, "")
+		if err != nil {
+			logger.Error(err.Error())
+			continue
+		}
+		for _, obj := range list.Items {
+			app.checkStepHeartbeats(ctx, logger, obj)
+		}
+	}
+}
+
+// fail the running steps of a workflow object whose heartbeat has expired.
+func (app *App) checkStepHeartbeats(ctx context.Context, logger *zap.Logger, obj unstructured.Unstructured) {
+	wfObjName := obj.GetName()
+	status, _, _ := unstructured.NestedString(obj.Object, "spec", "status")
+	if status != "Running" && status != "Paused" {
+		return
+	}
+	steps, _, _ := unstructured.NestedSlice(obj.Object, "spec", "steps")
+	for _, s := range steps {
+		step, ok := s.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if !heartbeatExpired(step, time.Now()) {
+			continue
+		}
+		stepName, _, _ := unstructured.NestedString(step, "name")
+		timeout, _, _ := unstructured.NestedString(step, "heartbeatTimeout")
+		taskToken, _, _ := unstructured.NestedString(step, "taskToken")
+		wi, err := app.getWorkflowInstanceOf(&obj)
+		if err != nil {
+			continue
+		}
+		ctx := executionContext(ctx, step)
+		// consume the token first so a late callback from the lost executor is rejected.
+		_, err = util.ClaimWorkflowObjectStepTaskToken(ctx, app.kubeconfig, wfObjName, stepName, taskToken)
+		if err != nil {
+			continue
+		}
+		message := fmt.Sprintf("no heartbeat received within %s, step is considered lost", timeout)
+		logError(logger, wfObjName, stepName, message)
+		wi.failStep(ctx, app.kubeconfig, logger, wfObjName, stepName, message)
+	}
+}
+
+// check whether a step entry is a running asynchronous step whose last heartbeat is older than its
+// heartbeatTimeout at now. Entries without a task token, a timeout or a parsable heartbeat time never expire.
+func heartbeatExpired(step map[string]interface{}, now time.Time) bool {
+	stepStatus, _, _ := unstructured.NestedString(step, "status")
+	timeout, _, _ := unstructured.NestedString(step, "heartbeatTimeout")
+	taskToken, _, _ := unstructured.NestedString(step, "taskToken")
+	lastHeartbeat, _, _ := unstructured.NestedString(step, "heartbeat", "at")
+	if stepStatus != "Running" || timeout == "" || taskToken == "" {
+		return false
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return false
+	}
+	at, err := time.Parse(time.RFC3339, lastHeartbeat)
+	if err != nil {
+		return false
+	}
+	return now.Sub(at) > d
+}
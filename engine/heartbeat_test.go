@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHeartbeatExpired(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	step := func(fields map[string]interface{}) map[string]interface{} {
+		s := map[string]interface{}{
+			"name":             "step1",
+			"status":           "Running",
+			"heartbeatTimeout": "1m",
+			"taskToken":        "t1",
+			"heartbeat":        map[string]interface{}{"at": "2020-01-01T11:58:00Z"},
+		}
+		for k, v := range fields {
+			s[k] = v
+		}
+		return s
+	}
+	tests := []struct {
+		name string
+		step map[string]interface{}
+		want bool
+	}{
+		{name: "expired", step: step(nil), want: true},
+		{name: "within the timeout", step: step(map[string]interface{}{"heartbeat": map[string]interface{}{"at": "2020-01-01T11:59:30Z"}})},
+		{name: "exactly at the timeout", step: step(map[string]interface{}{"heartbeat": map[string]interface{}{"at": "2020-01-01T11:59:00Z"}})},
+		{name: "step not running", step: step(map[string]interface{}{"status": "Complete"})},
+		{name: "no heartbeat timeout", step: step(map[string]interface{}{"heartbeatTimeout": ""})},
+		{name: "invalid heartbeat timeout", step: step(map[string]interface{}{"heartbeatTimeout": "soon"})},
+		{name: "missing task token", step: step(map[string]interface{}{"taskToken": ""})},
+		{name: "unparsable heartbeat time", step: step(map[string]interface{}{"heartbeat": map[string]interface{}{"at": "yesterday"}})},
+		{name: "no heartbeat", step: step(map[string]interface{}{"heartbeat": nil})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := heartbeatExpired(tt.step, now); got != tt.want {
+				t.Errorf("heartbeatExpired = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"github.com/flintdev/workflow-engine/handler"
+	"github.com/flintdev/workflow-engine/util"
+	"go.uber.org/zap"
+)
+
+// Join conditions of a hub step. A hub fires once its condition holds over the latest attempts of its
+// inputs, and becomes unsatisfied once the condition can no longer hold.
+const (
+	// every input completes successfully.
+	JoinAllSuccess = "all_success"
+	// at least one input completes successfully.
+	JoinAnySuccess = "any_success"
+	// every input has finished, successfully or not.
+	JoinAllDone = "all_done"
+	// at least joinCount inputs complete successfully.
+	JoinNOfM = "n_of_m"
+	// the first input finishes, successfully or not.
+	JoinFirstCompleted = "first_completed"
+)
+
+const hubSatisfied = "Satisfied"
+const hubUnsatisfied = "Unsatisfied"
+
+// evaluate the join of a hub step over the statuses of the inputs that have started. It returns
+// hubSatisfied, hubUnsatisfied or an empty string while the join is open, and whether failed inputs are
+// handled by the hub rather than failing the workflow.
+func (s Step) joinOutcome(statuses map[string]string) (string, bool) {
+	total := len(s.Inputs)
+	complete, failed := 0, 0
+	for _, status := range statuses {
+		switch status {
+		case "Complete":
+			complete++
+		case "Failure", "Cancelled":
+			failed++
+		}
+	}
+	done := complete + failed
+	unsatisfied := len(s.OnUnsatisfied) > 0
+	switch s.Condition {
+	case "", JoinAllSuccess:
+		if complete == total {
+			return hubSatisfied, true
+		}
+		if failed > 0 {
+			return hubUnsatisfied, unsatisfied
+		}
+	case JoinAnySuccess:
+		if complete >= 1 {
+			return hubSatisfied, true
+		}
+		if done == total {
+			return hubUnsatisfied, unsatisfied
+		}
+	case JoinAllDone:
+		if done == total {
+			return hubSatisfied, true
+		}
+	case JoinNOfM:
+		if complete >= s.JoinCount {
+			return hubSatisfied, true
+		}
+		if complete+total-done < s.JoinCount {
+			return hubUnsatisfied, unsatisfied
+		}
+	case JoinFirstCompleted:
+		if done >= 1 {
+			return hubSatisfied, true
+		}
+	default:
+		return hubUnsatisfied, false
+	}
+	return "", false
+}
+
+// evaluate the join of a hub step after one of its inputs completed.
+func (wi *WorkflowInstance) joinHub(ctx context.Context, kubeconfig *string, logger *zap.Logger, wfObjName string, hubName string, handler handler.Handler) {
+	step := wi.Workflow.Steps[hubName]
+	outcome, err := util.ClaimWorkflowObjectHub(ctx, kubeconfig, wfObjName, hubName, step.Inputs, step.joinOutcome)
+	if err != nil {
+		logError(logger, wfObjName, hubName, err.Error())
+		// the hub has no entry of this execution to fail.
+		wi.failWorkflow(ctx, kubeconfig, logger, wfObjName, hubName, err.Error())
+		return
+	}
+	wi.runHub(ctx, kubeconfig, logger, wfObjName, hubName, handler, outcome)
+}
+
+// evaluate the joins of the hub steps that have the failed step as input.
+func (wi *WorkflowInstance) joinHubsAfterFailure(ctx context.Context, kubeconfig *string, logger *zap.Logger, wfObjName string, stepName string) {
+	for hubName, step := range wi.Workflow.Steps {
+		if step.Type != "hub" || !containsString(step.Inputs, stepName) {
+			continue
+		}
+		ctx := util.WithExecution(ctx, util.GenerateExecutionID(), util.ExecutionID(ctx))
+		outcome, err := util.ClaimWorkflowObjectHub(ctx, kubeconfig, wfObjName, hubName, step.Inputs, step.joinOutcome)
+		if err != nil {
+			logError(logger, wfObjName, hubName, err.Error())
+			continue
+		}
+		if outcome == "" {
+			continue
+		}
+		h := newHandler(kubeconfig, wfObjName)
+		hubName := hubName
+		wi.spawn(func() {
+			wi.runHub(ctx, kubeconfig, logger, wfObjName, hubName, h, outcome)
+		})
+	}
+}
+
+// continue a hub step the join has fired for. A satisfied hub runs like any other step; an unsatisfied one
+// takes its onUnsatisfied branch, or fails without one.
+func (wi *WorkflowInstance) runHub(ctx context.Context, kubeconfig *string, logger *zap.Logger, wfObjName string, hubName string, handler handler.Handler, outcome string) {
+	if outcome == "" {
+		return
+	}
+	wi.recordLoopIteration(ctx, kubeconfig, logger, wfObjName, hubName)
+	step := wi.Workflow.Steps[hubName]
+	condition := step.Condition
+	if condition == "" {
+		condition = JoinAllSuccess
+	}
+	switch outcome {
+	case hubSatisfied:
+		logInfo(logger, wfObjName, hubName, fmt.Sprintf("join condition %s is satisfied", condition))
+		wi.invokeExecutor(ctx, kubeconfig, logger, wfObjName, hubName, handler)
+	case hubUnsatisfied:
+		message := fmt.Sprintf("join condition %s cannot be satisfied", condition)
+		if len(step.OnUnsatisfied) == 0 {
+			logError(logger, wfObjName, hubName, message)
+			wi.failStep(ctx, kubeconfig, logger, wfObjName, hubName, message)
+			return
+		}
+		logInfo(logger, wfObjName, hubName, message+", taking the onUnsatisfied branch")
+		nextSteps, err := matchNextSteps(ctx, step.OnUnsatisfied, handler, nil)
+		if err != nil {
+			logError(logger, wfObjName, hubName, err.Error())
+			wi.failStep(ctx, kubeconfig, logger, wfObjName, hubName, err.Error())
+			return
+		}
+		wi.completeStep(ctx, kubeconfig, logger, wfObjName, hubName, handler, nextSteps, message)
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
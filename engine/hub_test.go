@@ -0,0 +1,43 @@
+package engine
+
+import "testing"
+
+func TestStepJoinOutcome(t *testing.T) {
+	inputs := []string{"a", "b", "c"}
+	onUnsatisfied := []NextStep{{Name: "fallback"}}
+	tests := []struct {
+		name          string
+		condition     string
+		joinCount     int
+		onUnsatisfied []NextStep
+		statuses      map[string]string
+		wantOutcome   string
+		wantHandled   bool
+	}{
+		{name: "default waits", statuses: map[string]string{"a": "Complete"}},
+		{name: "default all complete", statuses: map[string]string{"a": "Complete", "b": "Complete", "c": "Complete"}, wantOutcome: hubSatisfied, wantHandled: true},
+		{name: "all_success running", condition: JoinAllSuccess, statuses: map[string]string{"a": "Complete", "b": "Running"}},
+		{name: "all_success failure", condition: JoinAllSuccess, statuses: map[string]string{"a": "Failure"}, wantOutcome: hubUnsatisfied},
+		{name: "all_success failure handled", condition: JoinAllSuccess, onUnsatisfied: onUnsatisfied, statuses: map[string]string{"a": "Cancelled"}, wantOutcome: hubUnsatisfied, wantHandled: true},
+		{name: "any_success", condition: JoinAnySuccess, statuses: map[string]string{"a": "Failure", "b": "Complete"}, wantOutcome: hubSatisfied, wantHandled: true},
+		{name: "any_success open", condition: JoinAnySuccess, statuses: map[string]string{"a": "Failure", "b": "Failure"}},
+		{name: "any_success all failed", condition: JoinAnySuccess, statuses: map[string]string{"a": "Failure", "b": "Failure", "c": "Failure"}, wantOutcome: hubUnsatisfied},
+		{name: "all_done open", condition: JoinAllDone, statuses: map[string]string{"a": "Failure", "b": "Complete"}},
+		{name: "all_done", condition: JoinAllDone, statuses: map[string]string{"a": "Failure", "b": "Complete", "c": "Cancelled"}, wantOutcome: hubSatisfied, wantHandled: true},
+		{name: "n_of_m reached", condition: JoinNOfM, joinCount: 2, statuses: map[string]string{"a": "Complete", "c": "Complete"}, wantOutcome: hubSatisfied, wantHandled: true},
+		{name: "n_of_m still reachable", condition: JoinNOfM, joinCount: 2, statuses: map[string]string{"a": "Failure", "b": "Complete"}},
+		{name: "n_of_m unreachable", condition: JoinNOfM, joinCount: 2, onUnsatisfied: onUnsatisfied, statuses: map[string]string{"a": "Failure", "b": "Failure"}, wantOutcome: hubUnsatisfied, wantHandled: true},
+		{name: "first_completed", condition: JoinFirstCompleted, statuses: map[string]string{"b": "Failure"}, wantOutcome: hubSatisfied, wantHandled: true},
+		{name: "first_completed open", condition: JoinFirstCompleted, statuses: map[string]string{"b": "Running"}},
+		{name: "unknown condition", condition: "most", statuses: map[string]string{}, wantOutcome: hubUnsatisfied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Step{Type: "hub", Inputs: inputs, Condition: tt.condition, JoinCount: tt.joinCount, OnUnsatisfied: tt.onUnsatisfied}
+			outcome, handled := s.joinOutcome(tt.statuses)
+			if outcome != tt.wantOutcome || handled != tt.wantHandled {
+				t.Errorf("joinOutcome(%v) = %q, %v, want %q, %v", tt.statuses, outcome, handled, tt.wantOutcome, tt.wantHandled)
+			}
+		})
+	}
+}
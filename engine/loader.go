@@ -0,0 +1,265 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultReloadInterval = 10 * time.Second
+
+// LoadConfig reads the config from a YAML or JSON file and applies it like RegisterConfig. Once the engine runs,
+// the file is polled every ReloadInterval; a changed gvr map or executor takes effect at once, while the other
+// fields need a restart.
+func (app *App) LoadConfig(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	var c Config
+	err = readDefinitionFile(path, &c)
+	if err != nil {
+		return err
+	}
+	app.RegisterConfig(func() Config { return c })
+	app.configFile = path
+	app.configFileTime = info.ModTime()
+	app.config = c
+	return nil
+}
+
+// LoadWorkflows registers the workflow definition in a YAML or JSON file, or those in the .yaml, .yml and
+// .json files of a directory. A workflow is named after its file unless the definition names it. Every file is
+// loaded even if others fail; the error lists the files that failed. Once the engine runs, the files are polled
+// every ReloadInterval and changed, added and removed files are reloaded like WorkflowDefinition resources.
+func (app *App) LoadWorkflows(path string) error {
+	logger, _ := zap.NewProduction()
+	defer logger.Sync()
+	files, err := app.workflowFiles(path)
+	if err != nil {
+		return err
+	}
+	app.workflowPaths = append(app.workflowPaths, path)
+	var problems []string
+	for _, file := range files {
+		err := app.loadWorkflowFile(logger, file)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// get the definition files at path, leaving out the config file.
+func (app *App) workflowFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		file := filepath.Join(path, entry.Name())
+		if entry.IsDir() || !isDefinitionFile(file) || file == app.configFile {
+			continue
+		}
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// load the workflow definition in a file and remember the file's modification time for reloading.
+func (app *App) loadWorkflowFile(logger *zap.Logger, file string) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	if app.workflowFileTimes == nil {
+		app.workflowFileTimes = make(map[string]time.Time)
+	}
+	app.workflowFileTimes[file] = info.ModTime()
+	w, err := ReadWorkflowFile(file)
+	if err != nil {
+		return err
+	}
+	_, err = app.loadWorkflow(logger, w, "file:"+file)
+	if err != nil {
+		message := fmt.Sprintf("%s: %s", file, err)
+		return errors.New(message)
+	}
+	return nil
+}
+
+// poll the config file and the workflow files every ReloadInterval until ctx is cancelled.
+func (app *App) watchFiles(ctx context.Context) {
+	logger, _ := zap.NewProduction()
+	defer logger.Sync()
+	interval := app.ReloadInterval
+	if interval <= 0 {
+		interval = defaultReloadInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		app.reloadConfigFile(logger)
+		app.reloadWorkflowFiles(logger)
+	}
+}
+
+// reload the config file if it changed. The model watches follow a changed gvr map and new steps use a changed
+// executor. Changes to the other fields are logged and need a restart. An invalid file leaves the config in
+// place.
+func (app *App) reloadConfigFile(logger *zap.Logger) {
+	if app.configFile == "" {
+		return
+	}
+	info, err := os.Stat(app.configFile)
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+	if info.ModTime().Equal(app.configFileTime) {
+		return
+	}
+	app.configFileTime = info.ModTime()
+	var c Config
+	err = readDefinitionFile(app.configFile, &c)
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+	app.setModelsAndExecutor(c)
+	if app.models != nil {
+		app.models.update(logger, app.modelGVRs())
+	}
+	logger.Info(fmt.Sprintf("Reloaded config %s", app.configFile))
+	if fields := restartFields(app.config, c); len(fields) > 0 {
+		logger.Warn(fmt.Sprintf("Changes to %s in %s take effect after a restart", strings.Join(fields, ", "), app.configFile))
+	}
+}
+
+// list the fields that differ between two configs and are only applied when the engine starts.
+func restartFields(loaded Config, c Config) []string {
+	var fields []string
+	if loaded.Concurrency != c.Concurrency {
+		fields = append(fields, "concurrency")
+	}
+	if loaded.ShutdownGracePeriod != c.ShutdownGracePeriod {
+		fields = append(fields, "shutdownGracePeriod")
+	}
+	if loaded.WatchDefinitions != c.WatchDefinitions {
+		fields = append(fields, "watchDefinitions")
+	}
+	if loaded.ReloadInterval != c.ReloadInterval {
+		fields = append(fields, "reloadInterval")
+	}
+	return fields
+}
+
+// reload the workflow files that changed or were added, and unregister the workflows of removed files.
+func (app *App) reloadWorkflowFiles(logger *zap.Logger) {
+	present := make(map[string]bool)
+	for _, path := range app.workflowPaths {
+		files, err := app.workflowFiles(path)
+		if err != nil {
+			logger.Error(err.Error())
+			continue
+		}
+		for _, file := range files {
+			present[file] = true
+			info, err := os.Stat(file)
+			if err != nil {
+				continue
+			}
+			if loadedAt, exist := app.workflowFileTimes[file]; exist && loadedAt.Equal(info.ModTime()) {
+				continue
+			}
+			err = app.loadWorkflowFile(logger, file)
+			if err != nil {
+				logger.Error(err.Error())
+			}
+		}
+	}
+	for file := range app.workflowFileTimes {
+		if !present[file] {
+			delete(app.workflowFileTimes, file)
+			app.unloadWorkflows(logger, "file:"+file)
+		}
+	}
+}
+
+func isDefinitionFile(file string) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// ReadWorkflowFile reads a workflow definition from a YAML or JSON file after checking it against
+// WorkflowSchema. The workflow is named after the file unless the definition names it.
+func ReadWorkflowFile(file string) (Workflow, error) {
+	var w Workflow
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return w, err
+	}
+	j, err := yaml.YAMLToJSON(b)
+	if err == nil {
+		var doc interface{}
+		err = json.Unmarshal(j, &doc)
+		if err == nil {
+			err = ValidateWorkflowDocument(doc)
+		}
+	}
+	if err == nil {
+		err = yaml.UnmarshalStrict(b, &w)
+	}
+	if err != nil {
+		message := fmt.Sprintf("%s: %s", file, err)
+		return w, errors.New(message)
+	}
+	if w.Name == "" {
+		w.Name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	}
+	return w, nil
+}
+
+// read a YAML or JSON file into v, which is decoded through its JSON field names. Unknown fields are rejected,
+// so a misspelled field is reported instead of ignored.
+func readDefinitionFile(file string, v interface{}) error {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	err = yaml.UnmarshalStrict(b, v)
+	if err != nil {
+		message := fmt.Sprintf("%s: %s", file, err)
+		return errors.New(message)
+	}
+	return nil
+}
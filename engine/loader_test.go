@@ -0,0 +1,278 @@
+package engine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestReloadConfigFile(t *testing.T) {
+	initial := `gvr:
+  model1: {group: flint.flint.com, version: v1, resource: model1s}
+executor:
+  url: http://executor:8080/execute
+concurrency:
+  maxWorkers: 4
+`
+	tests := []struct {
+		name        string
+		config      string
+		touch       bool
+		wantModels  []string
+		wantURL     string
+		wantWorkers int
+	}{
+		{
+			name:        "unchanged file",
+			config:      initial,
+			wantModels:  []string{"model1s"},
+			wantURL:     "http://executor:8080/execute",
+			wantWorkers: 4,
+		},
+		{
+			name: "model and executor changed",
+			config: `gvr:
+  model1: {group: flint.flint.com, version: v1, resource: model1s}
+  model2: {group: flint.flint.com, version: v1, resource: model2s}
+executor:
+  url: http://executor2:8080/execute
+concurrency:
+  maxWorkers: 4
+`,
+			touch:       true,
+			wantModels:  []string{"model1s", "model2s"},
+			wantURL:     "http://executor2:8080/execute",
+			wantWorkers: 4,
+		},
+		{
+			name: "restart field changed",
+			config: `gvr: {}
+concurrency:
+  maxWorkers: 8
+`,
+			touch:       true,
+			wantURL:     "http://executor:8080/execute",
+			wantWorkers: 4,
+		},
+		{
+			name:        "invalid file",
+			config:      "gvr: [\n",
+			touch:       true,
+			wantModels:  []string{"model1s"},
+			wantURL:     "http://executor:8080/execute",
+			wantWorkers: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "config")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			file := filepath.Join(dir, "config.yaml")
+			if err := ioutil.WriteFile(file, []byte(initial), 0644); err != nil {
+				t.Fatal(err)
+			}
+			app := CreateApp()
+			if err := app.LoadConfig(file); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(file, []byte(tt.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			modified := app.configFileTime
+			if tt.touch {
+				modified = modified.Add(time.Second)
+			}
+			if err := os.Chtimes(file, modified, modified); err != nil {
+				t.Fatal(err)
+			}
+			app.reloadConfigFile(zap.NewNop())
+			var models []string
+			for _, gvr := range app.modelGVRs() {
+				models = append(models, gvr.Resource)
+			}
+			if !reflect.DeepEqual(models, tt.wantModels) {
+				t.Errorf("models %v, want %v", models, tt.wantModels)
+			}
+			if url := app.executor().URL; url != tt.wantURL {
+				t.Errorf("executor url %s, want %s", url, tt.wantURL)
+			}
+			if app.Concurrency.MaxWorkers != tt.wantWorkers {
+				t.Errorf("maxWorkers %d, want %d", app.Concurrency.MaxWorkers, tt.wantWorkers)
+			}
+		})
+	}
+}
+
+func TestRestartFields(t *testing.T) {
+	loaded := Config{Concurrency: ConcurrencyConfig{MaxWorkers: 4}, ReloadInterval: "10s"}
+	tests := []struct {
+		name   string
+		config Config
+		want   []string
+	}{
+		{name: "unchanged", config: loaded},
+		{
+			name:   "reloadable fields only",
+			config: Config{Concurrency: loaded.Concurrency, ReloadInterval: "10s", GVRMap: map[string]GVR{"model1": {}}, Executor: ExecutorConfig{URL: "x"}},
+		},
+		{
+			name:   "restart fields",
+			config: Config{Concurrency: ConcurrencyConfig{MaxWorkers: 8}, ShutdownGracePeriod: "1m", WatchDefinitions: true},
+			want:   []string{"concurrency", "shutdownGracePeriod", "watchDefinitions", "reloadInterval"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := restartFields(loaded, tt.config); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("restartFields() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+const testWorkflowFile = `startAt: [step1]
+trigger: {model: model1, eventType: added}
+steps:
+  step1:
+    nextSteps: [{name: step2}]
+  step2: {}
+`
+
+// write files into a new directory, which the returned function removes.
+func writeWorkflowFiles(t *testing.T, files map[string]string) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "workflows")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestReadWorkflowFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		content  string
+		wantName string
+		wantErr  string
+	}{
+		{
+			name:     "named after the file",
+			file:     "orders.yaml",
+			content:  testWorkflowFile,
+			wantName: "orders",
+		},
+		{
+			name:     "named by the definition",
+			file:     "orders.yml",
+			content:  "name: refunds\n" + testWorkflowFile,
+			wantName: "refunds",
+		},
+		{
+			name:     "JSON file",
+			file:     "orders.json",
+			content:  `{"startAt": ["step1"], "trigger": {"model": "model1", "eventType": "added"}, "steps": {"step1": {}}}`,
+			wantName: "orders",
+		},
+		{
+			name:    "misspelled field",
+			file:    "orders.yaml",
+			content: strings.Replace(testWorkflowFile, "nextSteps", "nextStep", 1),
+			wantErr: "nextStep",
+		},
+		{
+			name:    "invalid YAML",
+			file:    "orders.yaml",
+			content: "steps: [\n",
+			wantErr: "orders.yaml",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, cleanup := writeWorkflowFiles(t, map[string]string{tt.file: tt.content})
+			defer cleanup()
+			w, err := ReadWorkflowFile(filepath.Join(dir, tt.file))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ReadWorkflowFile error = %v, want one mentioning %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w.Name != tt.wantName {
+				t.Errorf("name = %s, want %s", w.Name, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestLoadWorkflowsAggregatesErrors(t *testing.T) {
+	dir, cleanup := writeWorkflowFiles(t, map[string]string{
+		"good.yaml":   testWorkflowFile,
+		"typo.yaml":   strings.Replace(testWorkflowFile, "startAt", "startsAt", 1),
+		"broken.json": "{",
+		"notes.txt":   "not a definition",
+	})
+	defer cleanup()
+	app := CreateApp()
+	err := app.LoadWorkflows(dir)
+	if err == nil {
+		t.Fatal("LoadWorkflows succeeded with invalid files")
+	}
+	for _, file := range []string{"typo.yaml", "broken.json"} {
+		if !strings.Contains(err.Error(), file) {
+			t.Errorf("error %q does not mention %s", err, file)
+		}
+	}
+	for _, file := range []string{"good.yaml", "notes.txt"} {
+		if strings.Contains(err.Error(), file) {
+			t.Errorf("error %q mentions %s", err, file)
+		}
+	}
+	if app.getWorkflowInstance("good") == nil {
+		t.Error("the valid file was not loaded")
+	}
+}
+
+func TestReloadWorkflowFilesRetiresRemovedFiles(t *testing.T) {
+	dir, cleanup := writeWorkflowFiles(t, map[string]string{"orders.yaml": testWorkflowFile, "refunds.yaml": testWorkflowFile})
+	defer cleanup()
+	app, _, stop := newTestApp(t)
+	defer stop()
+	if err := app.LoadWorkflows(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "orders.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	app.reloadWorkflowFiles(zap.NewNop())
+	if app.getWorkflowInstance("orders") != nil {
+		t.Error("workflow of the removed file is still current")
+	}
+	if app.getWorkflowInstance("refunds") == nil {
+		t.Error("workflow of the remaining file was retired")
+	}
+	// no workflow object runs on the removed workflow, so it is unregistered altogether.
+	for _, wi := range app.workflowInstances() {
+		if wi.Workflow.Name == "orders" {
+			t.Errorf("retired workflow orders is still registered")
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"github.com/flintdev/workflow-engine/handler"
+	"github.com/flintdev/workflow-engine/util"
+	"go.uber.org/zap"
+)
+
+const defaultMaxIterations = 100
+
+// LoopConfig makes a loop step repeat its body, the steps from Start that lead back to the loop step. After
+// every iteration the Until condition is evaluated, with loop.iteration available besides flowData; once it
+// holds, the loop step continues with its nextSteps. When MaxIterations (default 100) iterations have run
+// without Until holding, the loop step fails.
+type LoopConfig struct {
+	Start         string `json:"start"`
+	Until         string `json:"until"`
+	MaxIterations int64  `json:"maxIterations"`
+}
+
+// run a loop step: start the next iteration of its body, or leave the loop.
+func (wi *WorkflowInstance) runLoop(ctx context.Context, kubeconfig *string, logger *zap.Logger, wfObjName string, loopName string, handler handler.Handler) {
+	step := wi.Workflow.Steps[loopName]
+	maxIterations := step.Loop.MaxIterations
+	if maxIterations <= 0 {
+		maxIterations = defaultMaxIterations
+	}
+	iteration, err := util.GetWorkflowObjectLoopIteration(ctx, kubeconfig, wfObjName, loopName)
+	if err != nil {
+		logError(logger, wfObjName, loopName, err.Error())
+		wi.failStep(ctx, kubeconfig, logger, wfObjName, loopName, err.Error())
+		return
+	}
+	if iteration > 0 {
+		done := false
+		if step.Loop.Until != "" {
+			done, err = parseStepCondition(ctx, step.Loop.Until, handler, map[string]interface{}{"loop.iteration": iteration})
+			if err != nil {
+				logError(logger, wfObjName, loopName, err.Error())
+				wi.failStep(ctx, kubeconfig, logger, wfObjName, loopName, err.Error())
+				return
+			}
+		}
+		if done || iteration >= maxIterations {
+			err = util.AdvanceWorkflowObjectLoop(ctx, kubeconfig, wfObjName, loopName, iteration, 0)
+			if err != nil {
+				logError(logger, wfObjName, loopName, err.Error())
+				wi.failStep(ctx, kubeconfig, logger, wfObjName, loopName, err.Error())
+				return
+			}
+		}
+		if done {
+			message := fmt.Sprintf("loop finished after %d iterations", iteration)
+			logInfo(logger, wfObjName, loopName, message)
+			nextSteps, err := matchNextSteps(ctx, step.NextSteps, handler, nil)
+			if err != nil {
+				logError(logger, wfObjName, loopName, err.Error())
+				wi.failStep(ctx, kubeconfig, logger, wfObjName, loopName, err.Error())
+				return
+			}
+			wi.completeStep(ctx, kubeconfig, logger, wfObjName, loopName, handler, nextSteps, message)
+			return
+		}
+		if iteration >= maxIterations {
+			message := fmt.Sprintf("loop reached its limit of %d iterations", maxIterations)
+			logError(logger, wfObjName, loopName, message)
+			wi.failStep(ctx, kubeconfig, logger, wfObjName, loopName, message)
+			return
+		}
+	}
+	err = util.AdvanceWorkflowObjectLoop(ctx, kubeconfig, wfObjName, loopName, iteration, iteration+1)
+	if err != nil {
+		// another branch of the body came back first and has started the iteration.
+		logInfo(logger, wfObjName, loopName, err.Error())
+		err := util.SetWorkflowObjectStepToComplete(ctx, kubeconfig, wfObjName, loopName, "iteration already started")
+		if err != nil {
+			logError(logger, wfObjName, loopName, err.Error())
+		}
+		return
+	}
+	err = util.SetWorkflowObjectStepFields(ctx, kubeconfig, wfObjName, loopName, map[string]interface{}{"iteration": iteration + 1})
+	if err != nil {
+		logError(logger, wfObjName, loopName, err.Error())
+	}
+	message := fmt.Sprintf("starting iteration %d", iteration+1)
+	logInfo(logger, wfObjName, loopName, message)
+	wi.completeStep(ctx, kubeconfig, logger, wfObjName, loopName, handler, []NextStep{{Name: step.Loop.Start}}, message)
+}
+
+// record on the entry of a step in the body of a loop which iteration it belongs to.
+func (wi *WorkflowInstance) recordLoopIteration(ctx context.Context, kubeconfig *string, logger *zap.Logger, wfObjName string, stepName string) {
+	loopName, exist := wi.loops[stepName]
+	if !exist {
+		return
+	}
+	err := util.SetWorkflowObjectStepLoopIteration(ctx, kubeconfig, wfObjName, stepName, loopName)
+	if err != nil {
+		logError(logger, wfObjName, stepName, err.Error())
+	}
+}
+
+// map every step in the body of a loop step to that loop. The body is what is reachable from the start of
+// the loop without passing through the loop step; a nested loop step belongs to the outer body, its own body
+// to the nested loop.
+func loopBodies(w Workflow) map[string]string {
+	bodies := make(map[string]string)
+	// visit the loops in a fixed order, so a step reachable from several loops always maps to the same one.
+	for _, loopName := range stepNames(w) {
+		step := w.Steps[loopName]
+		if step.Type != "loop" {
+			continue
+		}
+		queue := []string{step.Loop.Start}
+		for len(queue) > 0 {
+			name := queue[0]
+			queue = queue[1:]
+			if _, seen := bodies[name]; seen || name == loopName || name == "" {
+				continue
+			}
+			bodies[name] = loopName
+			s := w.Steps[name]
+			for _, nextSteps := range [][]NextStep{s.NextSteps, s.OnFailure, s.OnReject, s.OnUnsatisfied} {
+				for _, next := range nextSteps {
+					queue = append(queue, next.Name)
+				}
+			}
+		}
+	}
+	return bodies
+}
@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLoopBodies(t *testing.T) {
+	next := func(names ...string) []NextStep {
+		var steps []NextStep
+		for _, name := range names {
+			steps = append(steps, NextStep{Name: name})
+		}
+		return steps
+	}
+	tests := []struct {
+		name  string
+		steps map[string]Step
+		want  map[string]string
+	}{
+		{
+			name: "no loops",
+			steps: map[string]Step{
+				"a": {NextSteps: next("b")},
+				"b": {},
+			},
+			want: map[string]string{},
+		},
+		{
+			name: "simple loop",
+			steps: map[string]Step{
+				"init":  {NextSteps: next("loop")},
+				"loop":  {Type: "loop", Loop: LoopConfig{Start: "body1"}, NextSteps: next("after")},
+				"body1": {NextSteps: next("body2")},
+				"body2": {NextSteps: next("loop")},
+				"after": {},
+			},
+			want: map[string]string{"body1": "loop", "body2": "loop"},
+		},
+		{
+			name: "branches in the body",
+			steps: map[string]Step{
+				"loop":    {Type: "loop", Loop: LoopConfig{Start: "check"}},
+				"check":   {Type: "approval", NextSteps: next("loop"), OnReject: next("fix")},
+				"fix":     {OnFailure: next("recover"), NextSteps: next("loop")},
+				"recover": {NextSteps: next("loop")},
+			},
+			want: map[string]string{"check": "loop", "fix": "loop", "recover": "loop"},
+		},
+		{
+			name: "nested loop",
+			steps: map[string]Step{
+				"outer": {Type: "loop", Loop: LoopConfig{Start: "a"}, NextSteps: next("done")},
+				"a":     {NextSteps: next("inner")},
+				"inner": {Type: "loop", Loop: LoopConfig{Start: "b"}, NextSteps: next("c")},
+				"b":     {NextSteps: next("inner")},
+				"c":     {NextSteps: next("outer")},
+				"done":  {},
+			},
+			want: map[string]string{"a": "outer", "inner": "outer", "c": "outer", "b": "inner"},
+		},
+		{
+			name: "loop without start",
+			steps: map[string]Step{
+				"loop": {Type: "loop"},
+			},
+			want: map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := loopBodies(Workflow{Steps: tt.steps})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loopBodies = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
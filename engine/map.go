@@ -0,0 +1,314 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/flintdev/workflow-engine/handler"
+	"github.com/flintdev/workflow-engine/util"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"net/http"
+	"strconv"
+)
+
+const defaultMapItemKey = "item"
+const defaultMapResultKey = "result"
+
+// MapConfig describes a map step, which runs once per element of the JSON array stored under the Items
+// flowData key. With Workflow set, every item runs as a child instance of that workflow, which receives the
+// item under ItemKey along with InputMapping and returns its result under ResultKey. Otherwise the step
+// itself is sent to the executor once per item, with the item in the request; the executor may answer each
+// item asynchronously through the callback endpoint. At most MaxParallelism items
+// run at the same time, and the results are collected, in item order, into a JSON array under Output.
+type MapConfig struct {
+	Items          string            `json:"items"`
+	MaxParallelism int               `json:"maxParallelism"`
+	Workflow       string            `json:"workflow"`
+	InputMapping   map[string]string `json:"inputMapping"`
+	ItemKey        string            `json:"itemKey"`
+	ResultKey      string            `json:"resultKey"`
+	Output         string            `json:"output"`
+}
+
+// start a map step: record its items on the workflow object and start as many as it may run at once.
+func (wi *WorkflowInstance) startMap(ctx context.Context, kubeconfig *string, logger *zap.Logger, wfObjName string, stepName string, handler handler.Handler) {
+	config := wi.Workflow.Steps[stepName].Map
+	flowData, err := util.GetWorkflowObjectFlowData(ctx, kubeconfig, wfObjName)
+	if err != nil {
+		logError(logger, wfObjName, stepName, err.Error())
+		wi.failStep(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
+		return
+	}
+	items, err := parseMapItems(flowData, config.Items)
+	if err != nil {
+		logError(logger, wfObjName, stepName, err.Error())
+		wi.failStep(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
+		return
+	}
+	if len(items) == 0 {
+		logInfo(logger, wfObjName, stepName, "no items to map")
+		wi.completeMap(ctx, kubeconfig, logger, wfObjName, stepName, handler, nil)
+		return
+	}
+	maxParallelism := config.MaxParallelism
+	if maxParallelism <= 0 {
+		maxParallelism = len(items)
+	}
+	started, err := util.StartWorkflowObjectMapItems(ctx, kubeconfig, wfObjName, stepName, items, maxParallelism)
+	if err != nil {
+		logError(logger, wfObjName, stepName, err.Error())
+		wi.failStep(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
+		return
+	}
+	logInfo(logger, wfObjName, stepName, fmt.Sprintf("mapping %d items, %d at a time", len(items), maxParallelism))
+	for _, index := range started {
+		index := index
+		wi.spawn(func() {
+			wi.startMapItem(ctx, kubeconfig, logger, wfObjName, stepName, handler, index, items[index])
+		})
+	}
+}
+
+func (wi *WorkflowInstance) startMapItem(ctx context.Context, kubeconfig *string, logger *zap.Logger, wfObjName string, stepName string, handler handler.Handler, index int, item string) {
+	if ctx.Err() != nil {
+		return
+	}
+	config := wi.Workflow.Steps[stepName].Map
+	if config.Workflow == "" {
+		wi.invokeMapItem(ctx, kubeconfig, logger, wfObjName, stepName, handler, index, item)
+		return
+	}
+	var child *WorkflowInstance
+	if wi.app != nil {
+		child = wi.app.getWorkflowInstance(config.Workflow)
+	}
+	if child == nil {
+		message := fmt.Sprintf("sub-workflow %s is not registered", config.Workflow)
+		wi.finishMapItem(ctx, kubeconfig, logger, wfObjName, stepName, handler, index, "Failure", "", message)
+		return
+	}
+	flowData, err := util.GetWorkflowObjectFlowData(ctx, kubeconfig, wfObjName)
+	if err != nil {
+		wi.finishMapItem(ctx, kubeconfig, logger, wfObjName, stepName, handler, index, "Failure", "", err.Error())
+		return
+	}
+	childFlowData, err := mapFlowData(flowData, config.InputMapping)
+	if err != nil {
+		wi.finishMapItem(ctx, kubeconfig, logger, wfObjName, stepName, handler, index, "Failure", "", err.Error())
+		return
+	}
+	childFlowData[config.itemKey()] = item
+	childName := util.GenerateWorkflowObjName()
+	err = util.SetWorkflowObjectMapItemFields(ctx, kubeconfig, wfObjName, stepName, index, map[string]interface{}{"childObjName": childName})
+	if err != nil {
+		wi.finishMapItem(ctx, kubeconfig, logger, wfObjName, stepName, handler, index, "Failure", "", err.Error())
+		return
+	}
+	err = util.CreateChildWorkflowObject(ctx, kubeconfig, childName, wfObjName, stepName, strconv.Itoa(index), child.Workflow.Name, child.Workflow.Version, child.hash, childFlowData)
+	if err != nil {
+		wi.finishMapItem(ctx, kubeconfig, logger, wfObjName, stepName, handler, index, "Failure", "", err.Error())
+		return
+	}
+	logInfo(logger, wfObjName, stepName, fmt.Sprintf("started item %d as %s", index, childName))
+	var emptyNextMatchedSteps []NextStep
+	child.ExecuteWorkflow(wi.app.ctx, kubeconfig, logger, wi.app.newHandler(childName), childName, child.Workflow.StartAt, false, emptyNextMatchedSteps)
+}
+
+// send one item of a map step to the executor. A synchronous executor replies with the result of the item;
+// an asynchronous one replies 202 Accepted and reports the result later through the callback endpoint, with
+// the itemIndex and the task token of the item.
+func (wi *WorkflowInstance) invokeMapItem(ctx context.Context, kubeconfig *string, logger *zap.Logger, wfObjName string, stepName string, handler handler.Handler, index int, item string) {
+	entry, err := util.GetWorkflowObjectStep(ctx, kubeconfig, wfObjName, stepName)
+	if err != nil {
+		wi.finishMapItem(ctx, kubeconfig, logger, wfObjName, stepName, handler, index, "Failure", "", err.Error())
+		return
+	}
+	attempt, idempotencyKey := executionKey(wfObjName, fmt.Sprintf("%s[%d]", stepName, index), entry)
+	taskToken := util.GenerateTaskToken()
+	err = util.SetWorkflowObjectMapItemFields(ctx, kubeconfig, wfObjName, stepName, index, map[string]interface{}{"taskToken": taskToken})
+	if err != nil {
+		wi.finishMapItem(ctx, kubeconfig, logger, wfObjName, stepName, handler, index, "Failure", "", err.Error())
+		return
+	}
+	code, data, err := wi.postExecutor(ctx, ExecutorRequest{
+		Step:           stepName,
+		ObjName:        wfObjName,
+		Attempt:        attempt,
+		IdempotencyKey: idempotencyKey,
+		TaskToken:      taskToken,
+		CallbackURL:    wi.executorConfig().CallbackURL,
+		Item:           item,
+		ItemIndex:      &index,
+	})
+	if err != nil {
+		wi.finishMapItem(ctx, kubeconfig, logger, wfObjName, stepName, handler, index, "Failure", "", err.Error())
+		return
+	}
+	r, err := ParseExecutorResponse(data)
+	if code == http.StatusAccepted {
+		// the executor took the item over; keep it Running until the callback arrives.
+		if err == nil && r.TaskToken != "" && r.TaskToken != taskToken {
+			err = util.SetWorkflowObjectMapItemFields(ctx, kubeconfig, wfObjName, stepName, index, map[string]interface{}{"taskToken": r.TaskToken})
+			if err != nil {
+				wi.finishMapItem(ctx, kubeconfig, logger, wfObjName, stepName, handler, index, "Failure", "", err.Error())
+				return
+			}
+		}
+		logInfo(logger, wfObjName, stepName, fmt.Sprintf("item %d accepted by executor, waiting for callback", index))
+		return
+	}
+	if err != nil {
+		wi.finishMapItem(ctx, kubeconfig, logger, wfObjName, stepName, handler, index, "Failure", "", err.Error())
+		return
+	}
+	if r.Status != "success" {
+		wi.finishMapItem(ctx, kubeconfig, logger, wfObjName, stepName, handler, index, "Failure", "", r.Message)
+		return
+	}
+	wi.finishMapItem(ctx, kubeconfig, logger, wfObjName, stepName, handler, index, "Complete", r.Result, r.Message)
+}
+
+// record the result of an item, then start the next item or, once all items are complete, complete the
+// step. A failed item fails the step and cancels the child instances of the items still running.
+func (wi *WorkflowInstance) finishMapItem(ctx context.Context, kubeconfig *string, logger *zap.Logger, wfObjName string, stepName string, handler handler.Handler, index int, status string, result string, message string) {
+	next, items, err := util.FinishWorkflowObjectMapItem(ctx, kubeconfig, wfObjName, stepName, index, status, result, message)
+	if err != nil {
+		// the item was already finished, or the step is no longer running.
+		return
+	}
+	if status != "Complete" {
+		message := fmt.Sprintf("item %d failed: %s", index, message)
+		logError(logger, wfObjName, stepName, message)
+		wi.failStep(ctx, kubeconfig, logger, wfObjName, stepName, message)
+		if wi.app != nil {
+			wi.app.cancelMapItems(ctx, logger, wfObjName, stepName, items, message)
+		}
+		return
+	}
+	logInfo(logger, wfObjName, stepName, fmt.Sprintf("item %d complete", index))
+	if next >= 0 {
+		item, _ := items[next].(map[string]interface{})["item"].(string)
+		wi.spawn(func() {
+			wi.startMapItem(ctx, kubeconfig, logger, wfObjName, stepName, handler, next, item)
+		})
+		return
+	}
+	var results []string
+	for _, entry := range items {
+		m, _ := entry.(map[string]interface{})
+		if m["status"] != "Complete" {
+			return
+		}
+		result, _ := m["result"].(string)
+		results = append(results, result)
+	}
+	wi.completeMap(ctx, kubeconfig, logger, wfObjName, stepName, handler, results)
+}
+
+// write the collected results of a map step to flowData and move on to its next steps.
+func (wi *WorkflowInstance) completeMap(ctx context.Context, kubeconfig *string, logger *zap.Logger, wfObjName string, stepName string, handler handler.Handler, results []string) {
+	if output := wi.Workflow.Steps[stepName].Map.Output; output != "" {
+		if results == nil {
+			results = []string{}
+		}
+		data, err := json.Marshal(results)
+		if err == nil {
+			err = util.SetWorkflowObjectFlowDataValues(ctx, kubeconfig, wfObjName, map[string]string{output: string(data)})
+		}
+		if err != nil {
+			logError(logger, wfObjName, stepName, err.Error())
+			wi.failStep(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
+			return
+		}
+	}
+	wi.handleExecutorResponse(ctx, kubeconfig, logger, wfObjName, stepName, handler, ExecutorResponse{Status: "success"})
+}
+
+// cancel the child instances of the items of a map step that are still running.
+func (app *App) cancelMapItems(ctx context.Context, logger *zap.Logger, wfObjName string, stepName string, items []interface{}, reason string) {
+	for _, entry := range items {
+		m, _ := entry.(map[string]interface{})
+		childName, _ := m["childObjName"].(string)
+		if m["status"] != "Running" || childName == "" {
+			continue
+		}
+		err := app.CancelWorkflow(ctx, childName, reason)
+		if err != nil {
+			logError(logger, wfObjName, stepName, err.Error())
+		}
+	}
+}
+
+// continue the map step of a child workflow object that has finished.
+func (app *App) handleMapItemChildEvent(ctx context.Context, logger *zap.Logger, obj *unstructured.Unstructured, status string) {
+	childName := obj.GetName()
+	parentName := obj.GetLabels()[util.ParentWorkflowLabel]
+	parentStep := obj.GetLabels()[util.ParentStepLabel]
+	index, err := strconv.Atoi(obj.GetLabels()[util.ParentItemLabel])
+	if err != nil {
+		logError(logger, parentName, parentStep, fmt.Sprintf("invalid item label on %s", childName))
+		return
+	}
+	wi, err := app.getWorkflowInstanceOfObject(ctx, parentName)
+	if err != nil {
+		logError(logger, parentName, parentStep, err.Error())
+		return
+	}
+	ctx = wi.instanceContext(ctx, parentName)
+	h := app.newHandler(parentName)
+	if status != "Complete" {
+		childMessage, _, _ := unstructured.NestedString(obj.Object, "spec", "message")
+		message := fmt.Sprintf("sub-workflow %s finished with status %s: %s", childName, status, childMessage)
+		wi.finishMapItem(ctx, app.kubeconfig, logger, parentName, parentStep, h, index, "Failure", "", message)
+		return
+	}
+	flowData, _, _ := unstructured.NestedString(obj.Object, "spec", "flowData")
+	childFlowData, _ := util.ConvertJsonStringToStringMap(flowData)
+	result := childFlowData[wi.Workflow.Steps[parentStep].Map.resultKey()]
+	wi.finishMapItem(ctx, app.kubeconfig, logger, parentName, parentStep, h, index, "Complete", result, "")
+}
+
+// read the items of a map step from a flowData JSON array. String elements are passed on as they are,
+// other elements as JSON.
+func parseMapItems(flowData map[string]string, key string) ([]string, error) {
+	value, exist := flowData[util.ParseFlowDataKey(key)]
+	if !exist {
+		message := fmt.Sprintf("key %s is not found in flow data", key)
+		return nil, errors.New(message)
+	}
+	var elements []interface{}
+	err := json.Unmarshal([]byte(value), &elements)
+	if err != nil {
+		message := fmt.Sprintf("flowData %s is not a JSON array: %s", key, err)
+		return nil, errors.New(message)
+	}
+	var items []string
+	for _, element := range elements {
+		if s, ok := element.(string); ok {
+			items = append(items, s)
+			continue
+		}
+		data, err := json.Marshal(element)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, string(data))
+	}
+	return items, nil
+}
+
+func (c MapConfig) itemKey() string {
+	if c.ItemKey == "" {
+		return defaultMapItemKey
+	}
+	return util.ParseFlowDataKey(c.ItemKey)
+}
+
+func (c MapConfig) resultKey() string {
+	if c.ResultKey == "" {
+		return defaultMapResultKey
+	}
+	return util.ParseFlowDataKey(c.ResultKey)
+}
@@ -0,0 +1,224 @@
+package engine
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestParseMapItems(t *testing.T) {
+	tests := []struct {
+		name     string
+		flowData map[string]string
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:     "strings",
+			flowData: map[string]string{"workflow1.items": `["a", "b"]`},
+			want:     []string{"a", "b"},
+		},
+		{
+			name:     "other elements as JSON",
+			flowData: map[string]string{"workflow1.items": `[{"id": 1}, 2, true]`},
+			want:     []string{`{"id":1}`, "2", "true"},
+		},
+		{
+			name:     "empty array",
+			flowData: map[string]string{"workflow1.items": `[]`},
+		},
+		{
+			name:     "missing key",
+			flowData: map[string]string{},
+			wantErr:  true,
+		},
+		{
+			name:     "not an array",
+			flowData: map[string]string{"workflow1.items": `{"a": "b"}`},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMapItems(tt.flowData, "$.workflow1.items")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMapItems error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseMapItems = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func mapTestWorkflows() (Workflow, Workflow) {
+	parent := Workflow{
+		Name:    "workflow1",
+		StartAt: []string{"step1"},
+		Trigger: TriggerCondition{Model: "model1", EventType: "added"},
+		Steps: map[string]Step{
+			"step1": {Type: "map", Map: MapConfig{Items: "$.workflow1.items", Output: "$.workflow1.results"}},
+		},
+	}
+	child := Workflow{
+		Name:    "child1",
+		StartAt: []string{"step1"},
+		Steps:   map[string]Step{"step1": {}},
+	}
+	return parent, child
+}
+
+func TestFinishMapItem(t *testing.T) {
+	tests := []struct {
+		name         string
+		items        []interface{}
+		index        int
+		status       string
+		result       string
+		wantStatus   string
+		wantItems    []string
+		wantFlowData string
+		wantChild    string
+	}{
+		{
+			name: "results stay in item order",
+			items: []interface{}{
+				map[string]interface{}{"item": "a", "status": "Running"},
+				map[string]interface{}{"item": "b", "status": "Complete", "result": "r1"},
+			},
+			index:        0,
+			status:       "Complete",
+			result:       "r0",
+			wantStatus:   "Complete",
+			wantItems:    []string{"Complete", "Complete"},
+			wantFlowData: `{"workflow1.items":"[\"a\",\"b\"]","workflow1.results":"[\"r0\",\"r1\"]"}`,
+		},
+		{
+			name: "failure cancels the remaining items",
+			items: []interface{}{
+				map[string]interface{}{"item": "a", "status": "Running"},
+				map[string]interface{}{"item": "b", "status": "Running", "childObjName": "child-b"},
+				map[string]interface{}{"item": "c", "status": "Pending"},
+			},
+			index:      0,
+			status:     "Failure",
+			wantStatus: "Failure",
+			wantItems:  []string{"Failure", "Running", "Pending"},
+			wantChild:  "Cancelled",
+		},
+		{
+			name: "finished item is ignored",
+			items: []interface{}{
+				map[string]interface{}{"item": "a", "status": "Complete", "result": "r0"},
+				map[string]interface{}{"item": "b", "status": "Running"},
+			},
+			index:      0,
+			status:     "Failure",
+			wantStatus: "Running",
+			wantItems:  []string{"Complete", "Running"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent, child := mapTestWorkflows()
+			app, server, stop := newTestApp(t, parent, child)
+			defer stop()
+			addWorkflowObject(server, app, "wf1", "workflow1", "Running", `{"workflow1.items":"[\"a\",\"b\"]"}`,
+				map[string]interface{}{"name": "step1", "id": "e1", "status": "Running", "items": tt.items})
+			addWorkflowObject(server, app, "child-b", "child1", "Running", "",
+				map[string]interface{}{"name": "step1", "id": "c1", "status": "Running"})
+
+			wi := app.getWorkflowInstance("workflow1")
+			wi.finishMapItem(app.ctx, app.kubeconfig, zap.NewNop(), "wf1", "step1", app.newHandler("wf1"), tt.index, tt.status, tt.result, "")
+			settle(t, app)
+
+			step := workflowObjectSteps(t, server, "wf1")[0]
+			if step["status"] != tt.wantStatus {
+				t.Errorf("step status = %v, want %s", step["status"], tt.wantStatus)
+			}
+			var statuses []string
+			for _, item := range step["items"].([]interface{}) {
+				statuses = append(statuses, item.(map[string]interface{})["status"].(string))
+			}
+			if !reflect.DeepEqual(statuses, tt.wantItems) {
+				t.Errorf("item statuses = %v, want %v", statuses, tt.wantItems)
+			}
+			if tt.wantFlowData != "" {
+				if flowData := workflowObjectSpec(t, server, "wf1", "flowData"); flowData != tt.wantFlowData {
+					t.Errorf("flowData = %v, want %s", flowData, tt.wantFlowData)
+				}
+			}
+			if tt.wantChild != "" {
+				if status := workflowObjectSpec(t, server, "child-b", "status"); status != tt.wantChild {
+					t.Errorf("child status = %v, want %s", status, tt.wantChild)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleMapItemCallback(t *testing.T) {
+	parent, _ := mapTestWorkflows()
+	tests := []struct {
+		name       string
+		body       string
+		wantCode   int
+		wantItem   string
+		wantResult string
+	}{
+		{
+			name:       "success",
+			body:       `{"objName": "wf1", "step": "step1", "itemIndex": 0, "taskToken": "t0", "status": "success", "result": "r0"}`,
+			wantCode:   http.StatusOK,
+			wantItem:   "Complete",
+			wantResult: "r0",
+		},
+		{
+			name:     "failure",
+			body:     `{"objName": "wf1", "step": "step1", "itemIndex": 0, "taskToken": "t0", "status": "failure", "message": "boom"}`,
+			wantCode: http.StatusOK,
+			wantItem: "Failure",
+		},
+		{
+			name:     "token of another item",
+			body:     `{"objName": "wf1", "step": "step1", "itemIndex": 0, "taskToken": "t1", "status": "success"}`,
+			wantCode: http.StatusConflict,
+			wantItem: "Running",
+		},
+		{
+			name:     "unknown item",
+			body:     `{"objName": "wf1", "step": "step1", "itemIndex": 5, "taskToken": "t0", "status": "success"}`,
+			wantCode: http.StatusConflict,
+			wantItem: "Running",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, server, stop := newTestApp(t, parent)
+			defer stop()
+			items := []interface{}{
+				map[string]interface{}{"item": "a", "status": "Running", "taskToken": "t0"},
+				map[string]interface{}{"item": "b", "status": "Running", "taskToken": "t1"},
+			}
+			addWorkflowObject(server, app, "wf1", "workflow1", "Running", `{"workflow1.items":"[\"a\",\"b\"]"}`,
+				map[string]interface{}{"name": "step1", "id": "e1", "status": "Running", "items": items})
+			recorder := httptest.NewRecorder()
+			app.handleCallback(recorder, httptest.NewRequest(http.MethodPost, "/callback", strings.NewReader(tt.body)))
+			settle(t, app)
+			if recorder.Code != tt.wantCode {
+				t.Fatalf("got status code %d (%s), want %d", recorder.Code, recorder.Body.String(), tt.wantCode)
+			}
+			item := workflowObjectSteps(t, server, "wf1")[0]["items"].([]interface{})[0].(map[string]interface{})
+			if item["status"] != tt.wantItem {
+				t.Errorf("item status = %v, want %s", item["status"], tt.wantItem)
+			}
+			if result, _ := item["result"].(string); result != tt.wantResult {
+				t.Errorf("item result = %q, want %q", result, tt.wantResult)
+			}
+		})
+	}
+}
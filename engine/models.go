@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/watch"
+	"sync"
+)
+
+// modelWatches watches the model resources of the GVR map and merges their events into one channel. Watches
+// are started and stopped as the GVR map changes when the config file is reloaded. The channel is closed once
+// ctx is cancelled and every watch has stopped.
+type modelWatches struct {
+	ctx     context.Context
+	start   func(ctx context.Context, gvr GVR) (<-chan watch.Event, error)
+	events  chan watch.Event
+	mu      sync.Mutex
+	cancels map[GVR]context.CancelFunc
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+func newModelWatches(ctx context.Context, start func(ctx context.Context, gvr GVR) (<-chan watch.Event, error)) *modelWatches {
+	m := &modelWatches{
+		ctx:     ctx,
+		start:   start,
+		events:  make(chan watch.Event),
+		cancels: make(map[GVR]context.CancelFunc),
+	}
+	go func() {
+		<-ctx.Done()
+		m.mu.Lock()
+		m.stopped = true
+		m.mu.Unlock()
+		m.wg.Wait()
+		close(m.events)
+	}()
+	return m
+}
+
+// start watches for the GVRs that are not watched yet and stop the watches of the GVRs no longer listed. A
+// watch that cannot be started is logged and tried again on the next update.
+func (m *modelWatches) update(logger *zap.Logger, gvrs []GVR) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped {
+		return
+	}
+	listed := make(map[GVR]bool)
+	for _, gvr := range gvrs {
+		listed[gvr] = true
+		if _, exist := m.cancels[gvr]; exist {
+			continue
+		}
+		ctx, cancel := context.WithCancel(m.ctx)
+		ch, err := m.start(ctx, gvr)
+		if err != nil {
+			cancel()
+			logger.Error(fmt.Sprintf("cannot watch Resource Group: %s, Version: %s, Resource: %s: %s", gvr.Group, gvr.Version, gvr.Resource, err))
+			continue
+		}
+		logger.Info(fmt.Sprintf("Start Watching Resource Group: %s, Version: %s, Resource: %s", gvr.Group, gvr.Version, gvr.Resource))
+		m.cancels[gvr] = cancel
+		m.wg.Add(1)
+		go m.forward(ctx, ch)
+	}
+	for gvr, cancel := range m.cancels {
+		if listed[gvr] {
+			continue
+		}
+		logger.Info(fmt.Sprintf("Stop Watching Resource Group: %s, Version: %s, Resource: %s", gvr.Group, gvr.Version, gvr.Resource))
+		cancel()
+		delete(m.cancels, gvr)
+	}
+}
+
+// pass the events of a watch on until its channel is closed. Events that arrive after the watch was stopped
+// are dropped.
+func (m *modelWatches) forward(ctx context.Context, ch <-chan watch.Event) {
+	defer m.wg.Done()
+	for event := range ch {
+		select {
+		case m.events <- event:
+		case <-ctx.Done():
+		}
+	}
+}
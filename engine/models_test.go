@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func TestModelWatchesUpdate(t *testing.T) {
+	model1 := GVR{Group: "flint.flint.com", Version: "v1", Resource: "model1s"}
+	model2 := GVR{Group: "flint.flint.com", Version: "v1", Resource: "model2s"}
+	broken := GVR{Group: "flint.flint.com", Version: "v1", Resource: "broken"}
+	tests := []struct {
+		name    string
+		updates [][]GVR
+		want    []string
+		stopped []string
+	}{
+		{name: "initial map", updates: [][]GVR{{model1, model2}}, want: []string{"model1s", "model2s"}},
+		{name: "model added", updates: [][]GVR{{model1}, {model1, model2}}, want: []string{"model1s", "model2s"}},
+		{name: "model removed", updates: [][]GVR{{model1, model2}, {model2}}, want: []string{"model2s"}, stopped: []string{"model1s"}},
+		{name: "watch that cannot start", updates: [][]GVR{{model1, broken}}, want: []string{"model1s"}},
+		{name: "empty map", updates: [][]GVR{{model1}, nil}, stopped: []string{"model1s"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			var mu sync.Mutex
+			watchers := make(map[string]*watch.FakeWatcher)
+			contexts := make(map[string]context.Context)
+			m := newModelWatches(ctx, func(ctx context.Context, gvr GVR) (<-chan watch.Event, error) {
+				if gvr == broken {
+					return nil, errors.New("the server could not find the requested resource")
+				}
+				w := watch.NewFake()
+				mu.Lock()
+				watchers[gvr.Resource] = w
+				contexts[gvr.Resource] = ctx
+				mu.Unlock()
+				go func() {
+					<-ctx.Done()
+					w.Stop()
+				}()
+				return w.ResultChan(), nil
+			})
+			for _, gvrs := range tt.updates {
+				m.update(zap.NewNop(), gvrs)
+			}
+			for _, resource := range tt.stopped {
+				if contexts[resource].Err() == nil {
+					t.Errorf("watch of %s is not stopped", resource)
+				}
+			}
+			var got []string
+			m.mu.Lock()
+			for gvr := range m.cancels {
+				got = append(got, gvr.Resource)
+			}
+			m.mu.Unlock()
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("watching %v, want %v", got, tt.want)
+			}
+			// events of the running watches reach the merged channel.
+			for _, resource := range tt.want {
+				mu.Lock()
+				w := watchers[resource]
+				mu.Unlock()
+				go w.Add(nil)
+				if event := <-m.events; event.Type != watch.Added {
+					t.Errorf("got event %s from %s, want ADDED", event.Type, resource)
+				}
+			}
+			// the merged channel is closed once every watch has stopped.
+			cancel()
+			for range m.events {
+			}
+		})
+	}
+}
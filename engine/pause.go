@@ -0,0 +1,37 @@
+package engine
+
+import (
+	"context"
+	"github.com/flintdev/workflow-engine/util"
+	"go.uber.org/zap"
+)
+
+// PauseWorkflow suspends a workflow object. Steps in flight finish, but the steps they lead to are
+// deferred until the object is resumed, and manual step triggers are ignored in the meantime.
+func (app *App) PauseWorkflow(ctx context.Context, wfObjName string) error {
+	logger, _ := zap.NewProduction()
+	defer logger.Sync()
+	err := util.PauseWorkflowObject(ctx, app.kubeconfig, wfObjName)
+	if err != nil {
+		return err
+	}
+	logInfo(logger, wfObjName, "", "Paused workflow")
+	return nil
+}
+
+// ResumeWorkflow continues a paused workflow object with the steps deferred while it was paused.
+func (app *App) ResumeWorkflow(ctx context.Context, wfObjName string) error {
+	wi, err := app.getWorkflowInstanceOfObject(ctx, wfObjName)
+	if err != nil {
+		return err
+	}
+	deferredSteps, err := util.ResumeWorkflowObject(ctx, app.kubeconfig, wfObjName)
+	if err != nil {
+		return err
+	}
+	if len(deferredSteps) == 0 {
+		return nil
+	}
+	app.scheduleSteps(wi, wfObjName, deferredSteps, "Resuming deferred steps")
+	return nil
+}
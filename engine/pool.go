@@ -0,0 +1,166 @@
+package engine
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultMaxWorkers = 64
+const defaultQueueSize = 1024
+
+type ConcurrencyConfig struct {
+	MaxWorkers int `json:"maxWorkers"`
+	QueueSize  int `json:"queueSize"`
+}
+
+// workerPool runs tasks (event handling, step executions) on a fixed set of worker goroutines, bounding how
+// many run at the same time, globally and per workflow. Tasks submitted from the watch loop are queued and
+// block the submitter once the queue is full; continuations spawned by running tasks are queued without
+// blocking, so a full queue cannot deadlock them.
+type workerPool struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	tasks     []poolTask
+	queued    int
+	queueSize int
+	running   map[string]int
+	limits    map[string]int
+	stopped   bool
+	wg        sync.WaitGroup
+}
+
+// a task waiting for a worker. queued marks tasks submitted from the watch loop, which count against the
+// queue size.
+type poolTask struct {
+	workflow string
+	run      func()
+	queued   bool
+}
+
+func newWorkerPool(c ConcurrencyConfig) *workerPool {
+	if c.MaxWorkers <= 0 {
+		c.MaxWorkers = defaultMaxWorkers
+	}
+	if c.QueueSize <= 0 {
+		c.QueueSize = defaultQueueSize
+	}
+	p := &workerPool{
+		queueSize: c.QueueSize,
+		running:   make(map[string]int),
+		limits:    make(map[string]int),
+	}
+	p.cond = sync.NewCond(&p.mu)
+	for i := 0; i < c.MaxWorkers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// limit the number of concurrent tasks of a workflow. A limit of zero or less means no limit. The limit
+// changes in place: tasks already running keep counting against it, so lowering it holds back new tasks
+// until enough of them finish.
+func (p *workerPool) setWorkflowLimit(workflow string, limit int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if limit <= 0 {
+		delete(p.limits, workflow)
+	} else {
+		p.limits[workflow] = limit
+	}
+	p.cond.Broadcast()
+}
+
+// queue a task, blocking while the queue is full.
+func (p *workerPool) submit(workflow string, task func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.queued >= p.queueSize && !p.stopped {
+		p.cond.Wait()
+	}
+	p.add(poolTask{workflow: workflow, run: task, queued: true})
+}
+
+// queue a task spawned by another task without waiting for room in the queue.
+func (p *workerPool) spawn(workflow string, task func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.add(poolTask{workflow: workflow, run: task})
+}
+
+func (p *workerPool) add(task poolTask) {
+	if p.stopped {
+		return
+	}
+	p.wg.Add(1)
+	p.tasks = append(p.tasks, task)
+	if task.queued {
+		p.queued++
+	}
+	p.cond.Broadcast()
+}
+
+func (p *workerPool) work() {
+	for {
+		task, ok := p.next()
+		if !ok {
+			return
+		}
+		task.run()
+		p.mu.Lock()
+		p.running[task.workflow]--
+		p.cond.Broadcast()
+		p.mu.Unlock()
+		p.wg.Done()
+	}
+}
+
+// take the oldest task whose workflow is below its limit, waiting until there is one. A capped workflow
+// does not hold up the tasks of other workflows queued behind it.
+func (p *workerPool) next() (poolTask, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for !p.stopped {
+		for i, task := range p.tasks {
+			if limit, exist := p.limits[task.workflow]; exist && p.running[task.workflow] >= limit {
+				continue
+			}
+			p.tasks = append(p.tasks[:i], p.tasks[i+1:]...)
+			if task.queued {
+				p.queued--
+			}
+			p.running[task.workflow]++
+			p.cond.Broadcast()
+			return task, true
+		}
+		p.cond.Wait()
+	}
+	return poolTask{}, false
+}
+
+// wait for all tasks to finish, giving up after timeout. It reports whether all tasks finished.
+func (p *workerPool) wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// stop the workers once their current tasks finish and drop the tasks that have not started.
+func (p *workerPool) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopped = true
+	for range p.tasks {
+		p.wg.Done()
+	}
+	p.tasks = nil
+	p.queued = 0
+	p.cond.Broadcast()
+}
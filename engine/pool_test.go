@@ -0,0 +1,164 @@
+package engine
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// a counter of concurrently running tasks that remembers its peak.
+type concurrency struct {
+	current int32
+	peak    int32
+}
+
+func (c *concurrency) enter() int32 {
+	n := atomic.AddInt32(&c.current, 1)
+	for {
+		peak := atomic.LoadInt32(&c.peak)
+		if n <= peak || atomic.CompareAndSwapInt32(&c.peak, peak, n) {
+			return n
+		}
+	}
+}
+
+func (c *concurrency) leave() {
+	atomic.AddInt32(&c.current, -1)
+}
+
+func TestWorkerPoolLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		workers   int
+		limit     int
+		tasks     int
+		spawned   bool
+		wantTotal int32
+		wantLimit int32
+	}{
+		{name: "global limit", workers: 3, tasks: 20, wantTotal: 3},
+		{name: "workflow limit", workers: 8, limit: 2, tasks: 20, wantTotal: 8, wantLimit: 2},
+		{name: "workflow limit above workers", workers: 2, limit: 5, tasks: 20, wantTotal: 2, wantLimit: 2},
+		{name: "spawned tasks", workers: 4, limit: 3, tasks: 50, spawned: true, wantTotal: 4, wantLimit: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newWorkerPool(ConcurrencyConfig{MaxWorkers: tt.workers, QueueSize: 4})
+			defer p.stop()
+			p.setWorkflowLimit("capped", tt.limit)
+			var total, capped concurrency
+			var ran int32
+			for i := 0; i < tt.tasks; i++ {
+				workflow := "free"
+				if i%2 == 0 {
+					workflow = "capped"
+				}
+				task := func() {
+					total.enter()
+					if workflow == "capped" {
+						capped.enter()
+						defer capped.leave()
+					}
+					defer total.leave()
+					time.Sleep(2 * time.Millisecond)
+					atomic.AddInt32(&ran, 1)
+				}
+				if tt.spawned {
+					p.spawn(workflow, task)
+				} else {
+					p.submit(workflow, task)
+				}
+			}
+			if !p.wait(5 * time.Second) {
+				t.Fatal("tasks did not finish")
+			}
+			if ran != int32(tt.tasks) {
+				t.Errorf("ran %d tasks, want %d", ran, tt.tasks)
+			}
+			if total.peak > tt.wantTotal {
+				t.Errorf("peak concurrency %d, want at most %d", total.peak, tt.wantTotal)
+			}
+			if tt.wantLimit > 0 && capped.peak > tt.wantLimit {
+				t.Errorf("peak workflow concurrency %d, want at most %d", capped.peak, tt.wantLimit)
+			}
+		})
+	}
+}
+
+func TestWorkerPoolLowerLimitWhileRunning(t *testing.T) {
+	p := newWorkerPool(ConcurrencyConfig{MaxWorkers: 8})
+	defer p.stop()
+	p.setWorkflowLimit("w", 4)
+	var running concurrency
+	release := make(chan struct{})
+	var started sync.WaitGroup
+	started.Add(4)
+	for i := 0; i < 4; i++ {
+		p.spawn("w", func() {
+			running.enter()
+			defer running.leave()
+			started.Done()
+			<-release
+		})
+	}
+	started.Wait()
+	// lowering the limit while four tasks hold slots must not let new tasks exceed the new limit.
+	p.setWorkflowLimit("w", 2)
+	var exceeded int32
+	for i := 0; i < 10; i++ {
+		p.spawn("w", func() {
+			if running.enter() > 2 {
+				atomic.AddInt32(&exceeded, 1)
+			}
+			defer running.leave()
+			time.Sleep(time.Millisecond)
+		})
+	}
+	close(release)
+	if !p.wait(5 * time.Second) {
+		t.Fatal("tasks did not finish")
+	}
+	if exceeded > 0 {
+		t.Errorf("%d tasks started above the lowered limit", exceeded)
+	}
+}
+
+func TestWorkerPoolBoundsGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	p := newWorkerPool(ConcurrencyConfig{MaxWorkers: 4})
+	release := make(chan struct{})
+	for i := 0; i < 1000; i++ {
+		p.spawn("w", func() { <-release })
+	}
+	// the waiting tasks are queued, not parked on goroutines of their own.
+	if n := runtime.NumGoroutine() - before; n > 4 {
+		t.Errorf("%d goroutines for 4 workers", n)
+	}
+	close(release)
+	if !p.wait(5 * time.Second) {
+		t.Fatal("tasks did not finish")
+	}
+	p.stop()
+}
+
+func TestWorkerPoolStopDropsWaitingTasks(t *testing.T) {
+	p := newWorkerPool(ConcurrencyConfig{MaxWorkers: 1})
+	release := make(chan struct{})
+	var ran int32
+	for i := 0; i < 5; i++ {
+		p.spawn("w", func() {
+			<-release
+			atomic.AddInt32(&ran, 1)
+		})
+	}
+	p.stop()
+	close(release)
+	if !p.wait(5 * time.Second) {
+		t.Fatal("stop did not release the waiting tasks")
+	}
+	if ran > 1 {
+		t.Errorf("ran %d tasks after stop, want at most the running one", ran)
+	}
+}
@@ -0,0 +1,220 @@
+package engine
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"sort"
+	"sync"
+)
+
+// registry guards App.WorkflowInstances, which changes at runtime when workflow definitions are loaded from
+// the cluster. The slice is replaced on every change and never modified in place, so readers can keep using
+// the slice and the instances they got. It also guards App.ModelGVRMap and App.Executor, which change when the
+// config file is reloaded.
+type registry struct {
+	mu sync.RWMutex
+}
+
+// get the registered workflow instances.
+func (app *App) workflowInstances() []WorkflowInstance {
+	if app.registry == nil {
+		return app.WorkflowInstances
+	}
+	app.registry.mu.RLock()
+	defer app.registry.mu.RUnlock()
+	return app.WorkflowInstances
+}
+
+// get the model GVRs to watch, in the order of their model names.
+func (app *App) modelGVRs() []GVR {
+	if app.registry != nil {
+		app.registry.mu.RLock()
+		defer app.registry.mu.RUnlock()
+	}
+	var models []string
+	for model := range app.ModelGVRMap {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	var gvrs []GVR
+	for _, model := range models {
+		gvrs = append(gvrs, app.ModelGVRMap[model])
+	}
+	return gvrs
+}
+
+// get the executor config.
+func (app *App) executor() ExecutorConfig {
+	if app.registry != nil {
+		app.registry.mu.RLock()
+		defer app.registry.mu.RUnlock()
+	}
+	return app.Executor
+}
+
+// apply the parts of a config that can change while the engine runs: the model GVR map and the executor.
+func (app *App) setModelsAndExecutor(c Config) {
+	if app.registry != nil {
+		app.registry.mu.Lock()
+		defer app.registry.mu.Unlock()
+	}
+	app.ModelGVRMap = c.GVRMap
+	if c.Executor.URL != "" {
+		app.Executor.URL = c.Executor.URL
+	}
+	if c.Executor.CallbackURL != "" {
+		app.Executor.CallbackURL = c.Executor.CallbackURL
+	}
+	app.Executor.AbortURL = c.Executor.AbortURL
+}
+
+// register a workflow instance as the current version of its workflow. A definition of the workflow that is
+// registered already becomes current again. It returns whether the registered versions changed.
+func (app *App) addWorkflowInstance(wi WorkflowInstance) bool {
+	if app.registry != nil {
+		app.registry.mu.Lock()
+		defer app.registry.mu.Unlock()
+	}
+	index, last := -1, -1
+	for i, registered := range app.WorkflowInstances {
+		if registered.Workflow.Name != wi.Workflow.Name {
+			continue
+		}
+		if registered.hash == wi.hash {
+			index = i
+		}
+		last = i
+	}
+	// the definition is the current version already.
+	if index >= 0 && index == last && !app.WorkflowInstances[index].retired {
+		return false
+	}
+	instances := make([]WorkflowInstance, 0, len(app.WorkflowInstances)+1)
+	for i, registered := range app.WorkflowInstances {
+		if i != index {
+			instances = append(instances, registered)
+		}
+	}
+	app.WorkflowInstances = append(instances, wi)
+	if app.pool != nil {
+		app.pool.setWorkflowLimit(wi.Workflow.Name, wi.Workflow.MaxConcurrency)
+	}
+	return true
+}
+
+// register an earlier version of a workflow, unless the same definition is registered already. The current
+// version of the workflow stays current. It returns whether the instance was added.
+func (app *App) addEarlierWorkflowInstance(wi WorkflowInstance) bool {
+	if app.registry != nil {
+		app.registry.mu.Lock()
+		defer app.registry.mu.Unlock()
+	}
+	for _, registered := range app.WorkflowInstances {
+		if registered.Workflow.Name == wi.Workflow.Name && registered.hash == wi.hash {
+			return false
+		}
+	}
+	instances := make([]WorkflowInstance, 0, len(app.WorkflowInstances)+1)
+	instances = append(instances, wi)
+	app.WorkflowInstances = append(instances, app.WorkflowInstances...)
+	return true
+}
+
+// retire the workflow instances loaded from the given source, except those of the workflow named keep. Retired
+// instances start no new workflow objects but stay registered for the objects running on them. It returns the
+// names of the workflows retired.
+func (app *App) retireWorkflowInstances(source string, keep string) []string {
+	if app.registry != nil {
+		app.registry.mu.Lock()
+		defer app.registry.mu.Unlock()
+	}
+	var retired []string
+	instances := make([]WorkflowInstance, 0, len(app.WorkflowInstances))
+	for _, wi := range app.WorkflowInstances {
+		if wi.source == source && wi.Workflow.Name != keep && !wi.retired {
+			wi.retired = true
+			if !containsString(retired, wi.Workflow.Name) {
+				retired = append(retired, wi.Workflow.Name)
+			}
+		}
+		instances = append(instances, wi)
+	}
+	app.WorkflowInstances = instances
+	return retired
+}
+
+// unregister the retired versions of a workflow that no workflow object runs on any more, keeping those of
+// referenced. It returns the number of versions removed.
+func (app *App) removeRetiredWorkflowInstances(workflowName string, referenced map[string]bool) int {
+	if app.registry != nil {
+		app.registry.mu.Lock()
+		defer app.registry.mu.Unlock()
+	}
+	instances := make([]WorkflowInstance, 0, len(app.WorkflowInstances))
+	for _, wi := range app.WorkflowInstances {
+		if wi.Workflow.Name == workflowName && wi.retired && !referenced[wi.hash] {
+			continue
+		}
+		instances = append(instances, wi)
+	}
+	removed := len(app.WorkflowInstances) - len(instances)
+	app.WorkflowInstances = instances
+	return removed
+}
+
+// unregister the retired versions of a workflow that no workflow object that is not Complete or Cancelled runs
+// on.
+func (app *App) pruneWorkflowInstances(logger *zap.Logger, workflowName string) {
+	// the workflow objects cannot be listed before the engine runs.
+	if app.kubeconfig == nil {
+		return
+	}
+	referenced, err := app.referencedDefinitions(app.ctx, workflowName)
+	if err != nil {
+		logger.Error(err.Error(), zap.String("Workflow", workflowName))
+		return
+	}
+	if removed := app.removeRetiredWorkflowInstances(workflowName, referenced); removed > 0 {
+		logger.Info(fmt.Sprintf("Unregistered %d retired versions of workflow %s", removed, workflowName))
+	}
+}
+
+// validate and register a workflow loaded at runtime from source. A changed definition is registered as a new
+// version and becomes current, while the earlier versions stay loaded for the workflow objects started with
+// them. An invalid definition leaves the registered versions untouched.
+func (app *App) loadWorkflow(logger *zap.Logger, w Workflow, source string) (WorkflowInstance, error) {
+	wi, err := app.newWorkflowInstance(w, source)
+	if err != nil {
+		return WorkflowInstance{}, err
+	}
+	// a source renamed to another workflow no longer defines the old one.
+	for _, retired := range app.retireWorkflowInstances(source, w.Name) {
+		logger.Info(fmt.Sprintf("Retired workflow %s", retired), zap.String("Source", source))
+		app.pruneWorkflowInstances(logger, retired)
+	}
+	if app.addWorkflowInstance(wi) {
+		logger.Info(fmt.Sprintf("Registered workflow %s version %s (%s)", w.Name, w.Version, wi.hash), zap.String("Source", source))
+	}
+	return wi, nil
+}
+
+// validate a workflow loaded at runtime from source and create its instance.
+func (app *App) newWorkflowInstance(w Workflow, source string) (WorkflowInstance, error) {
+	err := ValidateWorkflow(w)
+	if err != nil {
+		return WorkflowInstance{}, err
+	}
+	wi := CreateWorkflowInstance()
+	wi.RegisterWorkflowDefinition(func() Workflow { return w })
+	wi.app = app
+	wi.source = source
+	return wi, nil
+}
+
+// retire every version of the workflows loaded from source, and unregister those no workflow object runs on.
+func (app *App) unloadWorkflows(logger *zap.Logger, source string) {
+	for _, retired := range app.retireWorkflowInstances(source, "") {
+		logger.Info(fmt.Sprintf("Retired workflow %s", retired), zap.String("Source", source))
+		app.pruneWorkflowInstances(logger, retired)
+	}
+}
@@ -0,0 +1,125 @@
+package engine
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestAddWorkflowInstance(t *testing.T) {
+	tests := []struct {
+		name        string
+		registered  []string
+		retired     bool
+		add         string
+		earlier     bool
+		wantAdded   bool
+		wantHashes  []string
+		wantCurrent string
+	}{
+		{name: "first version", add: "a", wantAdded: true, wantHashes: []string{"a"}, wantCurrent: "a"},
+		{name: "new version", registered: []string{"a"}, add: "b", wantAdded: true, wantHashes: []string{"a", "b"}, wantCurrent: "b"},
+		{name: "current again", registered: []string{"a", "b"}, add: "b", wantHashes: []string{"a", "b"}, wantCurrent: "b"},
+		{name: "earlier version made current", registered: []string{"a", "b"}, add: "a", wantAdded: true, wantHashes: []string{"b", "a"}, wantCurrent: "a"},
+		{name: "earlier version loaded", registered: []string{"b"}, add: "a", earlier: true, wantAdded: true, wantHashes: []string{"a", "b"}, wantCurrent: "b"},
+		{name: "earlier version registered", registered: []string{"a", "b"}, add: "a", earlier: true, wantHashes: []string{"a", "b"}, wantCurrent: "b"},
+		{name: "retired version registered again", registered: []string{"a", "b"}, retired: true, add: "b", wantAdded: true, wantHashes: []string{"a", "b"}, wantCurrent: "b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &App{}
+			other := WorkflowInstance{Workflow: Workflow{Name: "workflow2"}, hash: "x"}
+			app.WorkflowInstances = append(app.WorkflowInstances, other)
+			for _, hash := range tt.registered {
+				app.WorkflowInstances = append(app.WorkflowInstances, WorkflowInstance{Workflow: Workflow{Name: "workflow1"}, hash: hash, retired: tt.retired})
+			}
+			wi := WorkflowInstance{Workflow: Workflow{Name: "workflow1"}, hash: tt.add}
+			var added bool
+			if tt.earlier {
+				added = app.addEarlierWorkflowInstance(wi)
+			} else {
+				added = app.addWorkflowInstance(wi)
+			}
+			if added != tt.wantAdded {
+				t.Errorf("added = %v, want %v", added, tt.wantAdded)
+			}
+			var hashes []string
+			for _, registered := range app.workflowInstances() {
+				if registered.Workflow.Name == "workflow1" {
+					hashes = append(hashes, registered.hash)
+				}
+			}
+			if !reflect.DeepEqual(hashes, tt.wantHashes) {
+				t.Errorf("registered %v, want %v", hashes, tt.wantHashes)
+			}
+			if current := app.getWorkflowInstance("workflow1"); current == nil || current.hash != tt.wantCurrent {
+				t.Errorf("current = %v, want %s", current, tt.wantCurrent)
+			}
+			if current := app.getWorkflowInstance("workflow2"); current == nil || current.hash != "x" {
+				t.Errorf("other workflow = %v, want x", current)
+			}
+		})
+	}
+}
+
+func TestRetireWorkflowInstances(t *testing.T) {
+	tests := []struct {
+		name        string
+		source      string
+		keep        string
+		referenced  map[string]bool
+		wantRetired []string
+		wantHashes  []string
+		wantCurrent string
+	}{
+		{
+			name:        "source deleted",
+			source:      "WorkflowDefinition/workflow1",
+			referenced:  map[string]bool{"a": true},
+			wantRetired: []string{"workflow1"},
+			wantHashes:  []string{"a", "c"},
+			wantCurrent: "c",
+		},
+		{
+			name:        "source renamed to the same workflow",
+			source:      "WorkflowDefinition/workflow1",
+			keep:        "workflow1",
+			wantHashes:  []string{"a", "b", "c"},
+			wantCurrent: "b",
+		},
+		{
+			name:        "other source",
+			source:      "WorkflowDefinition/other",
+			wantHashes:  []string{"a", "b", "c"},
+			wantCurrent: "b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &App{WorkflowInstances: []WorkflowInstance{
+				{Workflow: Workflow{Name: "workflow1"}, hash: "c"},
+				{Workflow: Workflow{Name: "workflow1"}, hash: "a", source: "WorkflowDefinition/workflow1"},
+				{Workflow: Workflow{Name: "workflow1"}, hash: "b", source: "WorkflowDefinition/workflow1"},
+			}}
+			retired := app.retireWorkflowInstances(tt.source, tt.keep)
+			if !reflect.DeepEqual(retired, tt.wantRetired) {
+				t.Errorf("retired %v, want %v", retired, tt.wantRetired)
+			}
+			app.removeRetiredWorkflowInstances("workflow1", tt.referenced)
+			var hashes []string
+			for _, wi := range app.workflowInstances() {
+				hashes = append(hashes, wi.hash)
+				if wi.retired && wi.isCurrent() {
+					t.Errorf("retired version %s is current", wi.hash)
+				}
+			}
+			sort.Strings(hashes)
+			if !reflect.DeepEqual(hashes, tt.wantHashes) {
+				t.Errorf("registered %v, want %v", hashes, tt.wantHashes)
+			}
+			if current := app.getWorkflowInstance("workflow1"); current == nil || current.hash != tt.wantCurrent {
+				t.Errorf("current = %v, want %s", current, tt.wantCurrent)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/flintdev/workflow-engine/handler"
+	"github.com/flintdev/workflow-engine/util"
+	"go.uber.org/zap"
+)
+
+// RetryStep runs a failed step of a failed workflow object again. FlowData and completed steps are kept,
+// and the retry is recorded as a new attempt of the step in spec.steps. Once the compensation of the object
+// has started, its completed steps are being undone and it is refused; use CloneAndRerun instead.
+func (app *App) RetryStep(ctx context.Context, wfObjName string, stepName string) error {
+	wi, err := app.getWorkflowInstanceOfObject(ctx, wfObjName)
+	if err != nil {
+		return err
+	}
+	if _, exist := wi.Workflow.Steps[stepName]; !exist {
+		message := fmt.Sprintf("step %s is not defined in workflow %s", stepName, wi.Workflow.Name)
+		return errors.New(message)
+	}
+	err = util.ReopenWorkflowObject(ctx, app.kubeconfig, wfObjName, stepName)
+	if err != nil {
+		return err
+	}
+	app.scheduleSteps(wi, wfObjName, []string{stepName}, "Retrying step")
+	return nil
+}
+
+// RerunFromStep runs a finished workflow object again from the given step, keeping its flowData and history.
+// Like RetryStep, it is refused once the compensation of the object has started.
+func (app *App) RerunFromStep(ctx context.Context, wfObjName string, stepName string) error {
+	wi, err := app.getWorkflowInstanceOfObject(ctx, wfObjName)
+	if err != nil {
+		return err
+	}
+	if _, exist := wi.Workflow.Steps[stepName]; !exist {
+		message := fmt.Sprintf("step %s is not defined in workflow %s", stepName, wi.Workflow.Name)
+		return errors.New(message)
+	}
+	err = util.ReopenWorkflowObject(ctx, app.kubeconfig, wfObjName, "")
+	if err != nil {
+		return err
+	}
+	app.scheduleSteps(wi, wfObjName, []string{stepName}, "Rerunning from step")
+	return nil
+}
+
+// CloneAndRerun creates a new workflow object for the same model object and runs it from the start. It
+// returns the name of the new object, which is labelled with clonedFrom.
+func (app *App) CloneAndRerun(ctx context.Context, wfObjName string) (string, error) {
+	wi, err := app.getWorkflowInstanceOfObject(ctx, wfObjName)
+	if err != nil {
+		return "", err
+	}
+	cloneName := util.GenerateWorkflowObjName()
+	err = util.CloneWorkflowObject(ctx, app.kubeconfig, wfObjName, cloneName)
+	if err != nil {
+		return "", err
+	}
+	app.scheduleSteps(wi, cloneName, wi.Workflow.StartAt, "Running clone of "+wfObjName)
+	return cloneName, nil
+}
+
+// get the registered workflow instance a workflow object was started from.
+func (app *App) getWorkflowInstanceOfObject(ctx context.Context, wfObjName string) (*WorkflowInstance, error) {
+	obj, err := util.GetObj(ctx, app.kubeconfig, util.WFNamespace, util.WFGroup, util.WFVersion, util.WFResource, wfObjName)
+	if err != nil {
+		return nil, err
+	}
+	return app.getWorkflowInstanceOf(obj)
+}
+
+func (app *App) scheduleSteps(wi *WorkflowInstance, wfObjName string, steps []string, message string) {
+	logger, _ := zap.NewProduction()
+	defer logger.Sync()
+	logInfo(logger, wfObjName, "", fmt.Sprintf("%s %v", message, steps))
+	h := app.newHandler(wfObjName)
+	var emptyNextMatchedSteps []NextStep
+	wi.ExecuteWorkflow(app.ctx, app.kubeconfig, logger, h, wfObjName, steps, false, emptyNextMatchedSteps)
+}
+
+func (app *App) newHandler(wfObjName string) handler.Handler {
+	return newHandler(app.kubeconfig, wfObjName)
+}
@@ -0,0 +1,331 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// WorkflowSchema is the JSON Schema of workflow definitions, published as schema/workflow.schema.json for
+// editors and CI checks. Definition files and WorkflowDefinition resources are checked against it before they
+// are decoded, which catches misspelled fields and missing per-type fields such as the inputs of a hub step.
+const WorkflowSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "https://github.com/flintdev/workflow-engine/schema/workflow.schema.json",
+  "title": "Workflow",
+  "description": "A workflow definition of the flint workflow engine.",
+  "$ref": "#/definitions/Workflow",
+  "definitions": {
+    "Workflow": {
+      "type": "object",
+      "properties": {
+        "name": {"type": "string", "minLength": 1},
+        "version": {"type": "string", "maxLength": 63, "pattern": "^(([A-Za-z0-9][-A-Za-z0-9_.]*)?[A-Za-z0-9])?$"},
+        "startAt": {"type": "array", "items": {"type": "string", "minLength": 1}, "minItems": 1},
+        "trigger": {"$ref": "#/definitions/TriggerCondition"},
+        "steps": {"type": "object", "additionalProperties": {"$ref": "#/definitions/Step"}, "minProperties": 1},
+        "maxConcurrency": {"type": "integer", "minimum": 0}
+      },
+      "required": ["startAt", "trigger", "steps"],
+      "additionalProperties": false
+    },
+    "TriggerCondition": {
+      "type": "object",
+      "properties": {
+        "model": {"type": "string", "minLength": 1},
+        "eventType": {"type": "string", "pattern": "^([Aa][Dd][Dd][Ee][Dd]|[Mm][Oo][Dd][Ii][Ff][Ii][Ee][Dd]|[Dd][Ee][Ll][Ee][Tt][Ee][Dd])$"},
+        "when": {"type": "string"}
+      },
+      "required": ["model", "eventType"],
+      "additionalProperties": false
+    },
+    "NextStep": {
+      "type": "object",
+      "properties": {
+        "name": {"type": "string", "minLength": 1},
+        "when": {"type": "string"}
+      },
+      "required": ["name"],
+      "additionalProperties": false
+    },
+    "NextSteps": {"type": "array", "items": {"$ref": "#/definitions/NextStep"}},
+    "Step": {
+      "type": "object",
+      "properties": {
+        "type": {"type": "string", "enum": ["", "automation", "manual", "approval", "hub", "wait", "subworkflow", "map", "loop", "end"]},
+        "trigger": {"$ref": "#/definitions/TriggerCondition"},
+        "inputs": {"type": "array", "items": {"type": "string", "minLength": 1}, "minItems": 1},
+        "condition": {"type": "string", "enum": ["", "all_success", "any_success", "all_done", "n_of_m", "first_completed"]},
+        "joinCount": {"type": "integer", "minimum": 1},
+        "nextSteps": {"$ref": "#/definitions/NextSteps"},
+        "onFailure": {"$ref": "#/definitions/NextSteps"},
+        "onReject": {"$ref": "#/definitions/NextSteps"},
+        "onUnsatisfied": {"$ref": "#/definitions/NextSteps"},
+        "heartbeatTimeout": {"type": "string"},
+        "compensation": {"type": "string"},
+        "approval": {"$ref": "#/definitions/ApprovalConfig"},
+        "wait": {"$ref": "#/definitions/WaitConfig"},
+        "subWorkflow": {"$ref": "#/definitions/SubWorkflowConfig"},
+        "map": {"$ref": "#/definitions/MapConfig"},
+        "loop": {"$ref": "#/definitions/LoopConfig"},
+        "end": {"$ref": "#/definitions/EndConfig"}
+      },
+      "additionalProperties": false,
+      "allOf": [
+        {"if": {"properties": {"type": {"const": "manual"}}, "required": ["type"]}, "then": {"required": ["trigger"]}},
+        {"if": {"properties": {"type": {"const": "approval"}}, "required": ["type", "trigger"]}, "then": {"required": ["approval"], "properties": {"approval": {"required": ["approverField", "decisionField"]}}}},
+        {"if": {"properties": {"type": {"const": "hub"}}, "required": ["type"]}, "then": {"required": ["inputs"]}},
+        {"if": {"properties": {"condition": {"const": "n_of_m"}}, "required": ["condition"]}, "then": {"required": ["joinCount"]}},
+        {"if": {"properties": {"type": {"const": "wait"}}, "required": ["type"]}, "then": {"required": ["wait"]}},
+        {"if": {"properties": {"type": {"const": "subworkflow"}}, "required": ["type"]}, "then": {"required": ["subWorkflow"]}},
+        {"if": {"properties": {"type": {"const": "map"}}, "required": ["type"]}, "then": {"required": ["map"]}},
+        {"if": {"properties": {"type": {"const": "loop"}}, "required": ["type"]}, "then": {"required": ["loop"]}}
+      ]
+    },
+    "ApprovalConfig": {
+      "type": "object",
+      "properties": {
+        "approvers": {"type": "array", "items": {"type": "string"}},
+        "quorum": {"type": "string", "pattern": "^(any|all|\\+?0*[1-9][0-9]*)?$"},
+        "approverField": {"type": "string", "minLength": 1},
+        "groupsField": {"type": "string"},
+        "decisionField": {"type": "string", "minLength": 1}
+      },
+      "additionalProperties": false
+    },
+    "WaitConfig": {
+      "type": "object",
+      "properties": {
+        "duration": {"type": "string"},
+        "until": {"type": "string"},
+        "businessHours": {"$ref": "#/definitions/BusinessHours"}
+      },
+      "minProperties": 1,
+      "additionalProperties": false
+    },
+    "BusinessHours": {
+      "type": "object",
+      "properties": {
+        "days": {"type": "array", "items": {"type": "string"}},
+        "start": {"type": "string"},
+        "end": {"type": "string"},
+        "timezone": {"type": "string"}
+      },
+      "required": ["start", "end"],
+      "additionalProperties": false
+    },
+    "SubWorkflowConfig": {
+      "type": "object",
+      "properties": {
+        "workflow": {"type": "string", "minLength": 1},
+        "inputMapping": {"type": "object", "additionalProperties": {"type": "string"}},
+        "outputMapping": {"type": "object", "additionalProperties": {"type": "string"}}
+      },
+      "required": ["workflow"],
+      "additionalProperties": false
+    },
+    "MapConfig": {
+      "type": "object",
+      "properties": {
+        "items": {"type": "string", "minLength": 1},
+        "maxParallelism": {"type": "integer", "minimum": 0},
+        "workflow": {"type": "string"},
+        "inputMapping": {"type": "object", "additionalProperties": {"type": "string"}},
+        "itemKey": {"type": "string"},
+        "resultKey": {"type": "string"},
+        "output": {"type": "string"}
+      },
+      "required": ["items"],
+      "additionalProperties": false
+    },
+    "LoopConfig": {
+      "type": "object",
+      "properties": {
+        "start": {"type": "string", "minLength": 1},
+        "until": {"type": "string"},
+        "maxIterations": {"type": "integer", "minimum": 1}
+      },
+      "required": ["start"],
+      "additionalProperties": false
+    },
+    "EndConfig": {
+      "type": "object",
+      "properties": {
+        "outcome": {"type": "string"},
+        "result": {"type": "object", "additionalProperties": {"type": "string"}}
+      },
+      "additionalProperties": false
+    }
+  }
+}`
+
+var workflowSchema = mustParseSchema(WorkflowSchema)
+
+func mustParseSchema(s string) map[string]interface{} {
+	var schema map[string]interface{}
+	err := json.Unmarshal([]byte(s), &schema)
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+// ValidateWorkflowDocument checks a workflow definition decoded from JSON against WorkflowSchema. Every
+// problem is reported with the path of the offending field, like ValidateWorkflow.
+func ValidateWorkflowDocument(doc interface{}) error {
+	problems := schemaProblems(workflowSchema, workflowSchema, "", doc)
+	if len(problems) > 0 {
+		message := fmt.Sprintf("invalid workflow definition: %s", strings.Join(problems, "; "))
+		return errors.New(message)
+	}
+	return nil
+}
+
+// check a value against a schema, supporting the subset of JSON Schema draft 7 WorkflowSchema uses.
+func schemaProblems(root map[string]interface{}, schema map[string]interface{}, path string, value interface{}) []string {
+	var problems []string
+	add := func(path string, format string, args ...interface{}) {
+		if path == "" {
+			path = "(root)"
+		}
+		problems = append(problems, fmt.Sprintf("%s: %s", path, fmt.Sprintf(format, args...)))
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		problems = append(problems, schemaProblems(root, resolveSchemaRef(root, ref), path, value)...)
+	}
+	if t, ok := schema["type"].(string); ok && !hasSchemaType(t, value) {
+		add(path, "must be of type %s", t)
+		return problems
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok && !containsValue(enum, value) {
+		var values []string
+		for _, e := range enum {
+			values = append(values, fmt.Sprintf("%q", e))
+		}
+		add(path, "must be one of %s", strings.Join(values, ", "))
+	}
+	if c, ok := schema["const"]; ok && !reflect.DeepEqual(c, value) {
+		add(path, "must be %v", c)
+	}
+	switch v := value.(type) {
+	case string:
+		if n, ok := schema["minLength"].(float64); ok && float64(len(v)) < n {
+			add(path, "must not be empty")
+		}
+		if n, ok := schema["maxLength"].(float64); ok && float64(len(v)) > n {
+			add(path, "must be at most %v characters", n)
+		}
+		if pattern, ok := schema["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(v) {
+			add(path, "must match %s", pattern)
+		}
+	case float64:
+		if n, ok := schema["minimum"].(float64); ok && v < n {
+			add(path, "must be at least %v", n)
+		}
+	case []interface{}:
+		if n, ok := schema["minItems"].(float64); ok && float64(len(v)) < n {
+			add(path, "needs at least %v item(s)", n)
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				problems = append(problems, schemaProblems(root, items, fmt.Sprintf("%s[%d]", path, i), item)...)
+			}
+		}
+	case map[string]interface{}:
+		if n, ok := schema["minProperties"].(float64); ok && float64(len(v)) < n {
+			add(path, "needs at least %v field(s)", n)
+		}
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, exist := v[name.(string)]; !exist {
+					add(joinSchemaPath(path, name.(string)), "is required")
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		var names []string
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if property, ok := properties[name].(map[string]interface{}); ok {
+				problems = append(problems, schemaProblems(root, property, joinSchemaPath(path, name), v[name])...)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					add(joinSchemaPath(path, name), "unknown field")
+				}
+			case map[string]interface{}:
+				problems = append(problems, schemaProblems(root, additional, joinSchemaPath(path, name), v[name])...)
+			}
+		}
+	}
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range allOf {
+			problems = append(problems, schemaProblems(root, sub.(map[string]interface{}), path, value)...)
+		}
+	}
+	if cond, ok := schema["if"].(map[string]interface{}); ok {
+		if len(schemaProblems(root, cond, path, value)) == 0 {
+			if then, ok := schema["then"].(map[string]interface{}); ok {
+				problems = append(problems, schemaProblems(root, then, path, value)...)
+			}
+		}
+	}
+	return problems
+}
+
+// resolve a reference to the definitions of the root schema, the only kind WorkflowSchema uses.
+func resolveSchemaRef(root map[string]interface{}, ref string) map[string]interface{} {
+	definitions, _ := root["definitions"].(map[string]interface{})
+	schema, _ := definitions[strings.TrimPrefix(ref, "#/definitions/")].(map[string]interface{})
+	return schema
+}
+
+func hasSchemaType(t string, value interface{}) bool {
+	switch t {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	}
+	return true
+}
+
+func containsValue(list []interface{}, value interface{}) bool {
+	for _, item := range list {
+		if reflect.DeepEqual(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func joinSchemaPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
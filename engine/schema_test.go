@@ -0,0 +1,174 @@
+package engine
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// the published schema file must stay a copy of WorkflowSchema.
+func TestWorkflowSchemaFile(t *testing.T) {
+	b, err := ioutil.ReadFile("../schema/workflow.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(b)) != strings.TrimSpace(WorkflowSchema) {
+		t.Error("schema/workflow.schema.json differs from WorkflowSchema")
+	}
+}
+
+// a minimal valid definition document: step1 leads to step2. The steps of a case are added to it.
+func workflowDocument(t *testing.T, steps string) interface{} {
+	s := `{"name": "workflow1", "startAt": ["step1"], "trigger": {"model": "model1", "eventType": "added"},
+		"steps": {"step1": {"nextSteps": [{"name": "step2"}]}, "step2": {}` + steps + `}}`
+	var doc interface{}
+	err := json.Unmarshal([]byte(s), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestValidateWorkflowDocument(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   string
+		wantErr []string
+	}{
+		{name: "valid"},
+		{
+			name:    "unknown field",
+			steps:   `, "step3": {"nextStep": []}`,
+			wantErr: []string{"steps.step3.nextStep: unknown field"},
+		},
+		{
+			name:    "wrong type",
+			steps:   `, "step3": {"nextSteps": "step2"}`,
+			wantErr: []string{"steps.step3.nextSteps: must be of type array"},
+		},
+		{
+			name:    "unknown step type",
+			steps:   `, "step3": {"type": "script"}`,
+			wantErr: []string{`steps.step3.type: must be one of "", "automation"`},
+		},
+		{
+			name:    "empty next step name",
+			steps:   `, "step3": {"nextSteps": [{"name": ""}]}`,
+			wantErr: []string{"steps.step3.nextSteps[0].name: must not be empty"},
+		},
+		{
+			name:    "hub without inputs",
+			steps:   `, "step3": {"type": "hub"}`,
+			wantErr: []string{"steps.step3.inputs: is required"},
+		},
+		{
+			name:  "hub without condition",
+			steps: `, "step3": {"type": "hub", "inputs": ["step1"]}`,
+		},
+		{
+			name:    "n of m hub without join count",
+			steps:   `, "step3": {"type": "hub", "inputs": ["step1"], "condition": "n_of_m"}`,
+			wantErr: []string{"steps.step3.joinCount: is required"},
+		},
+		{
+			name:  "event type in mixed case",
+			steps: `, "step3": {"type": "manual", "trigger": {"model": "model1", "eventType": "Modified"}}`,
+		},
+		{
+			name:    "unknown event type",
+			steps:   `, "step3": {"type": "manual", "trigger": {"model": "model1", "eventType": "changed"}}`,
+			wantErr: []string{"steps.step3.trigger.eventType: must match"},
+		},
+		{
+			name:    "unknown quorum",
+			steps:   `, "step3": {"type": "approval", "trigger": {"model": "model1", "eventType": "modified"}, "approval": {"approverField": "spec.approver", "decisionField": "spec.decision", "quorum": "most"}}`,
+			wantErr: []string{"steps.step3.approval.quorum: must match"},
+		},
+		{
+			name:    "fractional max iterations",
+			steps:   `, "step3": {"type": "loop", "loop": {"start": "step1", "maxIterations": 1.5}}`,
+			wantErr: []string{"steps.step3.loop.maxIterations: must be of type integer"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateWorkflowDocument(workflowDocument(t, tt.steps))
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("got no error, want %q", tt.wantErr)
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("got error %v, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+// WorkflowSchema and ValidateWorkflow must accept the same definitions wherever both check a field.
+func TestValidatorsAgree(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   string
+		version string
+		valid   bool
+	}{
+		{name: "valid", valid: true},
+		{name: "hub without condition", steps: `, "step3": {"type": "hub", "inputs": ["step1"]}`, valid: true},
+		{name: "hub with empty condition", steps: `, "step3": {"type": "hub", "inputs": ["step1"], "condition": ""}`, valid: true},
+		{name: "hub with unknown condition", steps: `, "step3": {"type": "hub", "inputs": ["step1"], "condition": "majority"}`},
+		{name: "hub without inputs", steps: `, "step3": {"type": "hub"}`},
+		{name: "n of m hub without join count", steps: `, "step3": {"type": "hub", "inputs": ["step1"], "condition": "n_of_m"}`},
+		{name: "upper case event type", steps: `, "step3": {"type": "manual", "trigger": {"model": "model1", "eventType": "DELETED"}}`, valid: true},
+		{name: "mixed case event type", steps: `, "step3": {"type": "manual", "trigger": {"model": "model1", "eventType": "Modified"}}`, valid: true},
+		{name: "unknown event type", steps: `, "step3": {"type": "manual", "trigger": {"model": "model1", "eventType": "changed"}}`},
+		{name: "manual step without trigger", steps: `, "step3": {"type": "manual"}`},
+		{name: "numeric quorum", steps: `, "step3": {"type": "approval", "trigger": {"model": "model1", "eventType": "modified"}, "approval": {"approverField": "a", "decisionField": "d", "quorum": "2"}}`, valid: true},
+		{name: "zero quorum", steps: `, "step3": {"type": "approval", "trigger": {"model": "model1", "eventType": "modified"}, "approval": {"approverField": "a", "decisionField": "d", "quorum": "0"}}`},
+		{name: "unknown quorum", steps: `, "step3": {"type": "approval", "trigger": {"model": "model1", "eventType": "modified"}, "approval": {"approverField": "a", "decisionField": "d", "quorum": "most"}}`},
+		{name: "approval without trigger", steps: `, "step3": {"type": "approval", "approval": {"approvers": ["alice"]}}`, valid: true},
+		{name: "approval without config", steps: `, "step3": {"type": "approval"}`, valid: true},
+		{name: "approval without decision field", steps: `, "step3": {"type": "approval", "trigger": {"model": "model1", "eventType": "modified"}, "approval": {"approverField": "a"}}`},
+		{name: "wait without time", steps: `, "step3": {"type": "wait", "wait": {}}`},
+		{name: "business hours without end", steps: `, "step3": {"type": "wait", "wait": {"businessHours": {"start": "09:00"}}}`},
+		{name: "sub-workflow without workflow", steps: `, "step3": {"type": "subworkflow", "subWorkflow": {"workflow": ""}}`},
+		{name: "map without items", steps: `, "step3": {"type": "map", "map": {}}`},
+		{name: "unknown step type", steps: `, "step3": {"type": "script"}`},
+		{name: "label value version", version: "v1.0-beta_2", valid: true},
+		{name: "version with a space", version: "1.0 beta"},
+		{name: "version ending in a dash", version: "v1-"},
+		{name: "too long version", version: strings.Repeat("1", 64)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := workflowDocument(t, tt.steps)
+			if tt.version != "" {
+				doc.(map[string]interface{})["version"] = tt.version
+			}
+			schemaErr := ValidateWorkflowDocument(doc)
+			b, err := json.Marshal(doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var w Workflow
+			err = json.Unmarshal(b, &w)
+			if err != nil {
+				t.Fatal(err)
+			}
+			workflowErr := ValidateWorkflow(w)
+			if (schemaErr == nil) != tt.valid {
+				t.Errorf("ValidateWorkflowDocument() = %v, want valid %v", schemaErr, tt.valid)
+			}
+			if (workflowErr == nil) != tt.valid {
+				t.Errorf("ValidateWorkflow() = %v, want valid %v", workflowErr, tt.valid)
+			}
+		})
+	}
+}
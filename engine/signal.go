@@ -0,0 +1,117 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/flintdev/workflow-engine/util"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+// Signal resolves a pending manual step of a workflow object with the chosen next step. Payload entries
+// are written to flowData before the next step runs.
+type Signal struct {
+	ObjName string            `json:"objName"`
+	Step    string            `json:"step"`
+	Next    string            `json:"next"`
+	Payload map[string]string `json:"payload"`
+}
+
+// SignalStep resolves a pending manual step without waiting for a model event. When nextStep is empty, the
+// step must have exactly one next step.
+func (app *App) SignalStep(ctx context.Context, wfObjName string, stepName string, nextStep string, payload map[string]string) error {
+	logger, _ := zap.NewProduction()
+	defer logger.Sync()
+	wi, err := app.getWorkflowInstanceOfObject(ctx, wfObjName)
+	if err != nil {
+		return err
+	}
+	step, exist := wi.Workflow.Steps[stepName]
+	if !exist || step.Type != "manual" {
+		message := fmt.Sprintf("step %s is not a manual step of workflow %s", stepName, wi.Workflow.Name)
+		return errors.New(message)
+	}
+	next, err := chooseNextStep(step, nextStep)
+	if err != nil {
+		return err
+	}
+	executionID, err := util.ClaimWorkflowObjectPendingStep(ctx, app.kubeconfig, wfObjName, stepName)
+	if err != nil {
+		return err
+	}
+	// the step continues in the background, beyond the caller's context.
+	runCtx := util.WithExecution(app.ctx, executionID, "")
+	logInfo(logger, wfObjName, stepName, fmt.Sprintf("Received signal, continuing with step %s", next.Name))
+	if len(payload) > 0 {
+		err = util.SetWorkflowObjectFlowDataValues(ctx, app.kubeconfig, wfObjName, payload)
+		if err != nil {
+			wi.failStep(runCtx, app.kubeconfig, logger, wfObjName, stepName, err.Error())
+			return err
+		}
+	}
+	h := app.newHandler(wfObjName)
+	wi.ExecuteWorkflow(runCtx, app.kubeconfig, logger, h, wfObjName, []string{stepName}, true, []NextStep{next})
+	return nil
+}
+
+// pick the next step a signal continues with.
+func chooseNextStep(step Step, nextStep string) (NextStep, error) {
+	if nextStep == "" {
+		if len(step.NextSteps) != 1 {
+			return NextStep{}, errors.New("the step has several next steps, the signal must choose one")
+		}
+		return step.NextSteps[0], nil
+	}
+	for _, next := range step.NextSteps {
+		if next.Name == nextStep {
+			return next, nil
+		}
+	}
+	message := fmt.Sprintf("%s is not a next step of the step", nextStep)
+	return NextStep{}, errors.New(message)
+}
+
+func (app *App) handleSignal(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeCallbackResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var s Signal
+	err := json.NewDecoder(r.Body).Decode(&s)
+	if err != nil {
+		writeCallbackResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if s.ObjName == "" || s.Step == "" {
+		writeCallbackResponse(w, http.StatusBadRequest, "objName and step are required")
+		return
+	}
+	err = app.SignalStep(app.ctx, s.ObjName, s.Step, s.Next, s.Payload)
+	if err != nil {
+		writeCallbackResponse(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeCallbackResponse(w, http.StatusOK, "")
+}
+
+// handle a signal left as an annotation on the workflow object.
+func (app *App) handleSignalAnnotation(ctx context.Context, logger *zap.Logger, wfObjName string, value string) {
+	// remove the request first so the updates made while resolving the step don't replay it.
+	err := util.RemoveWorkflowObjectAnnotation(ctx, app.kubeconfig, wfObjName, util.SignalAnnotation)
+	if err != nil {
+		logError(logger, wfObjName, "", err.Error())
+		return
+	}
+	var s Signal
+	err = json.Unmarshal([]byte(value), &s)
+	if err != nil {
+		logError(logger, wfObjName, "", fmt.Sprintf("invalid signal annotation: %s", err))
+		return
+	}
+	err = app.SignalStep(ctx, wfObjName, s.Step, s.Next, s.Payload)
+	if err != nil {
+		logError(logger, wfObjName, s.Step, fmt.Sprintf("cannot resolve step with signal: %s", err))
+	}
+}
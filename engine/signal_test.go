@@ -0,0 +1,61 @@
+package engine
+
+import (
+	"context"
+	"testing"
+)
+
+func TestChooseNextStep(t *testing.T) {
+	one := Step{Type: "manual", NextSteps: []NextStep{{Name: "approve"}}}
+	several := Step{Type: "manual", NextSteps: []NextStep{{Name: "approve"}, {Name: "reject", When: "'$.a' == 'b'"}}}
+	tests := []struct {
+		name    string
+		step    Step
+		next    string
+		want    NextStep
+		wantErr bool
+	}{
+		{name: "empty next with one next step", step: one, want: NextStep{Name: "approve"}},
+		{name: "empty next with several next steps", step: several, wantErr: true},
+		{name: "chosen next step", step: several, next: "reject", want: NextStep{Name: "reject", When: "'$.a' == 'b'"}},
+		{name: "unknown next step", step: several, next: "escalate", wantErr: true},
+		{name: "unknown next step with one next step", step: one, next: "escalate", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := chooseNextStep(tt.step, tt.next)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("chooseNextStep error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("chooseNextStep = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignalStepRejectsNonManualSteps(t *testing.T) {
+	w := Workflow{
+		Name:    "workflow1",
+		StartAt: []string{"step1"},
+		Trigger: TriggerCondition{Model: "model1", EventType: "added"},
+		Steps: map[string]Step{
+			"step1": {NextSteps: []NextStep{{Name: "step2"}}},
+			"step2": {},
+		},
+	}
+	app, server, stop := newTestApp(t, w)
+	defer stop()
+	addWorkflowObject(server, app, "wf1", "workflow1", "Running", "",
+		map[string]interface{}{"name": "step1", "id": "e1", "status": "Running"})
+
+	for _, step := range []string{"step1", "missing"} {
+		if err := app.SignalStep(context.Background(), "wf1", step, "", nil); err == nil {
+			t.Errorf("signal to %s was accepted", step)
+		}
+	}
+	settle(t, app)
+	if steps := workflowObjectSteps(t, server, "wf1"); len(steps) != 1 || steps[0]["status"] != "Running" {
+		t.Errorf("steps = %v, want step1 still running", steps)
+	}
+}
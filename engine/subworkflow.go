@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/flintdev/workflow-engine/util"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// SubWorkflowConfig names the registered workflow a subworkflow step runs as a child instance.
+// InputMapping copies parent flowData into the child (child key: parent key) and OutputMapping copies the
+// child's flowData back once it completes (parent key: child key).
+type SubWorkflowConfig struct {
+	Workflow      string            `json:"workflow"`
+	InputMapping  map[string]string `json:"inputMapping"`
+	OutputMapping map[string]string `json:"outputMapping"`
+}
+
+// start the child workflow object of a subworkflow step. The step stays Running until the child finishes.
+func (wi *WorkflowInstance) startSubWorkflow(ctx context.Context, kubeconfig *string, logger *zap.Logger, wfObjName string, stepName string) {
+	config := wi.Workflow.Steps[stepName].SubWorkflow
+	var child *WorkflowInstance
+	if wi.app != nil {
+		child = wi.app.getWorkflowInstance(config.Workflow)
+	}
+	if child == nil {
+		message := fmt.Sprintf("sub-workflow %s is not registered", config.Workflow)
+		logError(logger, wfObjName, stepName, message)
+		wi.failStep(ctx, kubeconfig, logger, wfObjName, stepName, message)
+		return
+	}
+	flowData, err := util.GetWorkflowObjectFlowData(ctx, kubeconfig, wfObjName)
+	if err != nil {
+		logError(logger, wfObjName, stepName, err.Error())
+		wi.failStep(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
+		return
+	}
+	childFlowData, err := mapFlowData(flowData, config.InputMapping)
+	if err != nil {
+		logError(logger, wfObjName, stepName, err.Error())
+		wi.failStep(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
+		return
+	}
+	childName := util.GenerateWorkflowObjName()
+	// link the child before it exists, so that a child finishing right away finds its parent step waiting.
+	err = util.SetWorkflowObjectStepFields(ctx, kubeconfig, wfObjName, stepName, map[string]interface{}{"childObjName": childName})
+	if err != nil {
+		logError(logger, wfObjName, stepName, err.Error())
+		wi.failStep(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
+		return
+	}
+	err = util.CreateChildWorkflowObject(ctx, kubeconfig, childName, wfObjName, stepName, "", child.Workflow.Name, child.Workflow.Version, child.hash, childFlowData)
+	if err != nil {
+		logError(logger, wfObjName, stepName, err.Error())
+		wi.failStep(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
+		return
+	}
+	logInfo(logger, wfObjName, stepName, fmt.Sprintf("started sub-workflow %s as %s", child.Workflow.Name, childName))
+	var emptyNextMatchedSteps []NextStep
+	child.ExecuteWorkflow(wi.app.ctx, kubeconfig, logger, wi.app.newHandler(childName), childName, child.Workflow.StartAt, false, emptyNextMatchedSteps)
+}
+
+// continue the parent step of a child workflow object that has finished.
+func (app *App) handleChildWorkflowEvent(ctx context.Context, logger *zap.Logger, obj *unstructured.Unstructured) {
+	childName := obj.GetName()
+	parentName := obj.GetLabels()[util.ParentWorkflowLabel]
+	parentStep := obj.GetLabels()[util.ParentStepLabel]
+	status, _, _ := unstructured.NestedString(obj.Object, "spec", "status")
+	if status != "Complete" && status != "Failure" && status != "Cancelled" {
+		return
+	}
+	// children created before the execution label was introduced continue the latest entry of the step.
+	if executionID := obj.GetLabels()[util.ParentExecutionLabel]; executionID != "" {
+		ctx = util.WithExecution(ctx, executionID, "")
+	}
+	if _, exist := obj.GetLabels()[util.ParentItemLabel]; exist {
+		app.handleMapItemChildEvent(ctx, logger, obj, status)
+		return
+	}
+	wi, err := app.getWorkflowInstanceOfObject(ctx, parentName)
+	if err != nil {
+		logError(logger, parentName, parentStep, err.Error())
+		return
+	}
+	err = util.ClaimWorkflowObjectStepChild(ctx, app.kubeconfig, parentName, parentStep, childName, status)
+	if err != nil {
+		// the parent step has already been continued.
+		return
+	}
+	ctx = wi.instanceContext(ctx, parentName)
+	if status != "Complete" {
+		childMessage, _, _ := unstructured.NestedString(obj.Object, "spec", "message")
+		message := fmt.Sprintf("sub-workflow %s finished with status %s: %s", childName, status, childMessage)
+		logError(logger, parentName, parentStep, message)
+		wi.failStep(ctx, app.kubeconfig, logger, parentName, parentStep, message)
+		return
+	}
+	outputMapping := wi.Workflow.Steps[parentStep].SubWorkflow.OutputMapping
+	if len(outputMapping) > 0 {
+		flowData, _, _ := unstructured.NestedString(obj.Object, "spec", "flowData")
+		childFlowData, err := util.ConvertJsonStringToStringMap(flowData)
+		if err == nil {
+			var outputs map[string]string
+			outputs, err = mapFlowData(childFlowData, outputMapping)
+			if err == nil {
+				err = util.SetWorkflowObjectFlowDataValues(ctx, app.kubeconfig, parentName, outputs)
+			}
+		}
+		if err != nil {
+			logError(logger, parentName, parentStep, err.Error())
+			wi.failStep(ctx, app.kubeconfig, logger, parentName, parentStep, err.Error())
+			return
+		}
+	}
+	logInfo(logger, parentName, parentStep, fmt.Sprintf("sub-workflow %s completed", childName))
+	h := app.newHandler(parentName)
+	wi.handleExecutorResponse(ctx, app.kubeconfig, logger, parentName, parentStep, h, ExecutorResponse{Status: "success"})
+}
+
+// copy flowData values to new keys. The mapping is from target key to source key.
+func mapFlowData(source map[string]string, mapping map[string]string) (map[string]string, error) {
+	target := make(map[string]string)
+	for to, from := range mapping {
+		value, exist := source[util.ParseFlowDataKey(from)]
+		if !exist {
+			message := fmt.Sprintf("key %s is not found in flow data", from)
+			return target, errors.New(message)
+		}
+		target[util.ParseFlowDataKey(to)] = value
+	}
+	return target, nil
+}
@@ -0,0 +1,157 @@
+package engine
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/flintdev/workflow-engine/util"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestMapFlowData(t *testing.T) {
+	source := map[string]string{"workflow1.step1.owner": "alice", "workflow1.step1.id": "42"}
+	tests := []struct {
+		name    string
+		mapping map[string]string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:    "copies values to the target keys",
+			mapping: map[string]string{"$.child.owner": "$.workflow1.step1.owner", "child.id": "workflow1.step1.id"},
+			want:    map[string]string{"child.owner": "alice", "child.id": "42"},
+		},
+		{
+			name:    "no mapping",
+			mapping: nil,
+			want:    map[string]string{},
+		},
+		{
+			name:    "missing source key",
+			mapping: map[string]string{"$.child.owner": "$.workflow1.step1.name"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mapFlowData(source, tt.mapping)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("mapFlowData error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mapFlowData = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleChildWorkflowEvent(t *testing.T) {
+	w := Workflow{
+		Name:    "workflow1",
+		StartAt: []string{"call"},
+		Trigger: TriggerCondition{Model: "model1", EventType: "added"},
+		Steps: map[string]Step{
+			"call": {Type: "subworkflow", SubWorkflow: SubWorkflowConfig{
+				Workflow:      "child1",
+				OutputMapping: map[string]string{"$.workflow1.call.messageId": "$.child1.send.messageId"},
+			}},
+		},
+	}
+	tests := []struct {
+		name         string
+		child        string
+		status       string
+		flowData     string
+		wantStatus   string
+		wantMessage  string
+		wantFlowData string
+		wantWorkflow string
+	}{
+		{
+			name:         "complete copies the outputs",
+			child:        "child-1",
+			status:       "Complete",
+			flowData:     `{"child1.send.messageId":"m1"}`,
+			wantStatus:   "Complete",
+			wantFlowData: `{"workflow1.call.messageId":"m1"}`,
+			wantWorkflow: "Complete",
+		},
+		{
+			name:         "complete without an output key",
+			child:        "child-1",
+			status:       "Complete",
+			flowData:     `{}`,
+			wantStatus:   "Failure",
+			wantMessage:  "key $.child1.send.messageId is not found in flow data",
+			wantWorkflow: "Failure",
+		},
+		{
+			name:         "failure",
+			child:        "child-1",
+			status:       "Failure",
+			wantStatus:   "Failure",
+			wantMessage:  "sub-workflow child-1 finished with status Failure: boom",
+			wantWorkflow: "Failure",
+		},
+		{
+			name:         "cancelled",
+			child:        "child-1",
+			status:       "Cancelled",
+			wantStatus:   "Failure",
+			wantMessage:  "sub-workflow child-1 finished with status Cancelled: boom",
+			wantWorkflow: "Failure",
+		},
+		{
+			name:         "still running",
+			child:        "child-1",
+			status:       "Running",
+			wantStatus:   "Running",
+			wantWorkflow: "Running",
+		},
+		{
+			name:         "another child",
+			child:        "child-2",
+			status:       "Complete",
+			wantStatus:   "Running",
+			wantWorkflow: "Running",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, server, stop := newTestApp(t, w)
+			defer stop()
+			addWorkflowObject(server, app, "wf1", "workflow1", "Running", "",
+				map[string]interface{}{"name": "call", "id": "e1", "status": "Running", "childObjName": "child-1"})
+			child := &unstructured.Unstructured{Object: map[string]interface{}{
+				"spec": map[string]interface{}{"status": tt.status, "message": "boom", "flowData": tt.flowData},
+			}}
+			child.SetName(tt.child)
+			child.SetLabels(map[string]string{
+				util.ParentWorkflowLabel:  "wf1",
+				util.ParentStepLabel:      "call",
+				util.ParentExecutionLabel: "e1",
+			})
+
+			app.handleChildWorkflowEvent(app.ctx, zap.NewNop(), child)
+			settle(t, app)
+
+			step := workflowObjectSteps(t, server, "wf1")[0]
+			if step["status"] != tt.wantStatus {
+				t.Errorf("step status = %v, want %s", step["status"], tt.wantStatus)
+			}
+			if message, _ := step["message"].(string); tt.wantMessage != "" && !strings.Contains(message, tt.wantMessage) {
+				t.Errorf("step message = %q, want %q", message, tt.wantMessage)
+			}
+			if tt.wantFlowData != "" {
+				if flowData := workflowObjectSpec(t, server, "wf1", "flowData"); flowData != tt.wantFlowData {
+					t.Errorf("flowData = %v, want %s", flowData, tt.wantFlowData)
+				}
+			}
+			if status := workflowObjectSpec(t, server, "wf1", "status"); status != tt.wantWorkflow {
+				t.Errorf("workflow status = %v, want %s", status, tt.wantWorkflow)
+			}
+		})
+	}
+}
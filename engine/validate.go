@@ -0,0 +1,230 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Knetic/govaluate"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the step types the engine runs. An empty type is an automation step, or an end step without next steps.
+var stepTypes = map[string]bool{
+	"": true, "automation": true, "manual": true, "approval": true, "hub": true, "wait": true,
+	"subworkflow": true, "map": true, "loop": true, "end": true,
+}
+
+// ValidateWorkflow checks a workflow definition for errors the engine would otherwise only hit while running
+// it, such as references to undefined steps and unparsable conditions. Every problem is reported with the path
+// of the offending field.
+func ValidateWorkflow(w Workflow) error {
+	var problems []string
+	add := func(path string, format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("%s: %s", path, fmt.Sprintf(format, args...)))
+	}
+	if w.Name == "" {
+		add("name", "is required")
+	}
+	// the version is recorded in the workflowVersion label of every workflow object.
+	for _, message := range validation.IsValidLabelValue(w.Version) {
+		add("version", "%s", message)
+	}
+	if len(w.StartAt) == 0 {
+		add("startAt", "needs at least one step")
+	}
+	for i, name := range w.StartAt {
+		if _, exist := w.Steps[name]; !exist {
+			add(fmt.Sprintf("startAt[%d]", i), "step %s is not defined", name)
+		}
+	}
+	if w.Trigger.Model == "" {
+		add("trigger.model", "is required")
+	}
+	if w.Trigger.EventType == "" {
+		add("trigger.eventType", "is required")
+	}
+	checkEventType(add, "trigger.eventType", w.Trigger.EventType)
+	checkExpression(add, "trigger.when", w.Trigger.When)
+	var names []string
+	for name := range w.Steps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		step := w.Steps[name]
+		path := "steps." + name
+		if !stepTypes[step.Type] {
+			add(path+".type", "unknown step type %s", step.Type)
+		}
+		checkNextSteps(add, w, path+".nextSteps", step.NextSteps)
+		checkNextSteps(add, w, path+".onFailure", step.OnFailure)
+		checkNextSteps(add, w, path+".onReject", step.OnReject)
+		checkNextSteps(add, w, path+".onUnsatisfied", step.OnUnsatisfied)
+		if step.HeartbeatTimeout != "" {
+			d, err := time.ParseDuration(step.HeartbeatTimeout)
+			if err != nil {
+				add(path+".heartbeatTimeout", "cannot parse %s: %s", step.HeartbeatTimeout, err)
+			} else if d <= 0 {
+				add(path+".heartbeatTimeout", "must be positive, got %s", step.HeartbeatTimeout)
+			}
+		}
+		switch step.Type {
+		case "manual", "approval":
+			// an approval step without a trigger only takes decisions through DecideApproval and /decision.
+			trigger := step.StepTrigger
+			hasTrigger := trigger.Model != "" || trigger.EventType != "" || trigger.When != ""
+			if (step.Type == "manual" || hasTrigger) && (trigger.Model == "" || trigger.EventType == "") {
+				add(path+".trigger", "a %s step needs a trigger with model and eventType", step.Type)
+			}
+			checkEventType(add, path+".trigger.eventType", trigger.EventType)
+			checkExpression(add, path+".trigger.when", trigger.When)
+			if step.Type == "approval" {
+				if hasTrigger && step.Approval.ApproverField == "" {
+					add(path+".approval.approverField", "is required for decisions from model events")
+				}
+				if hasTrigger && step.Approval.DecisionField == "" {
+					add(path+".approval.decisionField", "is required for decisions from model events")
+				}
+				checkQuorum(add, path+".approval.quorum", step.Approval)
+			}
+		case "hub":
+			if len(step.Inputs) == 0 {
+				add(path+".inputs", "a hub step needs inputs")
+			}
+			for i, input := range step.Inputs {
+				if _, exist := w.Steps[input]; !exist {
+					add(fmt.Sprintf("%s.inputs[%d]", path, i), "step %s is not defined", input)
+				}
+			}
+			switch step.Condition {
+			case "", JoinAllSuccess, JoinAnySuccess, JoinAllDone, JoinFirstCompleted:
+			case JoinNOfM:
+				if step.JoinCount <= 0 || step.JoinCount > len(step.Inputs) {
+					add(path+".joinCount", "must be between 1 and the number of inputs")
+				}
+			default:
+				add(path+".condition", "unknown join condition %s", step.Condition)
+			}
+		case "wait":
+			if step.Wait.Duration == "" && step.Wait.Until == "" && step.Wait.BusinessHours == nil {
+				add(path+".wait", "needs a duration, until or businessHours")
+			}
+			checkDuration(add, path+".wait.duration", step.Wait.Duration)
+			if b := step.Wait.BusinessHours; b != nil {
+				checkBusinessHours(add, path+".wait.businessHours", *b)
+			}
+		case "subworkflow":
+			if step.SubWorkflow.Workflow == "" {
+				add(path+".subWorkflow.workflow", "is required")
+			}
+		case "map":
+			if step.Map.Items == "" {
+				add(path+".map.items", "is required")
+			}
+		case "loop":
+			if _, exist := w.Steps[step.Loop.Start]; !exist {
+				add(path+".loop.start", "step %s is not defined", step.Loop.Start)
+			}
+			checkExpression(add, path+".loop.until", step.Loop.Until)
+		}
+	}
+	if len(problems) > 0 {
+		message := fmt.Sprintf("invalid workflow %s: %s", w.Name, strings.Join(problems, "; "))
+		return errors.New(message)
+	}
+	return nil
+}
+
+func checkNextSteps(add func(string, string, ...interface{}), w Workflow, path string, nextSteps []NextStep) {
+	for i, next := range nextSteps {
+		if _, exist := w.Steps[next.Name]; !exist {
+			add(fmt.Sprintf("%s[%d].name", path, i), "step %s is not defined", next.Name)
+		}
+		checkExpression(add, fmt.Sprintf("%s[%d].when", path, i), next.When)
+	}
+}
+
+func checkDuration(add func(string, string, ...interface{}), path string, duration string) {
+	if duration == "" {
+		return
+	}
+	if _, err := time.ParseDuration(duration); err != nil {
+		add(path, "cannot parse %s: %s", duration, err)
+	}
+}
+
+// check business hours the way BusinessHours.next reads them, so that a wait step cannot fail on them later.
+func checkBusinessHours(add func(string, string, ...interface{}), path string, b BusinessHours) {
+	if b.Start == "" || b.End == "" {
+		add(path, "needs a start and an end")
+	}
+	if b.Timezone != "" {
+		if _, err := time.LoadLocation(b.Timezone); err != nil {
+			add(path+".timezone", "unknown time zone %s", b.Timezone)
+		}
+	}
+	start, startErr := time.Parse("15:04", b.Start)
+	if b.Start != "" && startErr != nil {
+		add(path+".start", "must be a time like 09:00, got %s", b.Start)
+	}
+	end, endErr := time.Parse("15:04", b.End)
+	if b.End != "" && endErr != nil {
+		add(path+".end", "must be a time like 17:00, got %s", b.End)
+	}
+	if startErr == nil && endErr == nil && !end.After(start) {
+		add(path+".end", "%s is not after start %s", b.End, b.Start)
+	}
+	for i, day := range b.Days {
+		if _, err := parseWeekday(day); err != nil {
+			add(fmt.Sprintf("%s.days[%d]", path, i), "unknown day %s", day)
+		}
+	}
+}
+
+// check that an event type names a watch event. Triggers match events regardless of case.
+func checkEventType(add func(string, string, ...interface{}), path string, eventType string) {
+	switch strings.ToLower(eventType) {
+	case "", "added", "modified", "deleted":
+		return
+	}
+	add(path, "must be added, modified or deleted, got %s", eventType)
+}
+
+// check that the quorum of an approval step is known and can be reached. A numeric quorum cannot exceed the
+// number of approvers unless a group entry lets more people decide.
+func checkQuorum(add func(string, string, ...interface{}), path string, c ApprovalConfig) {
+	switch c.Quorum {
+	case "", "any", "all":
+		return
+	}
+	n, err := strconv.Atoi(c.Quorum)
+	if err != nil || n <= 0 {
+		add(path, "must be any, all or a positive number, got %s", c.Quorum)
+		return
+	}
+	if len(c.Approvers) == 0 {
+		return
+	}
+	for _, a := range c.Approvers {
+		if strings.HasPrefix(a, "group:") {
+			return
+		}
+	}
+	if n > len(c.Approvers) {
+		add(path, "needs %d approvals but only %d approvers are listed", n, len(c.Approvers))
+	}
+}
+
+// check that a condition parses, the way parseStepCondition and ParseTriggerCondition read it.
+func checkExpression(add func(string, string, ...interface{}), path string, input string) {
+	if input == "" {
+		return
+	}
+	_, err := govaluate.NewEvaluableExpression(strings.Replace(input, "\"", "'", -1))
+	if err != nil {
+		add(path, "cannot parse %s: %s", input, err)
+	}
+}
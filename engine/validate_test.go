@@ -0,0 +1,293 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+// a minimal valid workflow: step1 leads to step2.
+func validWorkflow() Workflow {
+	return Workflow{
+		Name:    "workflow1",
+		StartAt: []string{"step1"},
+		Trigger: TriggerCondition{Model: "model1", EventType: "added"},
+		Steps: map[string]Step{
+			"step1": {NextSteps: []NextStep{{Name: "step2"}}},
+			"step2": {},
+		},
+	}
+}
+
+func TestValidateWorkflow(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(w *Workflow)
+		wantErr []string
+	}{
+		{name: "valid", modify: func(w *Workflow) {}},
+		{
+			name:    "missing name",
+			modify:  func(w *Workflow) { w.Name = "" },
+			wantErr: []string{"name: is required"},
+		},
+		{
+			name:    "version that is no label value",
+			modify:  func(w *Workflow) { w.Version = "1.0 beta" },
+			wantErr: []string{"version: a valid label must be"},
+		},
+		{
+			name:    "no start steps",
+			modify:  func(w *Workflow) { w.StartAt = nil },
+			wantErr: []string{"startAt: needs at least one step"},
+		},
+		{
+			name:    "undefined start step",
+			modify:  func(w *Workflow) { w.StartAt = []string{"step1", "step9"} },
+			wantErr: []string{"startAt[1]: step step9 is not defined"},
+		},
+		{
+			name:    "missing trigger",
+			modify:  func(w *Workflow) { w.Trigger = TriggerCondition{} },
+			wantErr: []string{"trigger.model: is required", "trigger.eventType: is required"},
+		},
+		{
+			name:   "event type in upper case",
+			modify: func(w *Workflow) { w.Trigger.EventType = "MODIFIED" },
+		},
+		{
+			name:    "unknown event type",
+			modify:  func(w *Workflow) { w.Trigger.EventType = "created" },
+			wantErr: []string{"trigger.eventType: must be added, modified or deleted, got created"},
+		},
+		{
+			name:    "unparsable trigger condition",
+			modify:  func(w *Workflow) { w.Trigger.When = "a ==" },
+			wantErr: []string{"trigger.when: cannot parse a =="},
+		},
+		{
+			name:    "unknown step type",
+			modify:  func(w *Workflow) { w.Steps["step2"] = Step{Type: "script"} },
+			wantErr: []string{"steps.step2.type: unknown step type script"},
+		},
+		{
+			name: "undefined next steps",
+			modify: func(w *Workflow) {
+				w.Steps["step1"] = Step{
+					NextSteps: []NextStep{{Name: "step2"}, {Name: "step9"}},
+					OnFailure: []NextStep{{Name: "step8"}},
+				}
+			},
+			wantErr: []string{"steps.step1.nextSteps[1].name: step step9 is not defined", "steps.step1.onFailure[0].name: step step8 is not defined"},
+		},
+		{
+			name: "unparsable next step condition",
+			modify: func(w *Workflow) {
+				w.Steps["step1"] = Step{NextSteps: []NextStep{{Name: "step2", When: "(a"}}}
+			},
+			wantErr: []string{"steps.step1.nextSteps[0].when: cannot parse (a"},
+		},
+		{
+			name:    "manual step without trigger",
+			modify:  func(w *Workflow) { w.Steps["step2"] = Step{Type: "manual"} },
+			wantErr: []string{"steps.step2.trigger: a manual step needs a trigger with model and eventType"},
+		},
+		{
+			name: "manual step with unknown event type",
+			modify: func(w *Workflow) {
+				w.Steps["step2"] = Step{Type: "manual", StepTrigger: TriggerCondition{Model: "model1", EventType: "changed"}}
+			},
+			wantErr: []string{"steps.step2.trigger.eventType: must be added, modified or deleted, got changed"},
+		},
+		{
+			name: "approval step without trigger",
+			modify: func(w *Workflow) {
+				w.Steps["step2"] = Step{Type: "approval", Approval: ApprovalConfig{Approvers: []string{"alice"}}}
+			},
+		},
+		{
+			name: "approval step with incomplete trigger",
+			modify: func(w *Workflow) {
+				w.Steps["step2"] = Step{Type: "approval", StepTrigger: TriggerCondition{Model: "model1"}}
+			},
+			wantErr: []string{"steps.step2.trigger: a approval step needs a trigger with model and eventType"},
+		},
+		{
+			name: "approval step without fields",
+			modify: func(w *Workflow) {
+				w.Steps["step2"] = Step{Type: "approval", StepTrigger: TriggerCondition{Model: "model1", EventType: "modified"}}
+			},
+			wantErr: []string{"steps.step2.approval.approverField: is required", "steps.step2.approval.decisionField: is required"},
+		},
+		{
+			name: "hub",
+			modify: func(w *Workflow) {
+				w.Steps["step2"] = Step{Type: "hub", Inputs: []string{"step1"}}
+			},
+		},
+		{
+			name:    "hub without inputs",
+			modify:  func(w *Workflow) { w.Steps["step2"] = Step{Type: "hub"} },
+			wantErr: []string{"steps.step2.inputs: a hub step needs inputs"},
+		},
+		{
+			name: "hub with undefined input",
+			modify: func(w *Workflow) {
+				w.Steps["step2"] = Step{Type: "hub", Inputs: []string{"step1", "step9"}}
+			},
+			wantErr: []string{"steps.step2.inputs[1]: step step9 is not defined"},
+		},
+		{
+			name: "hub with unknown condition",
+			modify: func(w *Workflow) {
+				w.Steps["step2"] = Step{Type: "hub", Inputs: []string{"step1"}, Condition: "majority"}
+			},
+			wantErr: []string{"steps.step2.condition: unknown join condition majority"},
+		},
+		{
+			name: "hub with too large join count",
+			modify: func(w *Workflow) {
+				w.Steps["step2"] = Step{Type: "hub", Inputs: []string{"step1"}, Condition: JoinNOfM, JoinCount: 2}
+			},
+			wantErr: []string{"steps.step2.joinCount: must be between 1 and the number of inputs"},
+		},
+		{
+			name:    "wait without time",
+			modify:  func(w *Workflow) { w.Steps["step2"] = Step{Type: "wait"} },
+			wantErr: []string{"steps.step2.wait: needs a duration, until or businessHours"},
+		},
+		{
+			name: "business hours without end",
+			modify: func(w *Workflow) {
+				w.Steps["step2"] = Step{Type: "wait", Wait: WaitConfig{BusinessHours: &BusinessHours{Start: "09:00"}}}
+			},
+			wantErr: []string{"steps.step2.wait.businessHours: needs a start and an end"},
+		},
+		{
+			name: "wait with unparsable duration",
+			modify: func(w *Workflow) {
+				w.Steps["step2"] = Step{Type: "wait", Wait: WaitConfig{Duration: "1 day"}}
+			},
+			wantErr: []string{"steps.step2.wait.duration: cannot parse 1 day"},
+		},
+		{
+			name: "business hours",
+			modify: func(w *Workflow) {
+				w.Steps["step2"] = Step{Type: "wait", Wait: WaitConfig{BusinessHours: &BusinessHours{
+					Days: []string{"Mon", "tuesday"}, Start: "09:00", End: "17:30", Timezone: "Europe/Berlin",
+				}}}
+			},
+		},
+		{
+			name: "invalid business hours",
+			modify: func(w *Workflow) {
+				w.Steps["step2"] = Step{Type: "wait", Wait: WaitConfig{BusinessHours: &BusinessHours{
+					Days: []string{"Mon", "Funday"}, Start: "9am", End: "17:00", Timezone: "Mars/Olympus",
+				}}}
+			},
+			wantErr: []string{
+				"steps.step2.wait.businessHours.timezone: unknown time zone Mars/Olympus",
+				"steps.step2.wait.businessHours.start: must be a time like 09:00, got 9am",
+				"steps.step2.wait.businessHours.days[1]: unknown day Funday",
+			},
+		},
+		{
+			name: "business hours ending before they start",
+			modify: func(w *Workflow) {
+				w.Steps["step2"] = Step{Type: "wait", Wait: WaitConfig{BusinessHours: &BusinessHours{Start: "17:00", End: "09:00"}}}
+			},
+			wantErr: []string{"steps.step2.wait.businessHours.end: 09:00 is not after start 17:00"},
+		},
+		{
+			name:    "unparsable heartbeat timeout",
+			modify:  func(w *Workflow) { w.Steps["step2"] = Step{HeartbeatTimeout: "soon"} },
+			wantErr: []string{"steps.step2.heartbeatTimeout: cannot parse soon"},
+		},
+		{
+			name:    "negative heartbeat timeout",
+			modify:  func(w *Workflow) { w.Steps["step2"] = Step{HeartbeatTimeout: "-1m"} },
+			wantErr: []string{"steps.step2.heartbeatTimeout: must be positive, got -1m"},
+		},
+		{
+			name:    "sub-workflow without workflow",
+			modify:  func(w *Workflow) { w.Steps["step2"] = Step{Type: "subworkflow"} },
+			wantErr: []string{"steps.step2.subWorkflow.workflow: is required"},
+		},
+		{
+			name:    "map without items",
+			modify:  func(w *Workflow) { w.Steps["step2"] = Step{Type: "map"} },
+			wantErr: []string{"steps.step2.map.items: is required"},
+		},
+		{
+			name: "loop with undefined start",
+			modify: func(w *Workflow) {
+				w.Steps["step2"] = Step{Type: "loop", Loop: LoopConfig{Start: "step9", Until: "a >"}}
+			},
+			wantErr: []string{"steps.step2.loop.start: step step9 is not defined", "steps.step2.loop.until: cannot parse a >"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := validWorkflow()
+			tt.modify(&w)
+			err := ValidateWorkflow(w)
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("got no error, want %q", tt.wantErr)
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("got error %v, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateWorkflowQuorum(t *testing.T) {
+	tests := []struct {
+		name      string
+		approvers []string
+		quorum    string
+		wantErr   string
+	}{
+		{name: "default", approvers: []string{"alice"}},
+		{name: "any", quorum: "any"},
+		{name: "all", approvers: []string{"alice", "bob"}, quorum: "all"},
+		{name: "reachable", approvers: []string{"alice", "bob"}, quorum: "2"},
+		{name: "anyone decides", quorum: "5"},
+		{name: "group approvers", approvers: []string{"alice", "group:finance"}, quorum: "3"},
+		{name: "unreachable", approvers: []string{"alice", "bob"}, quorum: "3", wantErr: "steps.step2.approval.quorum: needs 3 approvals but only 2 approvers are listed"},
+		{name: "zero", quorum: "0", wantErr: "steps.step2.approval.quorum: must be any, all or a positive number"},
+		{name: "unknown", quorum: "most", wantErr: "steps.step2.approval.quorum: must be any, all or a positive number"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := validWorkflow()
+			w.Steps["step2"] = Step{
+				Type:        "approval",
+				StepTrigger: TriggerCondition{Model: "model1", EventType: "modified"},
+				Approval: ApprovalConfig{
+					Approvers:     tt.approvers,
+					Quorum:        tt.quorum,
+					ApproverField: "spec.approver",
+					DecisionField: "spec.decision",
+				},
+			}
+			err := ValidateWorkflow(w)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
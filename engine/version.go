@@ -0,0 +1,177 @@
+package engine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/flintdev/workflow-engine/util"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// compute the hash that identifies a workflow definition, over the canonical form of its definition document.
+func definitionHash(w Workflow) string {
+	b, err := json.Marshal(w)
+	if err != nil {
+		return ""
+	}
+	var doc interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return ""
+	}
+	return documentHash(doc)
+}
+
+// compute the hash of a definition document. Fields that are null, false, zero or empty are dropped and object
+// keys are sorted, so a field the engine adds to its types does not change the hash of definitions that do not
+// set it.
+func documentHash(doc interface{}) string {
+	b, err := json.Marshal(canonicalDocument(doc))
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
+
+// drop the fields of a JSON document that are null, false, zero or empty, recursively. Array elements keep their
+// positions.
+func canonicalDocument(doc interface{}) interface{} {
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{})
+		for key, value := range v {
+			if value := canonicalDocument(value); value != nil {
+				m[key] = value
+			}
+		}
+		if len(m) == 0 {
+			return nil
+		}
+		return m
+	case []interface{}:
+		if len(v) == 0 {
+			return nil
+		}
+		list := make([]interface{}, len(v))
+		for i, value := range v {
+			list[i] = canonicalDocument(value)
+		}
+		return list
+	case string:
+		if v == "" {
+			return nil
+		}
+	case float64:
+		if v == 0 {
+			return nil
+		}
+	case bool:
+		if !v {
+			return nil
+		}
+	}
+	return doc
+}
+
+// get the workflow instance a workflow object was started with. An object records the definition hash and
+// version it started on, and keeps running on that definition while it is registered. Objects started before
+// definitions were versioned run on the current version.
+func (app *App) getWorkflowInstanceOf(obj *unstructured.Unstructured) (*WorkflowInstance, error) {
+	workflowName := obj.GetLabels()["workflowName"]
+	version := obj.GetLabels()[util.WorkflowVersionLabel]
+	hash, _, _ := unstructured.NestedString(obj.Object, "spec", "definitionHash")
+	if version == "" && hash == "" {
+		wi := app.getWorkflowInstance(workflowName)
+		if wi == nil {
+			message := fmt.Sprintf("workflow %s is not registered", workflowName)
+			return nil, errors.New(message)
+		}
+		return wi, nil
+	}
+	var versionMatch *WorkflowInstance
+	instances := app.workflowInstances()
+	for i := len(instances) - 1; i >= 0; i-- {
+		wi := &instances[i]
+		if wi.Workflow.Name != workflowName {
+			continue
+		}
+		if hash != "" && wi.hash == hash {
+			return wi, nil
+		}
+		if versionMatch == nil && version != "" && wi.Workflow.Version == version {
+			versionMatch = wi
+		}
+	}
+	// the definition of the version may have been edited without a version change.
+	if versionMatch != nil {
+		return versionMatch, nil
+	}
+	message := fmt.Sprintf("version %s (definition %s) of workflow %s is not registered", version, hash, workflowName)
+	return nil, errors.New(message)
+}
+
+// check whether the instance is the current version of its workflow, which new workflow objects start on.
+func (wi *WorkflowInstance) isCurrent() bool {
+	if wi.retired {
+		return false
+	}
+	if wi.app == nil {
+		return true
+	}
+	current := wi.app.getWorkflowInstance(wi.Workflow.Name)
+	return current == nil || current.hash == wi.hash
+}
+
+// check whether a workflow object runs on the instance's version of the workflow. An object of the workflow
+// whose version is not registered runs on none of them; the current version reports it.
+func (wi *WorkflowInstance) runs(obj *unstructured.Unstructured) bool {
+	if wi.app == nil {
+		return true
+	}
+	owner, err := wi.app.getWorkflowInstanceOf(obj)
+	if err != nil {
+		if obj.GetLabels()["workflowName"] == wi.Workflow.Name && wi.isCurrent() {
+			wi.app.reportUnregisteredVersion(obj, err)
+		}
+		return false
+	}
+	return owner.Workflow.Name == wi.Workflow.Name && owner.hash == wi.hash
+}
+
+// log that the version a workflow object was started with is not registered and record it as the message of
+// the object, so the object does not wait silently until the version is loaded again.
+func (app *App) reportUnregisteredVersion(obj *unstructured.Unstructured, err error) {
+	if message, _, _ := unstructured.NestedString(obj.Object, "spec", "message"); message == err.Error() {
+		return
+	}
+	logger, _ := zap.NewProduction()
+	defer logger.Sync()
+	logError(logger, obj.GetName(), "", err.Error())
+	err = util.SetWorkflowObjectMessage(app.ctx, app.kubeconfig, obj.GetName(), err.Error())
+	if err != nil {
+		logError(logger, obj.GetName(), "", err.Error())
+	}
+}
+
+// get the definition hashes the workflow objects of a workflow that are not Complete or Cancelled were started
+// with. Failed objects count, as they may still be retried.
+func (app *App) referencedDefinitions(ctx context.Context, workflowName string) (map[string]bool, error) {
+	list, err := util.ListObj(ctx, app.kubeconfig, util.WFNamespace, util.WFGroup, util.WFVersion, util.WFResource, "workflowName="+workflowName)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make(map[string]bool)
+	for _, obj := range list.Items {
+		status, _, _ := unstructured.NestedString(obj.Object, "spec", "status")
+		if status == "Complete" || status == "Cancelled" {
+			continue
+		}
+		hash, _, _ := unstructured.NestedString(obj.Object, "spec", "definitionHash")
+		hashes[hash] = true
+	}
+	return hashes, nil
+}
@@ -0,0 +1,110 @@
+package engine
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDefinitionHash(t *testing.T) {
+	base := definitionHash(validWorkflow())
+	tests := []struct {
+		name   string
+		modify func(doc map[string]interface{})
+		same   bool
+	}{
+		{
+			name:   "unchanged",
+			modify: func(doc map[string]interface{}) {},
+			same:   true,
+		},
+		{
+			name: "field added with its zero value",
+			modify: func(doc map[string]interface{}) {
+				step := doc["steps"].(map[string]interface{})["step1"].(map[string]interface{})
+				step["retryPolicy"] = map[string]interface{}{"maxAttempts": 0.0, "backoff": ""}
+				doc["labels"] = []interface{}{}
+				doc["draft"] = false
+			},
+			same: true,
+		},
+		{
+			name: "zero fields removed",
+			modify: func(doc map[string]interface{}) {
+				delete(doc, "maxConcurrency")
+				delete(doc["steps"].(map[string]interface{}), "step2")
+				doc["steps"].(map[string]interface{})["step2"] = map[string]interface{}{}
+			},
+			same: true,
+		},
+		{
+			name: "step changed",
+			modify: func(doc map[string]interface{}) {
+				doc["steps"].(map[string]interface{})["step2"] = map[string]interface{}{"type": "manual"}
+			},
+		},
+		{
+			name: "start steps reordered",
+			modify: func(doc map[string]interface{}) {
+				doc["startAt"] = []interface{}{"step2", "step1"}
+			},
+		},
+		{
+			name: "version changed",
+			modify: func(doc map[string]interface{}) {
+				doc["version"] = "2"
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(validWorkflow())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var doc map[string]interface{}
+			if err := json.Unmarshal(b, &doc); err != nil {
+				t.Fatal(err)
+			}
+			tt.modify(doc)
+			got := documentHash(doc)
+			if got == "" {
+				t.Fatal("documentHash() is empty")
+			}
+			if (got == base) != tt.same {
+				t.Errorf("documentHash() = %s, definitionHash() = %s, want same: %v", got, base, tt.same)
+			}
+		})
+	}
+}
+
+func TestCanonicalDocument(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  interface{}
+		want interface{}
+	}{
+		{name: "empty string", doc: "", want: nil},
+		{name: "zero", doc: 0.0, want: nil},
+		{name: "false", doc: false, want: nil},
+		{name: "empty object", doc: map[string]interface{}{"a": "", "b": map[string]interface{}{}}, want: nil},
+		{name: "empty array", doc: []interface{}{}, want: nil},
+		{
+			name: "array keeps positions",
+			doc:  []interface{}{"", "a", map[string]interface{}{}},
+			want: []interface{}{nil, "a", nil},
+		},
+		{
+			name: "nested values",
+			doc:  map[string]interface{}{"a": map[string]interface{}{"b": 1.0, "c": ""}, "d": true},
+			want: map[string]interface{}{"a": map[string]interface{}{"b": 1.0}, "d": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := canonicalDocument(tt.doc); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("canonicalDocument() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/flintdev/workflow-engine/handler"
+	"github.com/flintdev/workflow-engine/util"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"strings"
+	"time"
+)
+
+const waitCheckInterval = 5 * time.Second
+
+// WaitConfig describes how long a wait step pauses the workflow. Duration waits a fixed time, Until names
+// a flowData key holding an RFC3339 timestamp, and BusinessHours moves the wake time into the next open
+// business-hours window. When several are set, the latest resulting time wins.
+type WaitConfig struct {
+	Duration      string         `json:"duration"`
+	Until         string         `json:"until"`
+	BusinessHours *BusinessHours `json:"businessHours"`
+}
+
+// BusinessHours is a daily window, such as 09:00 to 17:00, on the given days of the week (Mon, Tue, ...).
+// Days default to Monday to Friday and Timezone to UTC.
+type BusinessHours struct {
+	Days     []string `json:"days"`
+	Start    string   `json:"start"`
+	End      string   `json:"end"`
+	Timezone string   `json:"timezone"`
+}
+
+// start a wait step: persist its wake time on the workflow object and arm a timer for it. The periodic
+// sweep picks the step up again if the engine restarts before the timer fires.
+func (wi *WorkflowInstance) startWait(ctx context.Context, kubeconfig *string, logger *zap.Logger, wfObjName string, stepName string, handler handler.Handler) {
+	wakeAt, err := wi.Workflow.Steps[stepName].Wait.wakeTime(ctx, time.Now(), handler)
+	if err != nil {
+		logError(logger, wfObjName, stepName, err.Error())
+		wi.failStep(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
+		return
+	}
+	at := wakeAt.UTC().Format(time.RFC3339)
+	err = util.SetWorkflowObjectStepFields(ctx, kubeconfig, wfObjName, stepName, map[string]interface{}{"wakeAt": at})
+	if err != nil {
+		logError(logger, wfObjName, stepName, err.Error())
+		wi.failStep(ctx, kubeconfig, logger, wfObjName, stepName, err.Error())
+		return
+	}
+	logInfo(logger, wfObjName, stepName, fmt.Sprintf("waiting until %s", at))
+	time.AfterFunc(time.Until(wakeAt), func() {
+		if ctx.Err() != nil {
+			return
+		}
+		wi.spawn(func() {
+			wi.wakeUp(ctx, kubeconfig, logger, wfObjName, stepName, at, handler)
+		})
+	})
+}
+
+// complete a wait step whose wake time has come and move on to its next steps.
+func (wi *WorkflowInstance) wakeUp(ctx context.Context, kubeconfig *string, logger *zap.Logger, wfObjName string, stepName string, wakeAt string, handler handler.Handler) {
+	if ctx.Err() != nil {
+		return
+	}
+	err := util.ClaimWorkflowObjectStepWakeUp(ctx, kubeconfig, wfObjName, stepName, wakeAt)
+	if err != nil {
+		return
+	}
+	logInfo(logger, wfObjName, stepName, "wait is over")
+	wi.handleExecutorResponse(ctx, kubeconfig, logger, wfObjName, stepName, handler, ExecutorResponse{Status: "success"})
+}
+
+// periodically wake up wait steps whose wake time has passed, such as those armed before a restart.
+func (app *App) monitorWaits(ctx context.Context) {
+	logger, _ := zap.NewProduction()
+	defer logger.Sync()
+	ticker := time.NewTicker(waitCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		list, err := util.ListObj(ctx, app.kubeconfig, util.WFNamespace, util.WFGroup, util.WFVersion, util.WFResource, "")
+		if err != nil {
+			logger.Error(err.Error())
+			continue
+		}
+		for _, obj := range list.Items {
+			app.checkWaitingSteps(ctx, logger, obj)
+		}
+	}
+}
+
+func (app *App) checkWaitingSteps(ctx context.Context, logger *zap.Logger, obj unstructured.Unstructured) {
+	wfObjName := obj.GetName()
+	status, _, _ := unstructured.NestedString(obj.Object, "spec", "status")
+	if status != "Running" && status != "Paused" {
+		return
+	}
+	steps, _, _ := unstructured.NestedSlice(obj.Object, "spec", "steps")
+	for _, s := range steps {
+		step, ok := s.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		stepName, _, _ := unstructured.NestedString(step, "name")
+		stepStatus, _, _ := unstructured.NestedString(step, "status")
+		wakeAt, _, _ := unstructured.NestedString(step, "wakeAt")
+		wokeAt, _, _ := unstructured.NestedString(step, "wokeAt")
+		if stepStatus != "Running" || wakeAt == "" || wokeAt != "" {
+			continue
+		}
+		at, err := time.Parse(time.RFC3339, wakeAt)
+		if err != nil || time.Now().Before(at) {
+			continue
+		}
+		wi, err := app.getWorkflowInstanceOf(&obj)
+		if err != nil {
+			continue
+		}
+		instanceCtx := executionContext(wi.instanceContext(ctx, wfObjName), step)
+		h := app.newHandler(wfObjName)
+		wi.spawn(func() {
+			wi.wakeUp(instanceCtx, app.kubeconfig, logger, wfObjName, stepName, wakeAt, h)
+		})
+	}
+}
+
+// compute when a wait step started at now wakes up.
+func (c WaitConfig) wakeTime(ctx context.Context, now time.Time, handler handler.Handler) (time.Time, error) {
+	if c.Duration == "" && c.Until == "" && c.BusinessHours == nil {
+		return now, errors.New("wait step needs a duration, until or businessHours")
+	}
+	wakeAt := now
+	if c.Duration != "" {
+		d, err := time.ParseDuration(c.Duration)
+		if err != nil {
+			message := fmt.Sprintf("invalid wait duration %s: %s", c.Duration, err)
+			return now, errors.New(message)
+		}
+		wakeAt = now.Add(d)
+	}
+	if c.Until != "" {
+		value, err := handler.FlowData.Get(ctx, c.Until)
+		if err != nil {
+			return now, err
+		}
+		until, err := time.Parse(time.RFC3339, fmt.Sprintf("%v", value))
+		if err != nil {
+			message := fmt.Sprintf("flowData %s is not an RFC3339 time: %s", c.Until, err)
+			return now, errors.New(message)
+		}
+		if until.After(wakeAt) {
+			wakeAt = until
+		}
+	}
+	if c.BusinessHours != nil {
+		return c.BusinessHours.next(wakeAt)
+	}
+	return wakeAt, nil
+}
+
+// get the first time at or after t that lies within the business hours.
+func (b BusinessHours) next(t time.Time) (time.Time, error) {
+	location := time.UTC
+	if b.Timezone != "" {
+		l, err := time.LoadLocation(b.Timezone)
+		if err != nil {
+			message := fmt.Sprintf("invalid businessHours timezone %s: %s", b.Timezone, err)
+			return t, errors.New(message)
+		}
+		location = l
+	}
+	start, err := time.Parse("15:04", b.Start)
+	if err != nil {
+		message := fmt.Sprintf("invalid businessHours start %s: %s", b.Start, err)
+		return t, errors.New(message)
+	}
+	end, err := time.Parse("15:04", b.End)
+	if err != nil {
+		message := fmt.Sprintf("invalid businessHours end %s: %s", b.End, err)
+		return t, errors.New(message)
+	}
+	if !end.After(start) {
+		message := fmt.Sprintf("businessHours end %s is not after start %s", b.End, b.Start)
+		return t, errors.New(message)
+	}
+	days := b.Days
+	if len(days) == 0 {
+		days = []string{"Mon", "Tue", "Wed", "Thu", "Fri"}
+	}
+	open := make(map[time.Weekday]bool)
+	for _, d := range days {
+		weekday, err := parseWeekday(d)
+		if err != nil {
+			return t, err
+		}
+		open[weekday] = true
+	}
+	t = t.In(location)
+	for i := 0; i <= 7; i++ {
+		day := t.AddDate(0, 0, i)
+		if !open[day.Weekday()] {
+			continue
+		}
+		opensAt := time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), 0, 0, location)
+		closesAt := time.Date(day.Year(), day.Month(), day.Day(), end.Hour(), end.Minute(), 0, 0, location)
+		if t.Before(opensAt) {
+			return opensAt, nil
+		}
+		if t.Before(closesAt) {
+			return t, nil
+		}
+	}
+	return t, errors.New("businessHours has no open window")
+}
+
+func parseWeekday(day string) (time.Weekday, error) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(day, d.String()) || strings.EqualFold(day, d.String()[:3]) {
+			return d, nil
+		}
+	}
+	message := fmt.Sprintf("invalid businessHours day %s", day)
+	return time.Sunday, errors.New(message)
+}
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBusinessHoursNext(t *testing.T) {
+	// 2024-01-03 is a Wednesday.
+	at := func(value string) time.Time {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	nineToFive := BusinessHours{Start: "09:00", End: "17:00"}
+	tests := []struct {
+		name    string
+		hours   BusinessHours
+		t       time.Time
+		want    time.Time
+		wantErr string
+	}{
+		{name: "within the window", hours: nineToFive, t: at("2024-01-03T10:30:00Z"), want: at("2024-01-03T10:30:00Z")},
+		{name: "at opening", hours: nineToFive, t: at("2024-01-03T09:00:00Z"), want: at("2024-01-03T09:00:00Z")},
+		{name: "before opening", hours: nineToFive, t: at("2024-01-03T07:00:00Z"), want: at("2024-01-03T09:00:00Z")},
+		{name: "at closing", hours: nineToFive, t: at("2024-01-03T17:00:00Z"), want: at("2024-01-04T09:00:00Z")},
+		{name: "friday evening", hours: nineToFive, t: at("2024-01-05T18:00:00Z"), want: at("2024-01-08T09:00:00Z")},
+		{name: "weekend", hours: nineToFive, t: at("2024-01-06T12:00:00Z"), want: at("2024-01-08T09:00:00Z")},
+		{
+			name:  "custom days",
+			hours: BusinessHours{Days: []string{"saturday", "SUN"}, Start: "10:00", End: "12:00"},
+			t:     at("2024-01-03T10:30:00Z"),
+			want:  at("2024-01-06T10:00:00Z"),
+		},
+		{
+			name:  "timezone",
+			hours: BusinessHours{Start: "09:00", End: "17:00", Timezone: "America/New_York"},
+			t:     at("2024-01-03T12:00:00Z"),
+			want:  at("2024-01-03T14:00:00Z"),
+		},
+		{name: "invalid start", hours: BusinessHours{Start: "9am", End: "17:00"}, wantErr: "invalid businessHours start"},
+		{name: "invalid end", hours: BusinessHours{Start: "09:00", End: "25:00"}, wantErr: "invalid businessHours end"},
+		{name: "end before start", hours: BusinessHours{Start: "22:00", End: "06:00"}, wantErr: "is not after start"},
+		{name: "invalid day", hours: BusinessHours{Days: []string{"Funday"}, Start: "09:00", End: "17:00"}, wantErr: "invalid businessHours day"},
+		{name: "invalid timezone", hours: BusinessHours{Start: "09:00", End: "17:00", Timezone: "Mars/Base"}, wantErr: "invalid businessHours timezone"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.hours.next(tt.t)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("next(%s) = %s, want %s", tt.t, got.UTC(), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseWeekday(t *testing.T) {
+	tests := []struct {
+		day     string
+		want    time.Weekday
+		wantErr bool
+	}{
+		{day: "Mon", want: time.Monday},
+		{day: "monday", want: time.Monday},
+		{day: "SAT", want: time.Saturday},
+		{day: "Sunday", want: time.Sunday},
+		{day: "Mo", wantErr: true},
+		{day: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.day, func(t *testing.T) {
+			got, err := parseWeekday(tt.day)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseWeekday(%q) error %v, want error %v", tt.day, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseWeekday(%q) = %s, want %s", tt.day, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,26 +1,29 @@
 package flowdata
 
-import "github.com/flintdev/workflow-engine/util"
+import (
+	"context"
+	"github.com/flintdev/workflow-engine/util"
+)
 
 type FlowData struct {
 	Kubeconfig *string
 	WFObjName  string
 }
 
-func (fd *FlowData) Set(path string, value string) error {
+func (fd *FlowData) Set(ctx context.Context, path string, value string) error {
 	kubeconfig := fd.Kubeconfig
 	objName := fd.WFObjName
-	err := util.SetWorkflowObjectFlowData(kubeconfig, objName, path, value)
+	err := util.SetWorkflowObjectFlowData(ctx, kubeconfig, objName, path, value)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (fd *FlowData) Get(path string) (interface{}, error) {
+func (fd *FlowData) Get(ctx context.Context, path string) (interface{}, error) {
 	kubeconfig := fd.Kubeconfig
 	objName := fd.WFObjName
-	r, err := util.GetWorkflowObjectFlowDataValue(kubeconfig, objName, path)
+	r, err := util.GetWorkflowObjectFlowDataValue(ctx, kubeconfig, objName, path)
 	if err != nil {
 		return nil, err
 	}
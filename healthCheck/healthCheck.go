@@ -1,11 +1,11 @@
 package healthCheck
 
 import (
+	"context"
 	"encoding/json"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
-	"log"
 	"net/http"
 )
 
@@ -46,8 +46,18 @@ func (k *Kube) checkDefaultNamespace(w http.ResponseWriter, r *http.Request) {
 	w.Write(js)
 }
 
-func HealthCheck(kubeconfig *string) {
+// HealthCheck adds the /health handler to mux and serves mux on :8080 until ctx is cancelled.
+func HealthCheck(ctx context.Context, kubeconfig *string, mux *http.ServeMux) error {
 	kube := Kube{kubeconfig: kubeconfig}
-	http.HandleFunc("/health", kube.checkDefaultNamespace)
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	mux.HandleFunc("/health", kube.checkDefaultNamespace)
+	server := &http.Server{Addr: ":8080", Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+	err := server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,307 @@
+// Package kubetest serves an in-memory Kubernetes API for tests of code that talks to the cluster through a
+// kubeconfig, such as the util helpers and the engine built on them. It supports getting, listing (with label
+// selectors), creating, updating (with resourceVersion conflicts), updating the status of and deleting custom
+// resources. Watches are not supported.
+package kubetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Server is a fake API server. Objects are kept per resource, namespace and name.
+type Server struct {
+	mu         sync.Mutex
+	objects    map[string]map[string]interface{}
+	version    int
+	server     *httptest.Server
+	dir        string
+	kubeconfig string
+}
+
+// NewServer starts a server and writes a kubeconfig that points to it. Close stops it.
+func NewServer() (*Server, error) {
+	s := &Server{objects: make(map[string]map[string]interface{})}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serve))
+	dir, err := ioutil.TempDir("", "kubetest")
+	if err != nil {
+		s.server.Close()
+		return nil, err
+	}
+	s.dir = dir
+	s.kubeconfig = filepath.Join(dir, "config")
+	config := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: kubetest
+  cluster:
+    server: %s
+contexts:
+- name: kubetest
+  context:
+    cluster: kubetest
+current-context: kubetest
+`, s.server.URL)
+	err = ioutil.WriteFile(s.kubeconfig, []byte(config), 0600)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Kubeconfig returns the path of the kubeconfig pointing to the server.
+func (s *Server) Kubeconfig() *string {
+	kubeconfig := s.kubeconfig
+	return &kubeconfig
+}
+
+// Close stops the server and removes its kubeconfig.
+func (s *Server) Close() {
+	s.server.Close()
+	os.RemoveAll(s.dir)
+}
+
+// Add stores an object of a resource, replacing one of the same name. The namespace defaults to "default".
+func (s *Server) Add(resource string, obj map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj = copyObject(obj)
+	s.store(key(resource, namespaceOf(obj), nameOf(obj)), obj)
+}
+
+// Get returns a copy of an object of a resource in the "default" namespace, or nil if there is none.
+func (s *Server) Get(resource string, name string) map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, exist := s.objects[key(resource, "default", name)]
+	if !exist {
+		return nil
+	}
+	return copyObject(obj)
+}
+
+// List returns copies of the objects of a resource in the "default" namespace, sorted by name.
+func (s *Server) List(resource string) []map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var items []map[string]interface{}
+	for _, k := range s.keys(resource, "default") {
+		items = append(items, copyObject(s.objects[k]))
+	}
+	return items
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	// /apis/<group>/<version>/namespaces/<namespace>/<resource>[/<name>[/status]]
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 6 || parts[0] != "apis" || parts[3] != "namespaces" {
+		writeStatus(w, http.StatusNotFound, "NotFound", "unknown path "+r.URL.Path)
+		return
+	}
+	namespace, resource := parts[4], parts[5]
+	name := ""
+	if len(parts) > 6 {
+		name = parts[6]
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet && name == "":
+		if r.URL.Query().Get("watch") != "" {
+			writeStatus(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "watches are not supported")
+			return
+		}
+		s.list(w, r, parts[1]+"/"+parts[2], resource, namespace)
+	case r.Method == http.MethodGet:
+		obj, exist := s.objects[key(resource, namespace, name)]
+		if !exist {
+			writeNotFound(w, resource, name)
+			return
+		}
+		writeJSON(w, http.StatusOK, obj)
+	case r.Method == http.MethodPost && name == "":
+		obj, err := decode(r)
+		if err != nil {
+			writeStatus(w, http.StatusBadRequest, "BadRequest", err.Error())
+			return
+		}
+		k := key(resource, namespace, nameOf(obj))
+		if _, exist := s.objects[k]; exist {
+			writeStatus(w, http.StatusConflict, "AlreadyExists", fmt.Sprintf("%s %q already exists", resource, nameOf(obj)))
+			return
+		}
+		s.store(k, obj)
+		writeJSON(w, http.StatusCreated, obj)
+	case r.Method == http.MethodPut:
+		obj, err := decode(r)
+		if err != nil {
+			writeStatus(w, http.StatusBadRequest, "BadRequest", err.Error())
+			return
+		}
+		k := key(resource, namespace, name)
+		current, exist := s.objects[k]
+		if !exist {
+			writeNotFound(w, resource, name)
+			return
+		}
+		if v := resourceVersionOf(obj); v != "" && v != resourceVersionOf(current) {
+			writeStatus(w, http.StatusConflict, "Conflict", fmt.Sprintf("the object %s has been modified", name))
+			return
+		}
+		s.store(k, obj)
+		writeJSON(w, http.StatusOK, obj)
+	case r.Method == http.MethodDelete && name != "":
+		k := key(resource, namespace, name)
+		if _, exist := s.objects[k]; !exist {
+			writeNotFound(w, resource, name)
+			return
+		}
+		delete(s.objects, k)
+		writeStatus(w, http.StatusOK, "", "")
+	default:
+		writeStatus(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method+" "+r.URL.Path)
+	}
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request, apiVersion string, resource string, namespace string) {
+	selector, err := labels.Parse(r.URL.Query().Get("labelSelector"))
+	if err != nil {
+		writeStatus(w, http.StatusBadRequest, "BadRequest", err.Error())
+		return
+	}
+	items := []interface{}{}
+	for _, k := range s.keys(resource, namespace) {
+		obj := s.objects[k]
+		if selector.Matches(labels.Set(labelsOf(obj))) {
+			items = append(items, obj)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       "List",
+		"metadata":   map[string]interface{}{"resourceVersion": strconv.Itoa(s.version)},
+		"items":      items,
+	})
+}
+
+// store an object under a new resourceVersion.
+func (s *Server) store(k string, obj map[string]interface{}) {
+	s.version++
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	if metadata == nil {
+		metadata = make(map[string]interface{})
+		obj["metadata"] = metadata
+	}
+	metadata["resourceVersion"] = strconv.Itoa(s.version)
+	if _, exist := metadata["namespace"]; !exist {
+		metadata["namespace"] = "default"
+	}
+	if _, exist := metadata["creationTimestamp"]; !exist {
+		metadata["creationTimestamp"] = "2020-01-01T00:00:00Z"
+	}
+	s.objects[k] = obj
+}
+
+func (s *Server) keys(resource string, namespace string) []string {
+	prefix := resource + "/" + namespace + "/"
+	var keys []string
+	for k := range s.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func key(resource string, namespace string, name string) string {
+	return resource + "/" + namespace + "/" + name
+}
+
+func metadataString(obj map[string]interface{}, field string) string {
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	value, _ := metadata[field].(string)
+	return value
+}
+
+func nameOf(obj map[string]interface{}) string {
+	return metadataString(obj, "name")
+}
+
+func resourceVersionOf(obj map[string]interface{}) string {
+	return metadataString(obj, "resourceVersion")
+}
+
+func namespaceOf(obj map[string]interface{}) string {
+	if namespace := metadataString(obj, "namespace"); namespace != "" {
+		return namespace
+	}
+	return "default"
+}
+
+func labelsOf(obj map[string]interface{}) map[string]string {
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	values, _ := metadata["labels"].(map[string]interface{})
+	result := make(map[string]string)
+	for k, v := range values {
+		result[k], _ = v.(string)
+	}
+	return result
+}
+
+// copy an object through JSON, which also turns numbers into the float64 and int64 values decoders produce.
+func copyObject(obj map[string]interface{}) map[string]interface{} {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		panic(err)
+	}
+	var result map[string]interface{}
+	err = json.Unmarshal(b, &result)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+func decode(r *http.Request) (map[string]interface{}, error) {
+	var obj map[string]interface{}
+	err := json.NewDecoder(r.Body).Decode(&obj)
+	return obj, err
+}
+
+func writeNotFound(w http.ResponseWriter, resource string, name string) {
+	writeStatus(w, http.StatusNotFound, "NotFound", fmt.Sprintf("%s %q not found", resource, name))
+}
+
+func writeStatus(w http.ResponseWriter, code int, reason string, message string) {
+	status := "Failure"
+	if code < 300 {
+		status = "Success"
+	}
+	writeJSON(w, code, map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Status",
+		"status":     status,
+		"reason":     reason,
+		"message":    message,
+		"code":       code,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(value)
+}
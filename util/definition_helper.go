@@ -0,0 +1,79 @@
+package util
+
+import (
+	"context"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/util/retry"
+	"time"
+)
+
+const WFDefinitionResource = "workflowdefinitions"
+
+// SetWorkflowDefinitionCondition records the outcome of loading a WorkflowDefinition in its status: a Ready
+// condition with the given status ("True" or "False"), reason and message, the generation it applies to, and
+// the version and hash of the definition that was registered, if any.
+func SetWorkflowDefinitionCondition(ctx context.Context, kubeconfig *string, name string, generation int64, status string, reason string, message string, registered map[string]interface{}) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		obj, err := GetObj(ctx, kubeconfig, WFNamespace, WFGroup, WFVersion, WFDefinitionResource, name)
+		if err != nil {
+			return err
+		}
+		transitionTime := time.Now().UTC().Format(time.RFC3339)
+		conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+		for _, c := range conditions {
+			// keep the transition time while the condition status does not change.
+			if m, ok := c.(map[string]interface{}); ok && m["type"] == "Ready" && m["status"] == status {
+				if at, ok := m["lastTransitionTime"].(string); ok {
+					transitionTime = at
+				}
+			}
+		}
+		condition := map[string]interface{}{
+			"type":               "Ready",
+			"status":             status,
+			"reason":             reason,
+			"message":            message,
+			"lastTransitionTime": transitionTime,
+		}
+		if err := unstructured.SetNestedSlice(obj.Object, []interface{}{condition}, "status", "conditions"); err != nil {
+			return err
+		}
+		if err := unstructured.SetNestedField(obj.Object, generation, "status", "observedGeneration"); err != nil {
+			return err
+		}
+		if registered != nil {
+			if err := unstructured.SetNestedMap(obj.Object, registered, "status", "registered"); err != nil {
+				return err
+			}
+		}
+		return UpdateObjStatus(ctx, kubeconfig, WFNamespace, WFGroup, WFVersion, WFDefinitionResource, obj)
+	})
+}
+
+// AddWorkflowDefinitionVersion records a registered version of a WorkflowDefinition in status.versions, so it can
+// be loaded again after the resource changes or the engine restarts. Recorded versions whose definition hash keep
+// rejects are dropped.
+func AddWorkflowDefinitionVersion(ctx context.Context, kubeconfig *string, name string, version map[string]interface{}, keep func(hash string) bool) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		obj, err := GetObj(ctx, kubeconfig, WFNamespace, WFGroup, WFVersion, WFDefinitionResource, name)
+		if err != nil {
+			return err
+		}
+		versions, _, _ := unstructured.NestedSlice(obj.Object, "status", "versions")
+		var kept []interface{}
+		for _, v := range versions {
+			entry, ok := v.(map[string]interface{})
+			if !ok || entry["definitionHash"] == version["definitionHash"] {
+				continue
+			}
+			if hash, _ := entry["definitionHash"].(string); keep(hash) {
+				kept = append(kept, entry)
+			}
+		}
+		kept = append(kept, version)
+		if err := unstructured.SetNestedSlice(obj.Object, kept, "status", "versions"); err != nil {
+			return err
+		}
+		return UpdateObjStatus(ctx, kubeconfig, WFNamespace, WFGroup, WFVersion, WFDefinitionResource, obj)
+	})
+}
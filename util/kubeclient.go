@@ -1,6 +1,7 @@
 package util
 
 import (
+	"context"
 	"flag"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -19,6 +20,11 @@ type GVR struct {
 }
 
 func GetKubeConfig() *string {
+	// the flag is defined once per process; later calls, such as a second App.Run, reuse its value.
+	if f := flag.Lookup("kubeconfig"); f != nil {
+		kubeconfig := f.Value.String()
+		return &kubeconfig
+	}
 	var kubeconfig *string
 	if home := homedir.HomeDir(); home != "" {
 		kubeconfig = flag.String("kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
@@ -29,8 +35,10 @@ func GetKubeConfig() *string {
 	return kubeconfig
 }
 
-func CreateObject(kubeconfig *string, namespace string, group string, version string, resource string, obj *unstructured.Unstructured) error {
-
+func CreateObject(ctx context.Context, kubeconfig *string, namespace string, group string, version string, resource string, obj *unstructured.Unstructured) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
 	if err != nil {
 		return err
@@ -49,8 +57,11 @@ func CreateObject(kubeconfig *string, namespace string, group string, version st
 	return nil
 }
 
-func GetObj(kubeconfig *string, namespace string, group string, version string, resource string, objName string) (*unstructured.Unstructured, error) {
+func GetObj(ctx context.Context, kubeconfig *string, namespace string, group string, version string, resource string, objName string) (*unstructured.Unstructured, error) {
 	var u *unstructured.Unstructured
+	if err := ctx.Err(); err != nil {
+		return u, err
+	}
 	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
 	if err != nil {
 		return u, err
@@ -68,8 +79,11 @@ func GetObj(kubeconfig *string, namespace string, group string, version string,
 	return result, nil
 }
 
-func ListObj(kubeconfig *string, namespace string, group string, version string, resource string, labelSelector string) (*unstructured.UnstructuredList, error) {
+func ListObj(ctx context.Context, kubeconfig *string, namespace string, group string, version string, resource string, labelSelector string) (*unstructured.UnstructuredList, error) {
 	var errReturn *unstructured.UnstructuredList
+	if err := ctx.Err(); err != nil {
+		return errReturn, err
+	}
 	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
 	if err != nil {
 		return errReturn, err
@@ -90,23 +104,55 @@ func ListObj(kubeconfig *string, namespace string, group string, version string,
 	return list, nil
 }
 
-func WatchObject(kubeconfig *string, namespace string, group string, version string, resource string) <-chan watch.Event {
-	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+func WatchObject(ctx context.Context, kubeconfig *string, namespace string, group string, version string, resource string) <-chan watch.Event {
+	ch, err := StartWatchObject(ctx, kubeconfig, namespace, group, version, resource)
 	if err != nil {
 		panic(err)
 	}
+	return ch
+}
+
+// StartWatchObject watches a resource until ctx is cancelled, like WatchObject, but returns an error instead of
+// panicking when the watch cannot be started.
+func StartWatchObject(ctx context.Context, kubeconfig *string, namespace string, group string, version string, resource string) (<-chan watch.Event, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	if err != nil {
+		return nil, err
+	}
 	client, err := dynamic.NewForConfig(config)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	res := schema.GroupVersionResource{Group: group, Version: version, Resource: resource}
 
 	watcher, err := client.Resource(res).Namespace(namespace).Watch(metav1.ListOptions{})
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	ch := watcher.ResultChan()
+	// the result channel is closed once the watcher is stopped.
+	go func() {
+		<-ctx.Done()
+		watcher.Stop()
+	}()
 
-	return ch
+	return ch, nil
+}
+
+func UpdateObjStatus(ctx context.Context, kubeconfig *string, namespace string, group string, version string, resource string, obj *unstructured.Unstructured) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	if err != nil {
+		return err
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	res := schema.GroupVersionResource{Group: group, Version: version, Resource: resource}
+	_, err = client.Resource(res).Namespace(namespace).UpdateStatus(obj, metav1.UpdateOptions{})
+	return err
 }
@@ -1,16 +1,20 @@
 package util
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/retry"
-	"reflect"
+	"sort"
 	"strings"
 	"time"
 )
@@ -20,7 +24,36 @@ const WFVersion = "v1"
 const WFResource = "workflows"
 const WFNamespace = "default"
 
-func CreateEmptyWorkflowObject(kubeconfig *string, wfObjName string, modelObjName string) error {
+// CancelAnnotation requests the cancellation of a workflow object; its value is recorded as the reason.
+const CancelAnnotation = "flint.flint.com/cancel"
+
+// SignalAnnotation resolves a pending manual step of a workflow object; its value is a JSON signal.
+const SignalAnnotation = "flint.flint.com/signal"
+
+// ParentWorkflowLabel and ParentStepLabel link a child workflow object to the step that started it.
+const ParentWorkflowLabel = "parentWorkflow"
+const ParentStepLabel = "parentStep"
+
+// ParentItemLabel carries the item index of a child workflow object started by a map step.
+const ParentItemLabel = "parentItem"
+
+// ParentExecutionLabel carries the execution ID of the step that started a child workflow object.
+const ParentExecutionLabel = "parentExecution"
+
+// WorkflowVersionLabel carries the version of the workflow definition a workflow object was started with.
+const WorkflowVersionLabel = "workflowVersion"
+
+func CreateEmptyWorkflowObject(ctx context.Context, kubeconfig *string, wfObjName string, modelObjName string, workflowName string, version string, definitionHash string) error {
+	obj := newWorkflowObject(wfObjName, modelObjName, workflowName, version, definitionHash)
+	err := CreateObject(ctx, kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, obj)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// build an empty workflow object pinned to the given version and hash of the workflow definition.
+func newWorkflowObject(wfObjName string, modelObjName string, workflowName string, version string, definitionHash string) *unstructured.Unstructured {
 	obj := &unstructured.Unstructured{
 		Object: map[string]interface{}{
 			"apiVersion": "flint.flint.com/v1",
@@ -29,10 +62,11 @@ func CreateEmptyWorkflowObject(kubeconfig *string, wfObjName string, modelObjNam
 				"name": wfObjName,
 				"labels": map[string]interface{}{
 					"modelObjName": modelObjName,
+					"workflowName": workflowName,
 				},
 			},
 			"spec": map[string]interface{}{
-				"steps":       []map[string]interface{}{},
+				"steps":       []interface{}{},
 				"flowData":    "{}",
 				"currentStep": "init",
 				"status":      "init",
@@ -40,15 +74,19 @@ func CreateEmptyWorkflowObject(kubeconfig *string, wfObjName string, modelObjNam
 			},
 		},
 	}
-	err := CreateObject(kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, obj)
-	if err != nil {
-		return err
+	if version != "" {
+		labels := obj.GetLabels()
+		labels[WorkflowVersionLabel] = version
+		obj.SetLabels(labels)
 	}
-	return nil
+	if definitionHash != "" {
+		obj.Object["spec"].(map[string]interface{})["definitionHash"] = definitionHash
+	}
+	return obj
 }
 
-func GetWorkflowObjectStatus(kubeconfig *string, objName string) (string, error) {
-	result, err := GetObj(kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
+func GetWorkflowObjectStatus(ctx context.Context, kubeconfig *string, objName string) (string, error) {
+	result, err := GetObj(ctx, kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
 
 	if err != nil {
 		return "", err
@@ -63,9 +101,9 @@ func GetWorkflowObjectStatus(kubeconfig *string, objName string) (string, error)
 	return status, nil
 }
 
-func GetWorkflowObjectFlowDataValue(kubeconfig *string, objName string, path string) (interface{}, error) {
+func GetWorkflowObjectFlowDataValue(ctx context.Context, kubeconfig *string, objName string, path string) (interface{}, error) {
 	path = ParseFlowDataKey(path)
-	result, err := GetObj(kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
+	result, err := GetObj(ctx, kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
 
 	if err != nil {
 		return "", err
@@ -88,7 +126,7 @@ func GetWorkflowObjectFlowDataValue(kubeconfig *string, objName string, path str
 	}
 }
 
-func SetWorkflowObjectMessage(kubeconfig *string, objName string, wfMessage string) error {
+func SetWorkflowObjectMessage(ctx context.Context, kubeconfig *string, objName string, wfMessage string) error {
 	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
 	if err != nil {
 		return err
@@ -99,7 +137,7 @@ func SetWorkflowObjectMessage(kubeconfig *string, objName string, wfMessage stri
 	}
 
 	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		result, err := GetObj(kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
+		result, err := GetObj(ctx, kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
 
 		if err != nil {
 			return err
@@ -120,75 +158,68 @@ func SetWorkflowObjectMessage(kubeconfig *string, objName string, wfMessage stri
 	return nil
 }
 
-func SetWorkflowObjectStatus(kubeconfig *string, objName string, status string) error {
-	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
-	if err != nil {
-		return err
-	}
-	client, err := dynamic.NewForConfig(config)
-	if err != nil {
-		return err
-	}
-
-	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		result, err := GetObj(kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
+func SetWorkflowObjectStatus(ctx context.Context, kubeconfig *string, objName string, status string) error {
+	return updateWorkflowObject(ctx, kubeconfig, objName, func(obj *unstructured.Unstructured) error {
+		return setWorkflowObjectStatus(obj, status)
+	})
+}
 
-		if err != nil {
+// set the status of a workflow object. A paused object keeps its Paused status until it is resumed or
+// finishes; finishing it also clears the pause and the steps deferred by it.
+func setWorkflowObjectStatus(obj *unstructured.Unstructured, status string) error {
+	switch status {
+	case "Complete", "Failure", "Cancelled":
+		if err := unstructured.SetNestedField(obj.Object, false, "spec", "paused"); err != nil {
 			return err
 		}
-
-		if err := unstructured.SetNestedField(result.Object, status, "spec", "status"); err != nil {
+		if err := unstructured.SetNestedStringSlice(obj.Object, []string{}, "spec", "deferredSteps"); err != nil {
 			return err
 		}
-
-		res := schema.GroupVersionResource{Group: WFGroup, Version: WFVersion, Resource: WFResource}
-
-		_, err = client.Resource(res).Namespace(WFNamespace).Update(result, metav1.UpdateOptions{})
-		return err
-	})
-	if retryErr != nil {
-		return retryErr
+	default:
+		if paused, _, _ := unstructured.NestedBool(obj.Object, "spec", "paused"); paused {
+			return errSkipUpdate
+		}
 	}
-	return nil
+	return unstructured.SetNestedField(obj.Object, status, "spec", "status")
 }
 
-func SetWorkflowObjectToComplete(kubeconfig *string, objName string) error {
-	err := SetWorkflowObjectStatus(kubeconfig, objName, "Complete")
+func SetWorkflowObjectToComplete(ctx context.Context, kubeconfig *string, objName string) error {
+	err := SetWorkflowObjectStatus(ctx, kubeconfig, objName, "Complete")
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func SetWorkflowObjectToRunning(kubeconfig *string, objName string) error {
-	err := SetWorkflowObjectStatus(kubeconfig, objName, "Running")
+func SetWorkflowObjectToRunning(ctx context.Context, kubeconfig *string, objName string) error {
+	err := SetWorkflowObjectStatus(ctx, kubeconfig, objName, "Running")
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func SetWorkflowObjectToPending(kubeconfig *string, objName string) error {
-	err := SetWorkflowObjectStatus(kubeconfig, objName, "Pending")
+func SetWorkflowObjectToPending(ctx context.Context, kubeconfig *string, objName string) error {
+	err := SetWorkflowObjectStatus(ctx, kubeconfig, objName, "Pending")
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func SetWorkflowObjectToFailure(kubeconfig *string, objName string, wfMessage string) error {
-	err := SetWorkflowObjectStatus(kubeconfig, objName, "Failure")
+func SetWorkflowObjectToFailure(ctx context.Context, kubeconfig *string, objName string, wfMessage string) error {
+	err := SetWorkflowObjectStatus(ctx, kubeconfig, objName, "Failure")
 	if err != nil {
 		return err
 	}
-	err = SetWorkflowObjectMessage(kubeconfig, objName, wfMessage)
+	err = SetWorkflowObjectMessage(ctx, kubeconfig, objName, wfMessage)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func SetWorkflowObjectCurrentStep(kubeconfig *string, objName string, currentStep string) error {
+func SetWorkflowObjectCurrentStep(ctx context.Context, kubeconfig *string, objName string, currentStep string) error {
 	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
 	if err != nil {
 		return err
@@ -199,7 +230,7 @@ func SetWorkflowObjectCurrentStep(kubeconfig *string, objName string, currentSte
 	}
 
 	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		result, err := GetObj(kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
+		result, err := GetObj(ctx, kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
 
 		if err != nil {
 			return err
@@ -220,7 +251,7 @@ func SetWorkflowObjectCurrentStep(kubeconfig *string, objName string, currentSte
 	return nil
 }
 
-func SetWorkflowObjectCurrentStepLabel(kubeconfig *string, objName string, currentStep string) error {
+func SetWorkflowObjectCurrentStepLabel(ctx context.Context, kubeconfig *string, objName string, currentStep string) error {
 
 	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
 	if err != nil {
@@ -232,7 +263,7 @@ func SetWorkflowObjectCurrentStepLabel(kubeconfig *string, objName string, curre
 	}
 
 	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		result, err := GetObj(kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
+		result, err := GetObj(ctx, kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
 		if err != nil {
 			return err
 		}
@@ -251,7 +282,7 @@ func SetWorkflowObjectCurrentStepLabel(kubeconfig *string, objName string, curre
 	return nil
 }
 
-func SetWorkflowObjectPendingStepLabel(kubeconfig *string, objName string, stepName string) error {
+func SetWorkflowObjectPendingStepLabel(ctx context.Context, kubeconfig *string, objName string, stepName string) error {
 
 	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
 	if err != nil {
@@ -263,7 +294,7 @@ func SetWorkflowObjectPendingStepLabel(kubeconfig *string, objName string, stepN
 	}
 
 	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		result, err := GetObj(kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
+		result, err := GetObj(ctx, kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
 		if err != nil {
 			return err
 		}
@@ -281,7 +312,7 @@ func SetWorkflowObjectPendingStepLabel(kubeconfig *string, objName string, stepN
 	return nil
 }
 
-func RemoveWorkflowObjectPendingStepLabel(kubeconfig *string, objName string, stepName string) error {
+func RemoveWorkflowObjectPendingStepLabel(ctx context.Context, kubeconfig *string, objName string, stepName string) error {
 
 	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
 	if err != nil {
@@ -293,7 +324,7 @@ func RemoveWorkflowObjectPendingStepLabel(kubeconfig *string, objName string, st
 	}
 
 	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		result, err := GetObj(kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
+		result, err := GetObj(ctx, kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
 		if err != nil {
 			return err
 		}
@@ -310,9 +341,10 @@ func RemoveWorkflowObjectPendingStepLabel(kubeconfig *string, objName string, st
 	return nil
 }
 
-func SetWorkflowObjectStep(kubeconfig *string, objName string, stepName string) error {
+func SetWorkflowObjectStep(ctx context.Context, kubeconfig *string, objName string, stepName string) error {
 	status := "Running"
 	currentTime := time.Now().UTC().String()
+	executionID, parentID := newExecution(ctx)
 
 	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
 	if err != nil {
@@ -324,21 +356,25 @@ func SetWorkflowObjectStep(kubeconfig *string, objName string, stepName string)
 	}
 
 	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		result, err := GetObj(kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
+		result, err := GetObj(ctx, kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
 		if err != nil {
 			return err
 		}
-		steps, found, err := unstructured.NestedSlice(result.Object, "spec", "steps")
-		if err != nil || !found || steps == nil {
-			message := fmt.Sprintf("steps not found or error in spec: %s", err)
-			return errors.New(message)
+		steps, err := getWorkflowObjectSteps(result)
+		if err != nil {
+			return err
 		}
+		run := getWorkflowObjectRun(result)
 		tempStep := map[string]interface{}{
-			"name":    stepName,
-			"startAt": currentTime,
-			"endAt":   "",
-			"message": "",
-			"status":  status,
+			"name":     stepName,
+			"startAt":  currentTime,
+			"endAt":    "",
+			"message":  "",
+			"status":   status,
+			"attempt":  nextStepAttempt(steps, stepName, run),
+			"run":      run,
+			"id":       executionID,
+			"parentId": parentID,
 		}
 		newSteps := append(steps, tempStep)
 
@@ -365,9 +401,10 @@ func SetWorkflowObjectStep(kubeconfig *string, objName string, stepName string)
 	return nil
 }
 
-func SetStepToWorkflowObject(kubeconfig *string, stepName string, objName string) error {
+func SetStepToWorkflowObject(ctx context.Context, kubeconfig *string, stepName string, objName string) error {
 	status := "Running"
 	currentTime := time.Now().UTC().String()
+	executionID, parentID := newExecution(ctx)
 
 	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
 	if err != nil {
@@ -379,21 +416,25 @@ func SetStepToWorkflowObject(kubeconfig *string, stepName string, objName string
 	}
 
 	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		result, err := GetObj(kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
+		result, err := GetObj(ctx, kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
 		if err != nil {
 			return err
 		}
-		steps, found, err := unstructured.NestedSlice(result.Object, "spec", "steps")
-		if err != nil || !found || steps == nil {
-			message := fmt.Sprintf("steps not found or error in spec: %s", err)
-			return errors.New(message)
+		steps, err := getWorkflowObjectSteps(result)
+		if err != nil {
+			return err
 		}
+		run := getWorkflowObjectRun(result)
 		tempStep := map[string]interface{}{
-			"name":    stepName,
-			"startAt": currentTime,
-			"endAt":   "",
-			"message": "",
-			"status":  status,
+			"name":     stepName,
+			"startAt":  currentTime,
+			"endAt":    "",
+			"message":  "",
+			"status":   status,
+			"attempt":  nextStepAttempt(steps, stepName, run),
+			"run":      run,
+			"id":       executionID,
+			"parentId": parentID,
 		}
 		newSteps := append(steps, tempStep)
 
@@ -412,9 +453,10 @@ func SetStepToWorkflowObject(kubeconfig *string, stepName string, objName string
 	return nil
 }
 
-func SetPendingStepToWorkflowObject(kubeconfig *string, stepName string, objName string) error {
+func SetPendingStepToWorkflowObject(ctx context.Context, kubeconfig *string, stepName string, objName string) error {
 	status := "Pending"
 	currentTime := time.Now().UTC().String()
+	executionID, parentID := newExecution(ctx)
 
 	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
 	if err != nil {
@@ -426,21 +468,25 @@ func SetPendingStepToWorkflowObject(kubeconfig *string, stepName string, objName
 	}
 
 	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		result, err := GetObj(kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
+		result, err := GetObj(ctx, kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
 		if err != nil {
 			return err
 		}
-		steps, found, err := unstructured.NestedSlice(result.Object, "spec", "steps")
-		if err != nil || !found || steps == nil {
-			message := fmt.Sprintf("steps not found or error in spec: %s", err)
-			return errors.New(message)
+		steps, err := getWorkflowObjectSteps(result)
+		if err != nil {
+			return err
 		}
+		run := getWorkflowObjectRun(result)
 		tempStep := map[string]interface{}{
-			"name":    stepName,
-			"startAt": currentTime,
-			"endAt":   "",
-			"message": "",
-			"status":  status,
+			"name":     stepName,
+			"startAt":  currentTime,
+			"endAt":    "",
+			"message":  "",
+			"status":   status,
+			"attempt":  nextStepAttempt(steps, stepName, run),
+			"run":      run,
+			"id":       executionID,
+			"parentId": parentID,
 		}
 		newSteps := append(steps, tempStep)
 
@@ -459,7 +505,7 @@ func SetPendingStepToWorkflowObject(kubeconfig *string, stepName string, objName
 	return nil
 }
 
-func SetWorkflowObjectFlowData(kubeconfig *string, objName string, path string, value string) error {
+func SetWorkflowObjectFlowData(ctx context.Context, kubeconfig *string, objName string, path string, value string) error {
 	path = ParseFlowDataKey(path)
 
 	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
@@ -472,7 +518,7 @@ func SetWorkflowObjectFlowData(kubeconfig *string, objName string, path string,
 	}
 
 	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		result, err := GetObj(kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
+		result, err := GetObj(ctx, kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
 		if err != nil {
 			return err
 		}
@@ -508,77 +554,44 @@ func SetWorkflowObjectFlowData(kubeconfig *string, objName string, path string,
 	return nil
 }
 
-func SetWorkflowObjectStepToComplete(kubeconfig *string, objName string, stepName string, message string) error {
-	err := setWorkflowObjectStepStatus(kubeconfig, objName, stepName, "Complete", message)
+func SetWorkflowObjectStepToComplete(ctx context.Context, kubeconfig *string, objName string, stepName string, message string) error {
+	err := setWorkflowObjectStepStatus(ctx, kubeconfig, objName, stepName, "Complete", message)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func SetWorkflowObjectStepToRunning(kubeconfig *string, objName string, stepName string, message string) error {
-	err := setWorkflowObjectStepStatus(kubeconfig, objName, stepName, "Running", message)
+func SetWorkflowObjectStepToRunning(ctx context.Context, kubeconfig *string, objName string, stepName string, message string) error {
+	err := setWorkflowObjectStepStatus(ctx, kubeconfig, objName, stepName, "Running", message)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func SetWorkflowObjectStepToPending(kubeconfig *string, objName string, stepName string, message string) error {
-	err := setWorkflowObjectStepStatus(kubeconfig, objName, stepName, "Pending", message)
+func SetWorkflowObjectStepToPending(ctx context.Context, kubeconfig *string, objName string, stepName string, message string) error {
+	err := setWorkflowObjectStepStatus(ctx, kubeconfig, objName, stepName, "Pending", message)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func SetWorkflowObjectStepToFailure(kubeconfig *string, objName string, stepName string, message string) error {
-	err := setWorkflowObjectStepStatus(kubeconfig, objName, stepName, "Failure", message)
+func SetWorkflowObjectStepToFailure(ctx context.Context, kubeconfig *string, objName string, stepName string, message string) error {
+	err := setWorkflowObjectStepStatus(ctx, kubeconfig, objName, stepName, "Failure", message)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func setWorkflowObjectStepStatus(kubeconfig *string, objName string, stepName string, status string, message string) error {
-	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
-	if err != nil {
-		return err
-	}
-	client, err := dynamic.NewForConfig(config)
-	if err != nil {
-		return err
-	}
-
-	var index int
-
-	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		result, err := GetObj(kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
+func setWorkflowObjectStepStatus(ctx context.Context, kubeconfig *string, objName string, stepName string, status string, message string) error {
+	return updateWorkflowObject(ctx, kubeconfig, objName, func(obj *unstructured.Unstructured) error {
+		steps, index, err := getWorkflowObjectExecution(ctx, obj, objName, stepName)
 		if err != nil {
 			return err
 		}
-		steps, found, err := unstructured.NestedSlice(result.Object, "spec", "steps")
-		if err != nil || !found || steps == nil {
-			message := fmt.Sprintf("steps not found or error in spec: %s", err)
-			return errors.New(message)
-		}
-		for i, step := range steps {
-			getIndex := false
-			v := reflect.ValueOf(step)
-			if v.Kind() == reflect.Map {
-				for _, key := range v.MapKeys() {
-					stepValue := v.MapIndex(key).Interface()
-					if stepValue == stepName {
-						index = i
-						getIndex = true
-						break
-					}
-				}
-			}
-			if getIndex {
-				break
-			}
-		}
 		if err := unstructured.SetNestedField(steps[index].(map[string]interface{}), status, "status"); err != nil {
 			return err
 		}
@@ -596,61 +609,16 @@ func setWorkflowObjectStepStatus(kubeconfig *string, objName string, stepName st
 			}
 		}
 
-		if err := unstructured.SetNestedField(result.Object, steps, "spec", "steps"); err != nil {
-			return err
-		}
-
-		res := schema.GroupVersionResource{Group: WFGroup, Version: WFVersion, Resource: WFResource}
-
-		_, err = client.Resource(res).Namespace(WFNamespace).Update(result, metav1.UpdateOptions{})
-		return err
+		return unstructured.SetNestedField(obj.Object, steps, "spec", "steps")
 	})
-	if retryErr != nil {
-		return retryErr
-	}
-	return nil
-
 }
 
-func SetWorkflowObjectFailedStep(kubeconfig *string, objName string, stepName string, stepMessage string) error {
-	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
-	if err != nil {
-		return err
-	}
-	client, err := dynamic.NewForConfig(config)
-	if err != nil {
-		return err
-	}
-
-	var index int
-
-	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		result, err := GetObj(kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
+func SetWorkflowObjectFailedStep(ctx context.Context, kubeconfig *string, objName string, stepName string, stepMessage string) error {
+	return updateWorkflowObject(ctx, kubeconfig, objName, func(obj *unstructured.Unstructured) error {
+		steps, index, err := getWorkflowObjectExecution(ctx, obj, objName, stepName)
 		if err != nil {
 			return err
 		}
-		steps, found, err := unstructured.NestedSlice(result.Object, "spec", "steps")
-		if err != nil || !found || steps == nil {
-			message := fmt.Sprintf("steps not found or error in spec: %s", err)
-			return errors.New(message)
-		}
-		for i, step := range steps {
-			getIndex := false
-			v := reflect.ValueOf(step)
-			if v.Kind() == reflect.Map {
-				for _, key := range v.MapKeys() {
-					stepValue := v.MapIndex(key).Interface()
-					if stepValue == stepName {
-						index = i
-						getIndex = true
-						break
-					}
-				}
-			}
-			if getIndex {
-				break
-			}
-		}
 		if err := unstructured.SetNestedField(steps[index].(map[string]interface{}), stepMessage, "message"); err != nil {
 			return err
 		}
@@ -658,50 +626,58 @@ func SetWorkflowObjectFailedStep(kubeconfig *string, objName string, stepName st
 			return err
 		}
 
-		if err := unstructured.SetNestedField(result.Object, steps, "spec", "steps"); err != nil {
-			return err
-		}
-
-		res := schema.GroupVersionResource{Group: WFGroup, Version: WFVersion, Resource: WFResource}
-
-		_, err = client.Resource(res).Namespace(WFNamespace).Update(result, metav1.UpdateOptions{})
-		return err
+		return unstructured.SetNestedField(obj.Object, steps, "spec", "steps")
 	})
-	if retryErr != nil {
-		return retryErr
+}
+
+// get the latest attempt of every step, in the order the steps first ran.
+func latestStepAttempts(steps []interface{}) []map[string]interface{} {
+	var names []string
+	latest := make(map[string]map[string]interface{})
+	for _, s := range steps {
+		step, ok := s.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		// compensations run after the workflow has finished and are not part of it.
+		if compensates, _ := step["compensates"].(string); compensates != "" {
+			continue
+		}
+		name, _ := step["name"].(string)
+		if _, exist := latest[name]; !exist {
+			names = append(names, name)
+		}
+		latest[name] = step
 	}
-	return nil
+	var result []map[string]interface{}
+	for _, name := range names {
+		result = append(result, latest[name])
+	}
+	return result
 }
 
-func CheckAllStepStatus(kubeconfig *string, objName string) (string, string, error) {
+func CheckAllStepStatus(ctx context.Context, kubeconfig *string, objName string) (string, string, error) {
 	// return enum: allCompleteSuccess, hasRunning, allCompleteHasFailure, hasPending
 
 	var failureSteps []string
 
-	result, err := GetObj(kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
+	result, err := GetObj(ctx, kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
 	if err != nil {
 		return "", "", err
 	}
-	steps, found, err := unstructured.NestedSlice(result.Object, "spec", "steps")
-	if err != nil || !found || steps == nil {
-		message := fmt.Sprintf("steps not found or error in spec: %s", err)
-		return "", "", errors.New(message)
+	steps, err := getWorkflowObjectSteps(result)
+	if err != nil {
+		return "", "", err
+	}
+	deferredSteps, _, _ := unstructured.NestedStringSlice(result.Object, "spec", "deferredSteps")
+	if len(deferredSteps) > 0 {
+		return "hasPending", "", nil
 	}
 	hasFailure := false
-	for _, step := range steps {
-		stepName := ""
-		status := ""
-		v := reflect.ValueOf(step)
-		if v.Kind() == reflect.Map {
-			for _, key := range v.MapKeys() {
-				if key.Interface() == "status" {
-					status = v.MapIndex(key).Interface().(string)
-				}
-				if key.Interface() == "name" {
-					stepName = v.MapIndex(key).Interface().(string)
-				}
-			}
-		}
+	// earlier attempts of retried steps do not count.
+	for _, step := range latestStepAttempts(steps) {
+		stepName, _ := step["name"].(string)
+		status, _ := step["status"].(string)
 		switch status {
 		case "Running":
 			return "hasRunning", "", nil
@@ -709,6 +685,10 @@ func CheckAllStepStatus(kubeconfig *string, objName string) (string, string, err
 			return "hasPending", "", nil
 		case "Complete":
 		case "Failure":
+			// failures a hub has joined over do not fail the workflow.
+			if handled, _ := step["handled"].(bool); handled {
+				continue
+			}
 			hasFailure = true
 			failureSteps = append(failureSteps, stepName)
 		}
@@ -720,33 +700,21 @@ func CheckAllStepStatus(kubeconfig *string, objName string) (string, string, err
 	return "allCompleteSuccess", "", nil
 }
 
-func CheckAllStepStatusByList(kubeconfig *string, objName string, stepsList []string) (string, error) {
+func CheckAllStepStatusByList(ctx context.Context, kubeconfig *string, objName string, stepsList []string) (string, error) {
 	// return enum: all_success, all_failed
 	var statusList []string
 
-	result, err := GetObj(kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
+	result, err := GetObj(ctx, kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
 	if err != nil {
 		return "", err
 	}
-	steps, found, err := unstructured.NestedSlice(result.Object, "spec", "steps")
-	if err != nil || !found || steps == nil {
-		message := fmt.Sprintf("steps not found or error in spec: %s", err)
-		return "", errors.New(message)
+	steps, err := getWorkflowObjectSteps(result)
+	if err != nil {
+		return "", err
 	}
-	for _, step := range steps {
-		stepName := ""
-		status := ""
-		v := reflect.ValueOf(step)
-		if v.Kind() == reflect.Map {
-			for _, key := range v.MapKeys() {
-				if key.Interface() == "status" {
-					status = v.MapIndex(key).Interface().(string)
-				}
-				if key.Interface() == "name" {
-					stepName = v.MapIndex(key).Interface().(string)
-				}
-			}
-		}
+	for _, step := range latestStepAttempts(steps) {
+		stepName, _ := step["name"].(string)
+		status, _ := step["status"].(string)
 		for _, name := range stepsList {
 			if name == stepName {
 				statusList = append(statusList, status)
@@ -764,9 +732,9 @@ func CheckAllStepStatusByList(kubeconfig *string, objName string, stepsList []st
 	return "all_success", nil
 }
 
-func CheckIfWorkflowIsTriggered(kubeconfig *string, modelObjName string) (bool, error) {
+func CheckIfWorkflowIsTriggered(ctx context.Context, kubeconfig *string, modelObjName string) (bool, error) {
 	labelSelector := fmt.Sprintf("modelObjName=%s", modelObjName)
-	list, err := ListObj(kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, labelSelector)
+	list, err := ListObj(ctx, kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, labelSelector)
 	if err != nil {
 		return false, err
 	}
@@ -777,10 +745,10 @@ func CheckIfWorkflowIsTriggered(kubeconfig *string, modelObjName string) (bool,
 	}
 }
 
-func GetPendingWorkflowList(kubeconfig *string, modelObjName string, currentStep string) (*unstructured.UnstructuredList, error) {
+func GetPendingWorkflowList(ctx context.Context, kubeconfig *string, modelObjName string, currentStep string) (*unstructured.UnstructuredList, error) {
 	var errorReturn *unstructured.UnstructuredList
 	labelSelector := fmt.Sprintf("modelObjName=%s, %s=%s", modelObjName, currentStep, "Pending")
-	list, err := ListObj(kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, labelSelector)
+	list, err := ListObj(ctx, kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, labelSelector)
 	if err != nil {
 		return errorReturn, err
 	}
@@ -801,3 +769,1127 @@ func ParseFlowDataKey(path string) string {
 	}
 	return strings.Join(s[:], ".")
 }
+
+// errSkipUpdate is returned by a mutate function to leave the workflow object unchanged.
+var errSkipUpdate = errors.New("skip update")
+
+// update the workflow object in place, retrying on conflict.
+func updateWorkflowObject(ctx context.Context, kubeconfig *string, objName string, mutate func(obj *unstructured.Unstructured) error) error {
+	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	if err != nil {
+		return err
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		result, err := GetObj(ctx, kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
+		if err != nil {
+			return err
+		}
+		if err := mutate(result); err != nil {
+			if err == errSkipUpdate {
+				return nil
+			}
+			return err
+		}
+
+		res := schema.GroupVersionResource{Group: WFGroup, Version: WFVersion, Resource: WFResource}
+
+		_, err = client.Resource(res).Namespace(WFNamespace).Update(result, metav1.UpdateOptions{})
+		return err
+	})
+	if retryErr != nil {
+		return retryErr
+	}
+	return nil
+}
+
+// get the index of the latest step entry with the given name, or -1 if there is none.
+func getStepIndex(steps []interface{}, stepName string) int {
+	for i := len(steps) - 1; i >= 0; i-- {
+		m, ok := steps[i].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if name, _ := m["name"].(string); name == stepName {
+			return i
+		}
+	}
+	return -1
+}
+
+type executionKey struct{}
+
+type execution struct {
+	id       string
+	parentID string
+}
+
+// WithExecution returns a context that addresses a single execution of a step. A new step entry written with
+// it takes the execution ID and the parent execution ID, and updates of the step through it change that entry
+// rather than the latest entry of the step.
+func WithExecution(ctx context.Context, executionID string, parentID string) context.Context {
+	return context.WithValue(ctx, executionKey{}, execution{id: executionID, parentID: parentID})
+}
+
+// ExecutionID returns the ID of the step execution the context addresses, or an empty string.
+func ExecutionID(ctx context.Context) string {
+	e, _ := ctx.Value(executionKey{}).(execution)
+	return e.id
+}
+
+func GenerateExecutionID() string {
+	return strings.Replace(uuid.New().String(), "-", "", -1)
+}
+
+// get the execution ID and parent execution ID for a new step entry, generating an ID when the context
+// does not carry one.
+func newExecution(ctx context.Context) (string, string) {
+	e, _ := ctx.Value(executionKey{}).(execution)
+	if e.id == "" {
+		e.id = GenerateExecutionID()
+	}
+	return e.id, e.parentID
+}
+
+// get the step entries of a workflow object.
+func getWorkflowObjectSteps(obj *unstructured.Unstructured) ([]interface{}, error) {
+	steps, found, err := unstructured.NestedSlice(obj.Object, "spec", "steps")
+	if err != nil || !found || steps == nil {
+		message := fmt.Sprintf("steps not found or error in spec: %s", err)
+		return nil, errors.New(message)
+	}
+	return steps, nil
+}
+
+// get the step entries of a workflow object and the index of the entry of the step execution the context
+// addresses.
+func getWorkflowObjectExecution(ctx context.Context, obj *unstructured.Unstructured, objName string, stepName string) ([]interface{}, int, error) {
+	steps, err := getWorkflowObjectSteps(obj)
+	if err != nil {
+		return nil, -1, err
+	}
+	index := getExecutionIndex(ctx, steps, stepName)
+	if index < 0 {
+		message := fmt.Sprintf("step %s is not found in workflow object %s", stepName, objName)
+		return nil, -1, errors.New(message)
+	}
+	return steps, index, nil
+}
+
+// get the index of the step entry of the execution the context addresses, or -1 if the step has no entry with
+// that execution ID. Without an execution, it is the latest entry of the step.
+func getExecutionIndex(ctx context.Context, steps []interface{}, stepName string) int {
+	id := ExecutionID(ctx)
+	if id == "" {
+		return getStepIndex(steps, stepName)
+	}
+	for i := len(steps) - 1; i >= 0; i-- {
+		m, ok := steps[i].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if m["id"] == id && m["name"] == stepName {
+			return i
+		}
+	}
+	return -1
+}
+
+// get the step entries of a workflow object and the index of the entry a task token was issued to. The
+// execution the context addresses takes precedence; without one, the entry is found by its task token, so a
+// callback that does not name its execution still reaches the right one.
+func getTaskExecution(ctx context.Context, obj *unstructured.Unstructured, objName string, stepName string, taskToken string) ([]interface{}, int, error) {
+	if ExecutionID(ctx) != "" {
+		return getWorkflowObjectExecution(ctx, obj, objName, stepName)
+	}
+	steps, err := getWorkflowObjectSteps(obj)
+	if err != nil {
+		return nil, -1, err
+	}
+	index := getTaskIndex(steps, stepName, taskToken)
+	if index < 0 {
+		index = getStepIndex(steps, stepName)
+	}
+	if index < 0 {
+		message := fmt.Sprintf("step %s is not found in workflow object %s", stepName, objName)
+		return nil, -1, errors.New(message)
+	}
+	return steps, index, nil
+}
+
+// get the index of the latest entry of a step that holds the task token, or -1 if there is none.
+func getTaskIndex(steps []interface{}, stepName string, taskToken string) int {
+	for i := len(steps) - 1; i >= 0; i-- {
+		m, ok := steps[i].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if m["name"] == stepName && taskToken != "" && m["taskToken"] == taskToken {
+			return i
+		}
+	}
+	return -1
+}
+
+// get the run of a workflow object, the number of times it has been reopened by a retry or rerun.
+func getWorkflowObjectRun(obj *unstructured.Unstructured) int64 {
+	run, _, _ := unstructured.NestedInt64(obj.Object, "spec", "run")
+	return run
+}
+
+// get the attempt number for a new entry of a step in the given run of the workflow object. The first entry
+// of a step in a new run, after a retry or rerun reopened the object, starts a new attempt; any other entry
+// continues the attempt of the step's latest entry, so a duplicate or recovered execution of the step gets
+// the same idempotency key.
+func nextStepAttempt(steps []interface{}, stepName string, run int64) int64 {
+	index := getStepIndex(steps, stepName)
+	if index < 0 {
+		return 1
+	}
+	step := steps[index].(map[string]interface{})
+	attempt, found, _ := unstructured.NestedInt64(step, "attempt")
+	if !found {
+		attempt = 1
+	}
+	if stepRun, _, _ := unstructured.NestedInt64(step, "run"); stepRun < run {
+		return attempt + 1
+	}
+	return attempt
+}
+
+func GetWorkflowObjectStep(ctx context.Context, kubeconfig *string, objName string, stepName string) (map[string]interface{}, error) {
+	result, err := GetObj(ctx, kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
+	if err != nil {
+		return nil, err
+	}
+	steps, index, err := getWorkflowObjectExecution(ctx, result, objName, stepName)
+	if err != nil {
+		return nil, err
+	}
+	return steps[index].(map[string]interface{}), nil
+}
+
+// GetWorkflowObjectStepStatuses returns the status of the latest execution of every step the workflow object
+// has run.
+func GetWorkflowObjectStepStatuses(ctx context.Context, kubeconfig *string, objName string) (map[string]string, error) {
+	result, err := GetObj(ctx, kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
+	if err != nil {
+		return nil, err
+	}
+	steps, _, err := unstructured.NestedSlice(result.Object, "spec", "steps")
+	if err != nil {
+		message := fmt.Sprintf("error in steps of workflow object %s: %s", objName, err)
+		return nil, errors.New(message)
+	}
+	statuses := make(map[string]string)
+	for _, item := range steps {
+		step, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := step["name"].(string)
+		status, _ := step["status"].(string)
+		if name != "" {
+			statuses[name] = status
+		}
+	}
+	return statuses, nil
+}
+
+func SetWorkflowObjectStepTaskToken(ctx context.Context, kubeconfig *string, objName string, stepName string, taskToken string) error {
+	return updateWorkflowObject(ctx, kubeconfig, objName, func(obj *unstructured.Unstructured) error {
+		steps, index, err := getWorkflowObjectExecution(ctx, obj, objName, stepName)
+		if err != nil {
+			return err
+		}
+		if err := unstructured.SetNestedField(steps[index].(map[string]interface{}), taskToken, "taskToken"); err != nil {
+			return err
+		}
+		return unstructured.SetNestedField(obj.Object, steps, "spec", "steps")
+	})
+}
+
+// ClaimWorkflowObjectStepTaskToken consumes the task token of a running step, so that a
+// completion callback is accepted at most once. It returns the execution ID of the step entry.
+func ClaimWorkflowObjectStepTaskToken(ctx context.Context, kubeconfig *string, objName string, stepName string, taskToken string) (string, error) {
+	executionID := ""
+	err := updateWorkflowObject(ctx, kubeconfig, objName, func(obj *unstructured.Unstructured) error {
+		steps, index, err := getTaskExecution(ctx, obj, objName, stepName, taskToken)
+		if err != nil {
+			return err
+		}
+		step := steps[index].(map[string]interface{})
+		status, _ := step["status"].(string)
+		token, _ := step["taskToken"].(string)
+		if status != "Running" || token == "" || token != taskToken {
+			message := fmt.Sprintf("task token for step %s does not match a running step", stepName)
+			return errors.New(message)
+		}
+		if err := unstructured.SetNestedField(step, "", "taskToken"); err != nil {
+			return err
+		}
+		executionID, _ = step["id"].(string)
+		return unstructured.SetNestedField(obj.Object, steps, "spec", "steps")
+	})
+	if err != nil {
+		return "", err
+	}
+	return executionID, nil
+}
+
+func SetWorkflowObjectFlowDataValues(ctx context.Context, kubeconfig *string, objName string, values map[string]string) error {
+	return updateWorkflowObject(ctx, kubeconfig, objName, func(obj *unstructured.Unstructured) error {
+		flowData, found, err := unstructured.NestedString(obj.Object, "spec", "flowData")
+		if err != nil || !found || flowData == "" {
+			message := fmt.Sprintf("flowData not found or error in spec: %s", err)
+			return errors.New(message)
+		}
+		m, err := ConvertJsonStringToStringMap(flowData)
+		if err != nil {
+			return err
+		}
+		for path, value := range values {
+			m[ParseFlowDataKey(path)] = value
+		}
+		jsonString, err := ConvertMapToJsonString(m)
+		if err != nil {
+			return err
+		}
+		return unstructured.SetNestedField(obj.Object, jsonString, "spec", "flowData")
+	})
+}
+
+// GenerateIdempotencyKey derives a stable key for one attempt of a step, so that repeated invocations
+// of the same attempt can be deduplicated by the executor.
+func GenerateIdempotencyKey(objName string, stepName string, attempt int64) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%s/%d", objName, stepName, attempt)))
+	return hex.EncodeToString(sum[:16])
+}
+
+func GenerateTaskToken() string {
+	return strings.Replace(uuid.New().String(), "-", "", -1)
+}
+
+func SetWorkflowObjectStepFields(ctx context.Context, kubeconfig *string, objName string, stepName string, fields map[string]interface{}) error {
+	return updateWorkflowObject(ctx, kubeconfig, objName, func(obj *unstructured.Unstructured) error {
+		steps, index, err := getWorkflowObjectExecution(ctx, obj, objName, stepName)
+		if err != nil {
+			return err
+		}
+		for key, value := range fields {
+			if err := unstructured.SetNestedField(steps[index].(map[string]interface{}), value, key); err != nil {
+				return err
+			}
+		}
+		return unstructured.SetNestedField(obj.Object, steps, "spec", "steps")
+	})
+}
+
+// RecordWorkflowObjectStepHeartbeat stores the latest heartbeat of a running step. The task token must
+// match the one the step was started with.
+func RecordWorkflowObjectStepHeartbeat(ctx context.Context, kubeconfig *string, objName string, stepName string, taskToken string, progress int64, details string) error {
+	return updateWorkflowObject(ctx, kubeconfig, objName, func(obj *unstructured.Unstructured) error {
+		steps, index, err := getTaskExecution(ctx, obj, objName, stepName, taskToken)
+		if err != nil {
+			return err
+		}
+		step := steps[index].(map[string]interface{})
+		status, _ := step["status"].(string)
+		token, _ := step["taskToken"].(string)
+		if status != "Running" || token == "" || token != taskToken {
+			message := fmt.Sprintf("task token for step %s does not match a running step", stepName)
+			return errors.New(message)
+		}
+		heartbeat := map[string]interface{}{
+			"progress": progress,
+			"details":  details,
+			"at":       time.Now().UTC().Format(time.RFC3339),
+		}
+		if err := unstructured.SetNestedField(step, heartbeat, "heartbeat"); err != nil {
+			return err
+		}
+		return unstructured.SetNestedField(obj.Object, steps, "spec", "steps")
+	})
+}
+
+// CancelWorkflowObject moves a workflow object that has not finished yet to Cancelled. Running and pending
+// steps are marked Cancelled, their task tokens are revoked and pending step labels are removed. It returns
+// the steps that were running, so their executors can be told to abort.
+func CancelWorkflowObject(ctx context.Context, kubeconfig *string, objName string, reason string) ([]map[string]interface{}, error) {
+	var cancelledSteps []map[string]interface{}
+	err := updateWorkflowObject(ctx, kubeconfig, objName, func(obj *unstructured.Unstructured) error {
+		cancelledSteps = nil
+		status, _, _ := unstructured.NestedString(obj.Object, "spec", "status")
+		if status == "Complete" || status == "Failure" || status == "Cancelled" {
+			message := fmt.Sprintf("workflow object %s is already finished with status %s", objName, status)
+			return errors.New(message)
+		}
+		steps, err := getWorkflowObjectSteps(obj)
+		if err != nil {
+			return err
+		}
+		currentTime := time.Now().UTC().String()
+		for _, s := range steps {
+			step, ok := s.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			stepStatus, _, _ := unstructured.NestedString(step, "status")
+			if stepStatus != "Running" && stepStatus != "Pending" {
+				continue
+			}
+			if stepStatus == "Running" {
+				cancelledSteps = append(cancelledSteps, runtime.DeepCopyJSON(step))
+			}
+			step["status"] = "Cancelled"
+			step["endAt"] = currentTime
+			step["taskToken"] = ""
+		}
+		if err := unstructured.SetNestedField(obj.Object, steps, "spec", "steps"); err != nil {
+			return err
+		}
+		if err := unstructured.SetNestedField(obj.Object, "Cancelled", "spec", "status"); err != nil {
+			return err
+		}
+		if err := unstructured.SetNestedField(obj.Object, false, "spec", "paused"); err != nil {
+			return err
+		}
+		if err := unstructured.SetNestedStringSlice(obj.Object, []string{}, "spec", "deferredSteps"); err != nil {
+			return err
+		}
+		if err := unstructured.SetNestedField(obj.Object, reason, "spec", "message"); err != nil {
+			return err
+		}
+		labels := obj.GetLabels()
+		for key, value := range labels {
+			if value == "Pending" {
+				delete(labels, key)
+			}
+		}
+		obj.SetLabels(labels)
+		annotations := obj.GetAnnotations()
+		delete(annotations, CancelAnnotation)
+		obj.SetAnnotations(annotations)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cancelledSteps, nil
+}
+
+func RemoveWorkflowObjectAnnotation(ctx context.Context, kubeconfig *string, objName string, key string) error {
+	return updateWorkflowObject(ctx, kubeconfig, objName, func(obj *unstructured.Unstructured) error {
+		annotations := obj.GetAnnotations()
+		delete(annotations, key)
+		obj.SetAnnotations(annotations)
+		return nil
+	})
+}
+
+// PauseWorkflowObject suspends a workflow object that has not finished yet.
+func PauseWorkflowObject(ctx context.Context, kubeconfig *string, objName string) error {
+	return updateWorkflowObject(ctx, kubeconfig, objName, func(obj *unstructured.Unstructured) error {
+		status, _, _ := unstructured.NestedString(obj.Object, "spec", "status")
+		if status == "Complete" || status == "Failure" || status == "Cancelled" {
+			message := fmt.Sprintf("workflow object %s is already finished with status %s", objName, status)
+			return errors.New(message)
+		}
+		if err := unstructured.SetNestedField(obj.Object, true, "spec", "paused"); err != nil {
+			return err
+		}
+		return unstructured.SetNestedField(obj.Object, "Paused", "spec", "status")
+	})
+}
+
+// ResumeWorkflowObject moves a paused workflow object back to Running and returns the steps that were
+// deferred while it was paused.
+func ResumeWorkflowObject(ctx context.Context, kubeconfig *string, objName string) ([]string, error) {
+	var deferredSteps []string
+	err := updateWorkflowObject(ctx, kubeconfig, objName, func(obj *unstructured.Unstructured) error {
+		status, _, _ := unstructured.NestedString(obj.Object, "spec", "status")
+		if status != "Paused" {
+			message := fmt.Sprintf("workflow object %s is not paused", objName)
+			return errors.New(message)
+		}
+		deferredSteps, _, _ = unstructured.NestedStringSlice(obj.Object, "spec", "deferredSteps")
+		if err := unstructured.SetNestedField(obj.Object, false, "spec", "paused"); err != nil {
+			return err
+		}
+		if err := unstructured.SetNestedStringSlice(obj.Object, []string{}, "spec", "deferredSteps"); err != nil {
+			return err
+		}
+		return unstructured.SetNestedField(obj.Object, "Running", "spec", "status")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deferredSteps, nil
+}
+
+// DeferWorkflowObjectStepIfPaused records the step as deferred when the workflow object is paused. It
+// reports whether the step was deferred.
+func DeferWorkflowObjectStepIfPaused(ctx context.Context, kubeconfig *string, objName string, stepName string) (bool, error) {
+	deferred := false
+	err := updateWorkflowObject(ctx, kubeconfig, objName, func(obj *unstructured.Unstructured) error {
+		deferred = false
+		paused, _, _ := unstructured.NestedBool(obj.Object, "spec", "paused")
+		if !paused {
+			return errSkipUpdate
+		}
+		deferredSteps, _, _ := unstructured.NestedStringSlice(obj.Object, "spec", "deferredSteps")
+		deferred = true
+		return unstructured.SetNestedStringSlice(obj.Object, append(deferredSteps, stepName), "spec", "deferredSteps")
+	})
+	if err != nil {
+		return false, err
+	}
+	return deferred, nil
+}
+
+// ReopenWorkflowObject moves a finished workflow object back to Running so that steps can run again. When
+// failedStep is set, the object must have failed and the latest attempt of failedStep must be a failure. An
+// object whose compensation has started cannot be reopened.
+func ReopenWorkflowObject(ctx context.Context, kubeconfig *string, objName string, failedStep string) error {
+	return updateWorkflowObject(ctx, kubeconfig, objName, func(obj *unstructured.Unstructured) error {
+		if err := checkReopenable(obj, objName, failedStep); err != nil {
+			return err
+		}
+		if err := unstructured.SetNestedField(obj.Object, "Running", "spec", "status"); err != nil {
+			return err
+		}
+		// steps that run again in the new run start new attempts.
+		if err := unstructured.SetNestedField(obj.Object, getWorkflowObjectRun(obj)+1, "spec", "run"); err != nil {
+			return err
+		}
+		return unstructured.SetNestedField(obj.Object, "", "spec", "message")
+	})
+}
+
+// check that a workflow object can be reopened to retry failedStep, or to rerun it when failedStep is empty.
+// Once the compensation of a failed or cancelled object has started, the side effects of its completed steps
+// are being undone, so it cannot be resumed; it can only be cloned and run from the start.
+func checkReopenable(obj *unstructured.Unstructured, objName string, failedStep string) error {
+	status, _, _ := unstructured.NestedString(obj.Object, "spec", "status")
+	if compensation, _, _ := unstructured.NestedString(obj.Object, "spec", "compensation", "status"); compensation != "" {
+		message := fmt.Sprintf("workflow object %s has been compensated, clone it to run it again", objName)
+		return errors.New(message)
+	}
+	if failedStep == "" {
+		if status != "Complete" && status != "Failure" && status != "Cancelled" {
+			message := fmt.Sprintf("workflow object %s has status %s, only finished workflows can be rerun", objName, status)
+			return errors.New(message)
+		}
+		return nil
+	}
+	if status != "Failure" {
+		message := fmt.Sprintf("workflow object %s has status %s, only failed workflows can be retried", objName, status)
+		return errors.New(message)
+	}
+	steps, _, _ := unstructured.NestedSlice(obj.Object, "spec", "steps")
+	index := getStepIndex(steps, failedStep)
+	if index < 0 {
+		message := fmt.Sprintf("step %s is not found in workflow object %s", failedStep, objName)
+		return errors.New(message)
+	}
+	stepStatus, _, _ := unstructured.NestedString(steps[index].(map[string]interface{}), "status")
+	if stepStatus != "Failure" {
+		message := fmt.Sprintf("step %s has status %s, only failed steps can be retried", failedStep, stepStatus)
+		return errors.New(message)
+	}
+	return nil
+}
+
+// CloneWorkflowObject creates an empty workflow object for the same model object, workflow and workflow
+// definition as objName.
+func CloneWorkflowObject(ctx context.Context, kubeconfig *string, objName string, cloneName string) error {
+	source, err := GetObj(ctx, kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
+	if err != nil {
+		return err
+	}
+	labels := source.GetLabels()
+	definitionHash, _, _ := unstructured.NestedString(source.Object, "spec", "definitionHash")
+	obj := newWorkflowObject(cloneName, labels["modelObjName"], labels["workflowName"], labels[WorkflowVersionLabel], definitionHash)
+	if err := unstructured.SetNestedField(obj.Object, objName, "metadata", "labels", "clonedFrom"); err != nil {
+		return err
+	}
+	return CreateObject(ctx, kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, obj)
+}
+
+// ClaimWorkflowObjectPendingStep takes a pending manual step out of the pending state, so that it is resolved
+// at most once even when a signal and a model event arrive together. It returns the execution ID of the step
+// entry.
+func ClaimWorkflowObjectPendingStep(ctx context.Context, kubeconfig *string, objName string, stepName string) (string, error) {
+	executionID := ""
+	err := updateWorkflowObject(ctx, kubeconfig, objName, func(obj *unstructured.Unstructured) error {
+		paused, _, _ := unstructured.NestedBool(obj.Object, "spec", "paused")
+		if paused {
+			message := fmt.Sprintf("workflow object %s is paused", objName)
+			return errors.New(message)
+		}
+		steps, index, err := getWorkflowObjectExecution(ctx, obj, objName, stepName)
+		if err != nil {
+			return err
+		}
+		step := steps[index].(map[string]interface{})
+		status, _ := step["status"].(string)
+		if status != "Pending" || obj.GetLabels()[stepName] != "Pending" {
+			message := fmt.Sprintf("step %s is not pending", stepName)
+			return errors.New(message)
+		}
+		step["status"] = "Running"
+		if err := unstructured.SetNestedField(obj.Object, steps, "spec", "steps"); err != nil {
+			return err
+		}
+		labels := obj.GetLabels()
+		delete(labels, stepName)
+		obj.SetLabels(labels)
+		executionID, _ = step["id"].(string)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return executionID, nil
+}
+
+// RecordWorkflowObjectStepDecision adds an approver's decision to a pending approval step and lets decide
+// evaluate all decisions recorded so far. Once decide returns an outcome, the step leaves the pending state
+// with that outcome. A second decision of the same approver is ignored. It returns the outcome, if any, and the
+// execution ID of the step entry.
+func RecordWorkflowObjectStepDecision(ctx context.Context, kubeconfig *string, objName string, stepName string, decision map[string]interface{}, decide func(decisions []interface{}) string) (string, string, error) {
+	outcome := ""
+	executionID := ""
+	err := updateWorkflowObject(ctx, kubeconfig, objName, func(obj *unstructured.Unstructured) error {
+		outcome = ""
+		paused, _, _ := unstructured.NestedBool(obj.Object, "spec", "paused")
+		if paused {
+			message := fmt.Sprintf("workflow object %s is paused", objName)
+			return errors.New(message)
+		}
+		steps, index, err := getWorkflowObjectExecution(ctx, obj, objName, stepName)
+		if err != nil {
+			return err
+		}
+		step := steps[index].(map[string]interface{})
+		executionID, _ = step["id"].(string)
+		status, _ := step["status"].(string)
+		if status != "Pending" || obj.GetLabels()[stepName] != "Pending" {
+			message := fmt.Sprintf("step %s is not pending", stepName)
+			return errors.New(message)
+		}
+		decisions, _, _ := unstructured.NestedSlice(step, "decisions")
+		for _, d := range decisions {
+			if m, ok := d.(map[string]interface{}); ok && m["approver"] == decision["approver"] {
+				return errSkipUpdate
+			}
+		}
+		decisions = append(decisions, decision)
+		step["decisions"] = decisions
+		outcome = decide(decisions)
+		if outcome != "" {
+			step["outcome"] = outcome
+			step["status"] = "Running"
+			labels := obj.GetLabels()
+			delete(labels, stepName)
+			obj.SetLabels(labels)
+		}
+		return unstructured.SetNestedField(obj.Object, steps, "spec", "steps")
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return outcome, executionID, nil
+}
+
+// ClaimWorkflowObjectStepWakeUp marks a running wait step as woken up. Only the first caller for a given
+// wake time succeeds, so the in-process timer and the periodic sweep never both continue the workflow.
+func ClaimWorkflowObjectStepWakeUp(ctx context.Context, kubeconfig *string, objName string, stepName string, wakeAt string) error {
+	return updateWorkflowObject(ctx, kubeconfig, objName, func(obj *unstructured.Unstructured) error {
+		steps, index, err := getWorkflowObjectExecution(ctx, obj, objName, stepName)
+		if err != nil {
+			return err
+		}
+		step := steps[index].(map[string]interface{})
+		status, _ := step["status"].(string)
+		at, _ := step["wakeAt"].(string)
+		wokeAt, _ := step["wokeAt"].(string)
+		if status != "Running" || at == "" || at != wakeAt || wokeAt != "" {
+			message := fmt.Sprintf("step %s is not waiting until %s", stepName, wakeAt)
+			return errors.New(message)
+		}
+		step["wokeAt"] = time.Now().UTC().Format(time.RFC3339)
+		return unstructured.SetNestedField(obj.Object, steps, "spec", "steps")
+	})
+}
+
+// CreateChildWorkflowObject creates a workflow object started by a sub-workflow or map step. The child shares
+// the parent's model object, is labelled with the parent object, step, execution and item, if any, and is owned
+// by the parent so it is garbage collected with it.
+func CreateChildWorkflowObject(ctx context.Context, kubeconfig *string, childName string, parentName string, parentStep string, parentItem string, workflowName string, version string, definitionHash string, flowData map[string]string) error {
+	parent, err := GetObj(ctx, kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, parentName)
+	if err != nil {
+		return err
+	}
+	obj := newWorkflowObject(childName, parent.GetLabels()["modelObjName"], workflowName, version, definitionHash)
+	labels := obj.GetLabels()
+	labels[ParentWorkflowLabel] = parentName
+	labels[ParentStepLabel] = parentStep
+	if executionID := ExecutionID(ctx); executionID != "" {
+		labels[ParentExecutionLabel] = executionID
+	}
+	if parentItem != "" {
+		labels[ParentItemLabel] = parentItem
+	}
+	obj.SetLabels(labels)
+	isController := true
+	obj.SetOwnerReferences([]metav1.OwnerReference{{
+		APIVersion: parent.GetAPIVersion(),
+		Kind:       parent.GetKind(),
+		Name:       parent.GetName(),
+		UID:        parent.GetUID(),
+		Controller: &isController,
+	}})
+	jsonString, err := ConvertMapToJsonString(flowData)
+	if err != nil {
+		return err
+	}
+	if err := unstructured.SetNestedField(obj.Object, jsonString, "spec", "flowData"); err != nil {
+		return err
+	}
+	return CreateObject(ctx, kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, obj)
+}
+
+func GetWorkflowObjectFlowData(ctx context.Context, kubeconfig *string, objName string) (map[string]string, error) {
+	result, err := GetObj(ctx, kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
+	if err != nil {
+		return nil, err
+	}
+	flowData, found, err := unstructured.NestedString(result.Object, "spec", "flowData")
+	if err != nil || !found || flowData == "" {
+		message := fmt.Sprintf("flowData not found or error in spec: %s", err)
+		return nil, errors.New(message)
+	}
+	return ConvertJsonStringToStringMap(flowData)
+}
+
+// ClaimWorkflowObjectStepChild records the final status of the child workflow object of a running
+// sub-workflow step. Only the first caller succeeds, so the parent step is continued exactly once.
+func ClaimWorkflowObjectStepChild(ctx context.Context, kubeconfig *string, objName string, stepName string, childName string, childStatus string) error {
+	return updateWorkflowObject(ctx, kubeconfig, objName, func(obj *unstructured.Unstructured) error {
+		steps, index, err := getWorkflowObjectExecution(ctx, obj, objName, stepName)
+		if err != nil {
+			return err
+		}
+		step := steps[index].(map[string]interface{})
+		status, _ := step["status"].(string)
+		child, _ := step["childObjName"].(string)
+		recorded, _ := step["childStatus"].(string)
+		if status != "Running" || child != childName || recorded != "" {
+			message := fmt.Sprintf("step %s is not waiting for child %s", stepName, childName)
+			return errors.New(message)
+		}
+		step["childStatus"] = childStatus
+		return unstructured.SetNestedField(obj.Object, steps, "spec", "steps")
+	})
+}
+
+// StartWorkflowObjectMapItems records the items of a running map step, all Pending, and marks the first
+// maxParallelism of them Running. It returns the indexes of the items to start.
+func StartWorkflowObjectMapItems(ctx context.Context, kubeconfig *string, objName string, stepName string, items []string, maxParallelism int) ([]int, error) {
+	var started []int
+	err := updateWorkflowObject(ctx, kubeconfig, objName, func(obj *unstructured.Unstructured) error {
+		started = nil
+		steps, index, err := getWorkflowObjectExecution(ctx, obj, objName, stepName)
+		if err != nil {
+			return err
+		}
+		step := steps[index].(map[string]interface{})
+		status, _ := step["status"].(string)
+		if _, exist := step["items"]; status != "Running" || exist {
+			message := fmt.Sprintf("step %s has already started its items", stepName)
+			return errors.New(message)
+		}
+		var entries []interface{}
+		for i, item := range items {
+			itemStatus := "Pending"
+			if i < maxParallelism {
+				itemStatus = "Running"
+				started = append(started, i)
+			}
+			entries = append(entries, map[string]interface{}{
+				"index":  int64(i),
+				"item":   item,
+				"status": itemStatus,
+			})
+		}
+		step["items"] = entries
+		return unstructured.SetNestedField(obj.Object, steps, "spec", "steps")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return started, nil
+}
+
+func SetWorkflowObjectMapItemFields(ctx context.Context, kubeconfig *string, objName string, stepName string, itemIndex int, fields map[string]interface{}) error {
+	return updateWorkflowObject(ctx, kubeconfig, objName, func(obj *unstructured.Unstructured) error {
+		steps, item, err := getMapItem(ctx, obj, objName, stepName, itemIndex)
+		if err != nil {
+			return err
+		}
+		for key, value := range fields {
+			item[key] = value
+		}
+		return unstructured.SetNestedField(obj.Object, steps, "spec", "steps")
+	})
+}
+
+// FinishWorkflowObjectMapItem records the result of a running item of a running map step. Unless the item
+// failed, the next Pending item is marked Running and its index returned, or -1 when there is none. Only the
+// first caller for an item succeeds. It also returns all items of the step.
+func FinishWorkflowObjectMapItem(ctx context.Context, kubeconfig *string, objName string, stepName string, itemIndex int, status string, result string, message string) (int, []interface{}, error) {
+	next := -1
+	var items []interface{}
+	err := updateWorkflowObject(ctx, kubeconfig, objName, func(obj *unstructured.Unstructured) error {
+		next = -1
+		steps, item, err := getMapItem(ctx, obj, objName, stepName, itemIndex)
+		if err != nil {
+			return err
+		}
+		step := steps[getExecutionIndex(ctx, steps, stepName)].(map[string]interface{})
+		stepStatus, _ := step["status"].(string)
+		itemStatus, _ := item["status"].(string)
+		if stepStatus != "Running" || itemStatus != "Running" {
+			message := fmt.Sprintf("item %d of step %s is not running", itemIndex, stepName)
+			return errors.New(message)
+		}
+		item["status"] = status
+		item["result"] = result
+		item["message"] = message
+		item["taskToken"] = ""
+		items, _, _ = unstructured.NestedSlice(step, "items")
+		if status == "Complete" {
+			for i, entry := range items {
+				m, ok := entry.(map[string]interface{})
+				if ok && m["status"] == "Pending" {
+					m["status"] = "Running"
+					next = i
+					break
+				}
+			}
+		}
+		step["items"] = items
+		return unstructured.SetNestedField(obj.Object, steps, "spec", "steps")
+	})
+	if err != nil {
+		return -1, nil, err
+	}
+	return next, items, nil
+}
+
+// ClaimWorkflowObjectMapItemTaskToken consumes the task token of a running item of a running map step, so
+// that a completion callback for the item is accepted at most once. It returns the execution ID of the step
+// entry.
+func ClaimWorkflowObjectMapItemTaskToken(ctx context.Context, kubeconfig *string, objName string, stepName string, itemIndex int, taskToken string) (string, error) {
+	executionID := ""
+	err := updateWorkflowObject(ctx, kubeconfig, objName, func(obj *unstructured.Unstructured) error {
+		steps, item, err := getMapItem(ctx, obj, objName, stepName, itemIndex)
+		if err != nil {
+			return err
+		}
+		step := steps[getExecutionIndex(ctx, steps, stepName)].(map[string]interface{})
+		stepStatus, _ := step["status"].(string)
+		itemStatus, _ := item["status"].(string)
+		token, _ := item["taskToken"].(string)
+		if stepStatus != "Running" || itemStatus != "Running" || token == "" || token != taskToken {
+			message := fmt.Sprintf("task token for item %d of step %s does not match a running item", itemIndex, stepName)
+			return errors.New(message)
+		}
+		item["taskToken"] = ""
+		executionID, _ = step["id"].(string)
+		return unstructured.SetNestedField(obj.Object, steps, "spec", "steps")
+	})
+	if err != nil {
+		return "", err
+	}
+	return executionID, nil
+}
+
+// get the steps of a workflow object and the item entry of a map step in them.
+func getMapItem(ctx context.Context, obj *unstructured.Unstructured, objName string, stepName string, itemIndex int) ([]interface{}, map[string]interface{}, error) {
+	steps, index, err := getWorkflowObjectExecution(ctx, obj, objName, stepName)
+	if err != nil {
+		return nil, nil, err
+	}
+	items, _ := steps[index].(map[string]interface{})["items"].([]interface{})
+	if itemIndex < 0 || itemIndex >= len(items) {
+		message := fmt.Sprintf("item %d is not found in step %s", itemIndex, stepName)
+		return nil, nil, errors.New(message)
+	}
+	item, ok := items[itemIndex].(map[string]interface{})
+	if !ok {
+		message := fmt.Sprintf("item %d is not found in step %s", itemIndex, stepName)
+		return nil, nil, errors.New(message)
+	}
+	return steps, item, nil
+}
+
+// ClaimWorkflowObjectHub evaluates the join of a hub step over the latest attempts of its inputs. decide gets
+// the status of every input that has started and returns the outcome of the join, empty while it is still
+// open, and whether failed inputs are handled by the join. The first caller to get an outcome adds the hub
+// step to the workflow object as Running, with the executions of the inputs it joined, and receives the
+// outcome; later callers get an empty outcome, so the hub fires exactly once per run of its inputs.
+func ClaimWorkflowObjectHub(ctx context.Context, kubeconfig *string, objName string, hubName string, inputs []string, decide func(statuses map[string]string) (string, bool)) (string, error) {
+	outcome := ""
+	executionID, parentID := newExecution(ctx)
+	err := updateWorkflowObject(ctx, kubeconfig, objName, func(obj *unstructured.Unstructured) error {
+		outcome = ""
+		steps, err := getWorkflowObjectSteps(obj)
+		if err != nil {
+			return err
+		}
+		run := getWorkflowObjectRun(obj)
+		statuses := make(map[string]string)
+		var joined []interface{}
+		first := -1
+		for _, input := range inputs {
+			index := getStepIndex(steps, input)
+			if index < 0 {
+				continue
+			}
+			if first < 0 || index < first {
+				first = index
+			}
+			step := steps[index].(map[string]interface{})
+			statuses[input], _ = step["status"].(string)
+			if id, _ := step["id"].(string); id != "" {
+				joined = append(joined, id)
+			}
+		}
+		result, handled := decide(statuses)
+		changed := false
+		if handled {
+			for _, input := range inputs {
+				index := getStepIndex(steps, input)
+				if index < 0 {
+					continue
+				}
+				step := steps[index].(map[string]interface{})
+				if done, _ := step["handled"].(bool); step["status"] == "Failure" && !done {
+					step["handled"] = true
+					changed = true
+				}
+			}
+		}
+		fired := hubFired(steps, hubName, joined, first)
+		if result != "" && !fired {
+			outcome = result
+			steps = append(steps, map[string]interface{}{
+				"name":     hubName,
+				"startAt":  time.Now().UTC().String(),
+				"endAt":    "",
+				"message":  "",
+				"status":   "Running",
+				"attempt":  nextStepAttempt(steps, hubName, run),
+				"run":      run,
+				"id":       executionID,
+				"parentId": parentID,
+				"joined":   joined,
+			})
+			changed = true
+		}
+		if !changed {
+			return errSkipUpdate
+		}
+		return unstructured.SetNestedField(obj.Object, steps, "spec", "steps")
+	})
+	if err != nil {
+		return "", err
+	}
+	return outcome, nil
+}
+
+// check whether a hub has fired for the current run of its inputs: its latest entry joined one of the input
+// executions it would join now. Entries written without the joined executions fall back to their position,
+// the hub has fired if it was added after the earliest of its inputs.
+func hubFired(steps []interface{}, hubName string, joined []interface{}, first int) bool {
+	hubIndex := getStepIndex(steps, hubName)
+	if hubIndex < 0 {
+		return false
+	}
+	previous, found := steps[hubIndex].(map[string]interface{})["joined"].([]interface{})
+	if !found {
+		return hubIndex > first
+	}
+	for _, id := range previous {
+		for _, current := range joined {
+			if id == current {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ClaimWorkflowObjectCompensation starts the compensation of a finished workflow object. It returns the latest
+// attempts of the completed steps that compensable accepts, in completion order, and marks the compensation
+// Running. Only the first caller gets the steps; nothing is claimed when there is nothing to compensate.
+func ClaimWorkflowObjectCompensation(ctx context.Context, kubeconfig *string, objName string, compensable func(stepName string) bool) ([]map[string]interface{}, error) {
+	var completed []map[string]interface{}
+	err := updateWorkflowObject(ctx, kubeconfig, objName, func(obj *unstructured.Unstructured) error {
+		completed = nil
+		if status, _, _ := unstructured.NestedString(obj.Object, "spec", "compensation", "status"); status != "" {
+			return errSkipUpdate
+		}
+		// a retry or rerun has reopened the object before its compensation started.
+		if status, _, _ := unstructured.NestedString(obj.Object, "spec", "status"); status != "Failure" && status != "Cancelled" {
+			return errSkipUpdate
+		}
+		steps, err := getWorkflowObjectSteps(obj)
+		if err != nil {
+			return err
+		}
+		for _, step := range latestStepAttempts(steps) {
+			name, _ := step["name"].(string)
+			if step["status"] == "Complete" && compensable(name) {
+				completed = append(completed, step)
+			}
+		}
+		if len(completed) == 0 {
+			return errSkipUpdate
+		}
+		sort.SliceStable(completed, func(i, j int) bool {
+			return stepEndTime(completed[i]).Before(stepEndTime(completed[j]))
+		})
+		compensation := map[string]interface{}{"status": "Running", "message": ""}
+		return unstructured.SetNestedField(obj.Object, compensation, "spec", "compensation")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return completed, nil
+}
+
+// get the time a step entry finished at, as written by setWorkflowObjectStepStatus.
+func stepEndTime(step map[string]interface{}) time.Time {
+	endAt, _ := step["endAt"].(string)
+	t, _ := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", endAt)
+	return t
+}
+
+// AddWorkflowObjectCompensationStep adds a Running entry for a compensation step that undoes the given
+// execution of a step. It returns the attempt of the entry.
+func AddWorkflowObjectCompensationStep(ctx context.Context, kubeconfig *string, objName string, stepName string, compensates string, compensatesID string) (int64, error) {
+	var attempt int64
+	executionID, _ := newExecution(ctx)
+	err := updateWorkflowObject(ctx, kubeconfig, objName, func(obj *unstructured.Unstructured) error {
+		steps, err := getWorkflowObjectSteps(obj)
+		if err != nil {
+			return err
+		}
+		run := getWorkflowObjectRun(obj)
+		attempt = nextStepAttempt(steps, stepName, run)
+		// the compensation descends from the execution it undoes.
+		steps = append(steps, map[string]interface{}{
+			"name":        stepName,
+			"startAt":     time.Now().UTC().String(),
+			"endAt":       "",
+			"message":     "",
+			"status":      "Running",
+			"attempt":     attempt,
+			"run":         run,
+			"compensates": compensates,
+			"id":          executionID,
+			"parentId":    compensatesID,
+		})
+		return unstructured.SetNestedField(obj.Object, steps, "spec", "steps")
+	})
+	if err != nil {
+		return 0, err
+	}
+	return attempt, nil
+}
+
+func SetWorkflowObjectCompensationStatus(ctx context.Context, kubeconfig *string, objName string, status string, message string) error {
+	return updateWorkflowObject(ctx, kubeconfig, objName, func(obj *unstructured.Unstructured) error {
+		compensation := map[string]interface{}{"status": status, "message": message}
+		return unstructured.SetNestedField(obj.Object, compensation, "spec", "compensation")
+	})
+}
+
+// GetWorkflowObjectLoopIteration returns the iteration a loop step is in, or 0 when the loop is not running.
+func GetWorkflowObjectLoopIteration(ctx context.Context, kubeconfig *string, objName string, loopName string) (int64, error) {
+	result, err := GetObj(ctx, kubeconfig, WFNamespace, WFGroup, WFVersion, WFResource, objName)
+	if err != nil {
+		return 0, err
+	}
+	iteration, _, _ := unstructured.NestedInt64(result.Object, "spec", "loops", loopName)
+	return iteration, nil
+}
+
+// AdvanceWorkflowObjectLoop moves a loop step from one iteration to the next, or ends the loop when to is 0.
+// It fails when the loop is no longer in iteration from, so every iteration is started once.
+func AdvanceWorkflowObjectLoop(ctx context.Context, kubeconfig *string, objName string, loopName string, from int64, to int64) error {
+	return updateWorkflowObject(ctx, kubeconfig, objName, func(obj *unstructured.Unstructured) error {
+		iteration, _, _ := unstructured.NestedInt64(obj.Object, "spec", "loops", loopName)
+		if iteration != from {
+			message := fmt.Sprintf("loop %s is in iteration %d, not %d", loopName, iteration, from)
+			return errors.New(message)
+		}
+		if to == 0 {
+			unstructured.RemoveNestedField(obj.Object, "spec", "loops", loopName)
+			return nil
+		}
+		return unstructured.SetNestedField(obj.Object, to, "spec", "loops", loopName)
+	})
+}
+
+// SetWorkflowObjectStepLoopIteration records on the latest entry of a step the loop it runs in and the
+// current iteration of that loop.
+func SetWorkflowObjectStepLoopIteration(ctx context.Context, kubeconfig *string, objName string, stepName string, loopName string) error {
+	return updateWorkflowObject(ctx, kubeconfig, objName, func(obj *unstructured.Unstructured) error {
+		iteration, _, _ := unstructured.NestedInt64(obj.Object, "spec", "loops", loopName)
+		steps, index, err := getWorkflowObjectExecution(ctx, obj, objName, stepName)
+		if err != nil {
+			return err
+		}
+		step := steps[index].(map[string]interface{})
+		step["loop"] = loopName
+		step["iteration"] = iteration
+		return unstructured.SetNestedField(obj.Object, steps, "spec", "steps")
+	})
+}
+
+// SetWorkflowObjectOutcome records the end step a workflow object finished on, with its outcome and result.
+// Only the first end step is recorded; it returns whether this call recorded it.
+func SetWorkflowObjectOutcome(ctx context.Context, kubeconfig *string, objName string, endStep string, outcome string, result map[string]string) (bool, error) {
+	recorded := false
+	err := updateWorkflowObject(ctx, kubeconfig, objName, func(obj *unstructured.Unstructured) error {
+		recorded = false
+		if step, _, _ := unstructured.NestedString(obj.Object, "spec", "endStep"); step != "" {
+			return errSkipUpdate
+		}
+		if err := unstructured.SetNestedField(obj.Object, endStep, "spec", "endStep"); err != nil {
+			return err
+		}
+		if err := unstructured.SetNestedField(obj.Object, outcome, "spec", "outcome"); err != nil {
+			return err
+		}
+		if len(result) > 0 {
+			if err := unstructured.SetNestedStringMap(obj.Object, result, "spec", "result"); err != nil {
+				return err
+			}
+		}
+		recorded = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return recorded, nil
+}
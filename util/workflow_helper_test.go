@@ -0,0 +1,305 @@
+package util
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestSetWorkflowObjectStatus(t *testing.T) {
+	tests := []struct {
+		name         string
+		spec         map[string]interface{}
+		status       string
+		wantSkip     bool
+		wantStatus   string
+		wantPaused   bool
+		wantDeferred []string
+	}{
+		{
+			name:       "running",
+			spec:       map[string]interface{}{"status": ""},
+			status:     "Running",
+			wantStatus: "Running",
+		},
+		{
+			name:       "running keeps the pause",
+			spec:       map[string]interface{}{"status": "Paused", "paused": true},
+			status:     "Running",
+			wantSkip:   true,
+			wantStatus: "Paused",
+			wantPaused: true,
+		},
+		{
+			name:         "complete clears the pause",
+			spec:         map[string]interface{}{"status": "Paused", "paused": true},
+			status:       "Complete",
+			wantStatus:   "Complete",
+			wantDeferred: []string{},
+		},
+		{
+			name:         "failure drops deferred steps",
+			spec:         map[string]interface{}{"status": "Paused", "paused": true, "deferredSteps": []interface{}{"step2"}},
+			status:       "Failure",
+			wantStatus:   "Failure",
+			wantDeferred: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := &unstructured.Unstructured{Object: map[string]interface{}{"spec": tt.spec}}
+			err := setWorkflowObjectStatus(obj, tt.status)
+			if tt.wantSkip {
+				if err != errSkipUpdate {
+					t.Fatalf("got error %v, want errSkipUpdate", err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			status, _, _ := unstructured.NestedString(obj.Object, "spec", "status")
+			if status != tt.wantStatus {
+				t.Errorf("status %q, want %q", status, tt.wantStatus)
+			}
+			paused, _, _ := unstructured.NestedBool(obj.Object, "spec", "paused")
+			if paused != tt.wantPaused {
+				t.Errorf("paused %v, want %v", paused, tt.wantPaused)
+			}
+			deferred, _, _ := unstructured.NestedStringSlice(obj.Object, "spec", "deferredSteps")
+			if !reflect.DeepEqual(deferred, tt.wantDeferred) {
+				t.Errorf("deferred steps %v, want %v", deferred, tt.wantDeferred)
+			}
+		})
+	}
+}
+
+func TestNextStepAttempt(t *testing.T) {
+	steps := []interface{}{
+		map[string]interface{}{"name": "step1", "attempt": int64(1)},
+		map[string]interface{}{"name": "step2", "attempt": int64(1)},
+		map[string]interface{}{"name": "step2", "attempt": int64(2), "run": int64(1)},
+		map[string]interface{}{"name": "legacy"},
+	}
+	tests := []struct {
+		name     string
+		stepName string
+		run      int64
+		want     int64
+	}{
+		{name: "first entry", stepName: "step3", run: 0, want: 1},
+		{name: "first entry after a rerun", stepName: "step3", run: 2, want: 1},
+		{name: "duplicate in the same run", stepName: "step1", run: 0, want: 1},
+		{name: "retry in a new run", stepName: "step1", run: 1, want: 2},
+		{name: "duplicate of a retried step", stepName: "step2", run: 1, want: 2},
+		{name: "rerun of a retried step", stepName: "step2", run: 2, want: 3},
+		{name: "entry without attempt", stepName: "legacy", run: 0, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextStepAttempt(steps, tt.stepName, tt.run); got != tt.want {
+				t.Errorf("nextStepAttempt(%s, run %d) = %d, want %d", tt.stepName, tt.run, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetExecutionIndex(t *testing.T) {
+	steps := []interface{}{
+		map[string]interface{}{"name": "step1", "id": "a1"},
+		map[string]interface{}{"name": "step2", "id": "b1"},
+		map[string]interface{}{"name": "step1", "id": "a2"},
+		map[string]interface{}{"name": "legacy"},
+	}
+	tests := []struct {
+		name        string
+		stepName    string
+		executionID string
+		want        int
+	}{
+		{name: "latest without execution", stepName: "step1", want: 2},
+		{name: "exact execution", stepName: "step1", executionID: "a1", want: 0},
+		{name: "latest execution", stepName: "step1", executionID: "a2", want: 2},
+		{name: "execution of another step", stepName: "step1", executionID: "b1", want: -1},
+		{name: "unknown execution", stepName: "step1", executionID: "c1", want: -1},
+		{name: "entry without id", stepName: "legacy", executionID: "a1", want: -1},
+		{name: "unknown step", stepName: "step3", want: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.executionID != "" {
+				ctx = WithExecution(ctx, tt.executionID, "")
+			}
+			if got := getExecutionIndex(ctx, steps, tt.stepName); got != tt.want {
+				t.Errorf("getExecutionIndex(%s, %q) = %d, want %d", tt.stepName, tt.executionID, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetTaskIndex(t *testing.T) {
+	steps := []interface{}{
+		map[string]interface{}{"name": "step1", "taskToken": "t1"},
+		map[string]interface{}{"name": "step2", "taskToken": "t2"},
+		map[string]interface{}{"name": "step1", "taskToken": ""},
+	}
+	tests := []struct {
+		name      string
+		stepName  string
+		taskToken string
+		want      int
+	}{
+		{name: "older execution", stepName: "step1", taskToken: "t1", want: 0},
+		{name: "token of another step", stepName: "step1", taskToken: "t2", want: -1},
+		{name: "unknown token", stepName: "step1", taskToken: "t3", want: -1},
+		{name: "empty token", stepName: "step1", taskToken: "", want: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getTaskIndex(steps, tt.stepName, tt.taskToken); got != tt.want {
+				t.Errorf("getTaskIndex(%s, %q) = %d, want %d", tt.stepName, tt.taskToken, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHubFired(t *testing.T) {
+	tests := []struct {
+		name   string
+		steps  []interface{}
+		joined []interface{}
+		first  int
+		want   bool
+	}{
+		{
+			name:   "not fired yet",
+			steps:  []interface{}{map[string]interface{}{"name": "a", "id": "a1"}},
+			joined: []interface{}{"a1"},
+		},
+		{
+			name: "fired for the same inputs",
+			steps: []interface{}{
+				map[string]interface{}{"name": "a", "id": "a1"},
+				map[string]interface{}{"name": "b", "id": "b1"},
+				map[string]interface{}{"name": "hub", "id": "h1", "joined": []interface{}{"a1", "b1"}},
+			},
+			joined: []interface{}{"a1", "b1"},
+			want:   true,
+		},
+		{
+			name: "fired while an input runs again",
+			steps: []interface{}{
+				map[string]interface{}{"name": "hub", "id": "h1", "joined": []interface{}{"a1", "b1"}},
+				map[string]interface{}{"name": "a", "id": "a2"},
+			},
+			joined: []interface{}{"a2", "b1"},
+			want:   true,
+		},
+		{
+			name: "every input ran again",
+			steps: []interface{}{
+				map[string]interface{}{"name": "hub", "id": "h1", "joined": []interface{}{"a1", "b1"}},
+				map[string]interface{}{"name": "a", "id": "a2"},
+				map[string]interface{}{"name": "b", "id": "b2"},
+			},
+			joined: []interface{}{"a2", "b2"},
+			first:  1,
+		},
+		{
+			name: "entry without joined executions after its inputs",
+			steps: []interface{}{
+				map[string]interface{}{"name": "a"},
+				map[string]interface{}{"name": "hub"},
+			},
+			first: 0,
+			want:  true,
+		},
+		{
+			name: "entry without joined executions before its inputs",
+			steps: []interface{}{
+				map[string]interface{}{"name": "hub"},
+				map[string]interface{}{"name": "a"},
+			},
+			first: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hubFired(tt.steps, "hub", tt.joined, tt.first); got != tt.want {
+				t.Errorf("hubFired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateIdempotencyKey(t *testing.T) {
+	key := GenerateIdempotencyKey("wf-1", "step1", 1)
+	if len(key) != 32 {
+		t.Errorf("key %q has %d characters, want 32", key, len(key))
+	}
+	tests := []struct {
+		name    string
+		objName string
+		step    string
+		attempt int64
+		same    bool
+	}{
+		{name: "same execution", objName: "wf-1", step: "step1", attempt: 1, same: true},
+		{name: "other attempt", objName: "wf-1", step: "step1", attempt: 2},
+		{name: "other step", objName: "wf-1", step: "step2", attempt: 1},
+		{name: "other object", objName: "wf-2", step: "step1", attempt: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := GenerateIdempotencyKey(tt.objName, tt.step, tt.attempt)
+			if (got == key) != tt.same {
+				t.Errorf("GenerateIdempotencyKey(%s, %s, %d) = %s, same as %s: %v, want %v", tt.objName, tt.step, tt.attempt, got, key, got == key, tt.same)
+			}
+		})
+	}
+}
+
+func TestCheckReopenable(t *testing.T) {
+	failedSteps := []interface{}{
+		map[string]interface{}{"name": "step1", "status": "Complete"},
+		map[string]interface{}{"name": "step2", "status": "Failure"},
+	}
+	tests := []struct {
+		name         string
+		status       string
+		compensation string
+		failedStep   string
+		wantErr      string
+	}{
+		{name: "retry failed step", status: "Failure", failedStep: "step2"},
+		{name: "retry completed step", status: "Failure", failedStep: "step1", wantErr: "only failed steps can be retried"},
+		{name: "retry unknown step", status: "Failure", failedStep: "step3", wantErr: "step step3 is not found"},
+		{name: "retry running workflow", status: "Running", failedStep: "step2", wantErr: "only failed workflows can be retried"},
+		{name: "retry compensated workflow", status: "Failure", compensation: "Complete", failedStep: "step2", wantErr: "has been compensated"},
+		{name: "retry while compensating", status: "Failure", compensation: "Running", failedStep: "step2", wantErr: "has been compensated"},
+		{name: "rerun complete workflow", status: "Complete"},
+		{name: "rerun cancelled workflow", status: "Cancelled"},
+		{name: "rerun compensated workflow", status: "Cancelled", compensation: "Failure", wantErr: "has been compensated"},
+		{name: "rerun running workflow", status: "Running", wantErr: "only finished workflows can be rerun"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := map[string]interface{}{"status": tt.status, "steps": failedSteps}
+			if tt.compensation != "" {
+				spec["compensation"] = map[string]interface{}{"status": tt.compensation}
+			}
+			err := checkReopenable(&unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}, "wf-1", tt.failedStep)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"fmt"
 	workflowFramework "github.com/flintdev/workflow-engine/engine"
 	"github.com/flintdev/workflow-engine/workflow-example/workflows"
 	"github.com/flintdev/workflow-engine/workflow-example/workflows/workflow1"
 	"github.com/flintdev/workflow-engine/workflow-example/workflows/workflow2"
+	"os"
 )
 
 func main() {
@@ -12,5 +14,19 @@ func main() {
 	app.RegisterWorkflow(workflow1.Definition)
 	app.RegisterWorkflow(workflow2.Definition)
 	app.RegisterConfig(workflows.ParseConfig)
+	// "graph <workflow> [dot|mermaid]" prints a registered workflow instead of running the engine.
+	if len(os.Args) > 2 && os.Args[1] == "graph" {
+		format := workflowFramework.GraphDOT
+		if len(os.Args) > 3 {
+			format = os.Args[3]
+		}
+		graph, err := app.WorkflowGraph(os.Args[2], format, nil)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		fmt.Print(graph)
+		return
+	}
 	app.Start()
 }